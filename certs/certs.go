@@ -0,0 +1,145 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+// KeyPair is a certificate together with its private key
+type KeyPair struct {
+	Certificate *x509.Certificate
+	PrivateKey  *ecdsa.PrivateKey
+}
+
+// NewCA creates a self-signed certificate authority to issue certificates
+// with, e.g. for tests or a development setup
+func NewCA(commonName string, validFor time.Duration) (*KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return newKeyPair(template, nil, validFor)
+}
+
+// IssueServer creates a certificate for a server reachable at the hosts,
+// which are DNS names or IP addresses
+func (ca *KeyPair) IssueServer(commonName string, hosts []string, validFor time.Duration) (*KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return newKeyPair(template, ca, validFor)
+}
+
+// IssueClient creates a certificate a client authenticates with to a server
+// requiring mutual TLS
+func (ca *KeyPair) IssueClient(commonName string, validFor time.Duration) (*KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return newKeyPair(template, ca, validFor)
+}
+
+// newKeyPair generates a key and signs the certificate of the template with
+// the key of the parent, or with its own key when the parent is nil
+func newKeyPair(template *x509.Certificate, parent *KeyPair, validFor time.Duration) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate key: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template.SerialNumber = serialNumber
+	// a little slack for the clocks of the other machines
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.Add(validFor)
+
+	parentCert := template
+	signer := key
+	if parent != nil {
+		parentCert = parent.Certificate
+		signer = parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse certificate: %w", err)
+	}
+
+	return &KeyPair{Certificate: cert, PrivateKey: key}, nil
+}
+
+// CertPEM returns the PEM encoded certificate
+func (pair *KeyPair) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Certificate.Raw})
+}
+
+// KeyPEM returns the PEM encoded private key
+func (pair *KeyPair) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(pair.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TLSCertificate returns the key pair for a tls.Config
+func (pair *KeyPair) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{pair.Certificate.Raw},
+		PrivateKey:  pair.PrivateKey,
+		Leaf:        pair.Certificate,
+	}
+}
+
+// WriteFiles writes the PEM encoded certificate and private key. Only the
+// owner can read the key
+func (pair *KeyPair) WriteFiles(certFile string, keyFile string) error {
+	keyPEM, err := pair.KeyPEM()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(certFile, pair.CertPEM(), 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot write certificate: %w", err)
+	}
+
+	err = ioutil.WriteFile(keyFile, keyPEM, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot write private key: %w", err)
+	}
+	return nil
+}
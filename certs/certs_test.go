@@ -0,0 +1,119 @@
+package certs_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/certs"
+)
+
+// handshake connects a client to a server over TLS and returns the errors of both sides
+func handshake(serverConfig *tls.Config, clientConfig *tls.Config) (error, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn := tls.Server(serverConn, serverConfig)
+		err := conn.Handshake()
+		if err == nil {
+			// with TLS 1.3 the client learns that its certificate is
+			// rejected on its first read
+			_, err = conn.Write([]byte{1})
+		}
+		serverErr <- err
+		serverConn.Close()
+	}()
+
+	conn := tls.Client(clientConn, clientConfig)
+	err := conn.Handshake()
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+	}
+	clientConn.Close()
+
+	return <-serverErr, err
+}
+
+func TestConfigs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	ca, err := certs.NewCA("test CA", time.Hour)
+	require.NoError(t, err)
+	require.True(t, ca.Certificate.IsCA)
+
+	server, err := ca.IssueServer("test server", []string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []string{"localhost"}, server.Certificate.DNSNames)
+	require.Len(t, server.Certificate.IPAddresses, 1)
+
+	client, err := ca.IssueClient("test client", time.Hour)
+	require.NoError(t, err)
+
+	otherCA, err := certs.NewCA("other CA", time.Hour)
+	require.NoError(t, err)
+	otherClient, err := otherCA.IssueClient("other client", time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, ca.WriteFiles(path("ca-cert.pem"), path("ca-key.pem")))
+	require.NoError(t, server.WriteFiles(path("server-cert.pem"), path("server-key.pem")))
+	require.NoError(t, client.WriteFiles(path("client-cert.pem"), path("client-key.pem")))
+	require.NoError(t, otherCA.WriteFiles(path("other-ca-cert.pem"), path("other-ca-key.pem")))
+	require.NoError(t, otherClient.WriteFiles(path("other-client-cert.pem"), path("other-client-key.pem")))
+
+	serverConfig, err := certs.ServerConfig(path("server-cert.pem"), path("server-key.pem"), path("ca-cert.pem"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		caFile    string
+		certFile  string
+		keyFile   string
+		serverErr bool
+		clientErr bool
+	}{
+		{"mutual", "ca-cert.pem", "client-cert.pem", "client-key.pem", false, false},
+		{"no_client_cert", "ca-cert.pem", "", "", true, true},
+		{"untrusted_client", "ca-cert.pem", "other-client-cert.pem", "other-client-key.pem", true, true},
+		{"untrusted_server", "other-ca-cert.pem", "client-cert.pem", "client-key.pem", true, true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			certFile, keyFile := "", ""
+			if tc.certFile != "" {
+				certFile, keyFile = path(tc.certFile), path(tc.keyFile)
+			}
+			clientConfig, err := certs.ClientConfig(path(tc.caFile), certFile, keyFile)
+			require.NoError(t, err)
+			clientConfig.ServerName = "localhost"
+
+			serverErr, clientErr := handshake(serverConfig.Clone(), clientConfig)
+			require.Equal(t, tc.serverErr, serverErr != nil, "server error: %v", serverErr)
+			require.Equal(t, tc.clientErr, clientErr != nil, "client error: %v", clientErr)
+		})
+	}
+
+	_, err = certs.ClientConfig(path("ca-cert.pem"), path("client-cert.pem"), "")
+	require.Error(t, err)
+
+	err = ioutil.WriteFile(path("empty.pem"), nil, 0644)
+	require.NoError(t, err)
+	_, err = certs.ServerConfig(path("server-cert.pem"), path("server-key.pem"), path("empty.pem"))
+	require.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// APIKeyInterceptor adds an API key to the calls, for machine clients which
+// do not log in
+type APIKeyInterceptor struct {
+	apiKey string
+}
+
+func NewAPIKeyInterceptor(apiKey string) *APIKeyInterceptor {
+	return &APIKeyInterceptor{
+		apiKey: apiKey,
+	}
+}
+
+func (interceptor *APIKeyInterceptor) attachKey(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-api-key", interceptor.apiKey)
+}
+
+func (interceptor *APIKeyInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(interceptor.attachKey(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+func (interceptor *APIKeyInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(interceptor.attachKey(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"google.golang.org/grpc"
+)
+
+// AuthClient logs in to AuthService with the credentials of a user
+type AuthClient struct {
+	service  pb.AuthServiceClient
+	username string
+	password string
+}
+
+func NewAuthClient(cc *grpc.ClientConn, username string, password string) *AuthClient {
+	return &AuthClient{
+		service:  pb.NewAuthServiceClient(cc),
+		username: username,
+		password: password,
+	}
+}
+
+// Login returns a new access token together with its expiry time
+func (client *AuthClient) Login(ctx context.Context) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req := &pb.LoginRequest{
+		Username: client.username,
+		Password: client.password,
+	}
+
+	res, err := client.service.Login(ctx, req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return res.GetAccessToken(), res.GetExpiresAt().AsTime(), nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthInterceptor adds an access token to the calls. It logs in on the first
+// call and again when the token is about to expire. The AuthClient needs a
+// connection without the interceptor
+type AuthInterceptor struct {
+	authClient    *AuthClient
+	refreshMargin time.Duration
+
+	mutex       sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewAuthInterceptor refreshes the token refreshMargin before it expires
+func NewAuthInterceptor(authClient *AuthClient, refreshMargin time.Duration) *AuthInterceptor {
+	return &AuthInterceptor{
+		authClient:    authClient,
+		refreshMargin: refreshMargin,
+	}
+}
+
+// token returns the current access token, or a new one if it is about to
+// expire or if refresh is set
+func (interceptor *AuthInterceptor) token(ctx context.Context, refresh bool) (string, error) {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	if !refresh && interceptor.accessToken != "" && time.Until(interceptor.expiresAt) > interceptor.refreshMargin {
+		return interceptor.accessToken, nil
+	}
+
+	accessToken, expiresAt, err := interceptor.authClient.Login(ctx)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Logged in, the access token expires at %v", expiresAt)
+
+	interceptor.accessToken = accessToken
+	interceptor.expiresAt = expiresAt
+	return accessToken, nil
+}
+
+func (interceptor *AuthInterceptor) attachToken(ctx context.Context, refresh bool) (context.Context, error) {
+	accessToken, err := interceptor.token(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+accessToken), nil
+}
+
+// Unary retries a call rejected as unauthenticated once with a new token, in
+// case the token was rejected because of the clock of the server
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		authCtx, err := interceptor.attachToken(ctx, false)
+		if err != nil {
+			return err
+		}
+
+		err = invoker(authCtx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+
+		authCtx, err = interceptor.attachToken(ctx, true)
+		if err != nil {
+			return err
+		}
+		return invoker(authCtx, method, req, reply, cc, opts...)
+	}
+}
+
+func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		authCtx, err := interceptor.attachToken(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(authCtx, desc, cc, method, opts...)
+	}
+}
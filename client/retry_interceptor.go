@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterHeader is the trailer of the calls rejected by the rate limiter
+// of the server, in milliseconds
+const retryAfterHeader = "retry-after-ms"
+
+// RetryAfter returns how long the server asked to wait before calling again
+func RetryAfter(trailer metadata.MD) (time.Duration, bool) {
+	values := trailer.Get(retryAfterHeader)
+	if len(values) == 0 {
+		return 0, false
+	}
+	milliseconds, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || milliseconds < 0 {
+		return 0, false
+	}
+	return time.Duration(milliseconds) * time.Millisecond, true
+}
+
+// RetryInterceptor retries the calls rejected as resource exhausted after
+// the delay asked by the server, unless it ends after the deadline of the
+// call. Streams sending a single request, like SearchLaptop, are retried
+// until they receive a response. The other streams are not retried, since
+// their requests cannot be sent again
+type RetryInterceptor struct {
+	maxRetries int
+}
+
+func NewRetryInterceptor(maxRetries int) *RetryInterceptor {
+	return &RetryInterceptor{
+		maxRetries: maxRetries,
+	}
+}
+
+// wait sleeps before a retry of an error with the trailer, and reports
+// whether to retry
+func (interceptor *RetryInterceptor) wait(ctx context.Context, err error, trailer metadata.MD, retries int) bool {
+	if status.Code(err) != codes.ResourceExhausted || retries >= interceptor.maxRetries {
+		return false
+	}
+	delay, ok := RetryAfter(trailer)
+	if !ok {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	log.Printf("Rate limited, retrying in %v", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (interceptor *RetryInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		for retries := 0; ; retries++ {
+			var trailer metadata.MD
+			err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+			if err == nil || !interceptor.wait(ctx, err, trailer, retries) {
+				return err
+			}
+		}
+	}
+}
+
+func (interceptor *RetryInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || desc.ClientStreams {
+			return stream, err
+		}
+
+		retry := &retryStream{
+			ClientStream: stream,
+			interceptor:  interceptor,
+			ctx:          ctx,
+			open: func() (grpc.ClientStream, error) {
+				return streamer(ctx, desc, cc, method, opts...)
+			},
+		}
+		return retry, nil
+	}
+}
+
+// retryStream opens the stream again and sends its request when it fails
+// before the first response
+type retryStream struct {
+	grpc.ClientStream
+	interceptor *RetryInterceptor
+	// ctx is the context of the call, the one of the stream is canceled when it fails
+	ctx  context.Context
+	open func() (grpc.ClientStream, error)
+
+	req      interface{}
+	received bool
+	retries  int
+}
+
+func (stream *retryStream) SendMsg(m interface{}) error {
+	stream.req = m
+	return stream.ClientStream.SendMsg(m)
+}
+
+func (stream *retryStream) RecvMsg(m interface{}) error {
+	for {
+		err := stream.ClientStream.RecvMsg(m)
+		if err == nil {
+			stream.received = true
+			return nil
+		}
+		if stream.received || stream.req == nil {
+			return err
+		}
+		if !stream.interceptor.wait(stream.ctx, err, stream.ClientStream.Trailer(), stream.retries) {
+			return err
+		}
+		stream.retries++
+
+		other, err := stream.open()
+		if err != nil {
+			return err
+		}
+		err = other.SendMsg(stream.req)
+		if err != nil {
+			return err
+		}
+		err = other.CloseSend()
+		if err != nil {
+			return err
+		}
+		stream.ClientStream = other
+	}
+}
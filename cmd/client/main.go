@@ -9,13 +9,17 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"gitlab.com/keshavbhattad/pcbook/certs"
+	"gitlab.com/keshavbhattad/pcbook/client"
 	"gitlab.com/keshavbhattad/pcbook/pb"
 	"gitlab.com/keshavbhattad/pcbook/sample"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 )
 
@@ -47,27 +51,44 @@ func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*8)
 	defer cancel()
 
-	req := &pb.SearchLaptopRequest{Filter: filter}
-	stream, err := laptopClient.SearchLaptop(ctx, req)
-	if err != nil {
-		log.Fatal("Cannot search laptop: ", err)
+	req := &pb.SearchLaptopRequest{
+		Filter:   filter,
+		SortBy:   pb.SearchLaptopRequest_PRICE_INR,
+		PageSize: 5,
 	}
 
-	for {
-		res, err := stream.Recv()
-		if err == io.EOF {
-			return
-		}
+	for page := 1; ; page++ {
+		stream, err := laptopClient.SearchLaptop(ctx, req)
 		if err != nil {
-			log.Fatal("Cannot receive response: ", err)
+			log.Fatal("Cannot search laptop: ", err)
+		}
+
+		log.Printf("Page %d:", page)
+		req.PageToken = ""
+
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Fatal("Cannot receive response: ", err)
+			}
+
+			laptop := res.GetLaptop()
+			log.Print(" - found: ", laptop.GetId())
+			log.Print(" + brand: ", laptop.GetBrand())
+			log.Print(" + name: ", laptop.GetName())
+			log.Print(" + CPU cores: ", laptop.Cpu.GetNumberOfCores())
+			log.Print(" + CPU minimum GHz: ", laptop.Cpu.GetMinGhz())
+			log.Print(" + price: ", laptop.GetPriceInr())
+
+			req.PageToken = res.GetNextPageToken()
 		}
 
-		laptop := res.GetLaptop()
-		log.Print(" - found: ", laptop.GetId())
-		log.Print(" + brand: ", laptop.GetBrand())
-		log.Print(" + name: ", laptop.GetName())
-		log.Print(" + CPU cores: ", laptop.Cpu.GetNumberOfCores())
-		log.Print(" + CPU minimum GHz: ", laptop.Cpu.GetMinGhz())
+		if req.PageToken == "" {
+			return
+		}
 	}
 }
 
@@ -93,34 +114,95 @@ func testCreateLaptop(laptopClient pb.LaptopServiceClient) {
 	createLaptop(laptopClient, sample.NewLaptop())
 }
 
-func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath string) {
+// uploadAttempts is the number of times uploadImage tries to upload an image,
+// each attempt resuming from where the previous one stopped
+const uploadAttempts = 5
+
+func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath string) string {
 	file, err := os.Open(imagePath)
 	if err != nil {
 		log.Fatal("Cannot read the file: ", err)
-		return
 	}
 	defer file.Close()
 
+	uploadID := ""
+	for attempt := 1; ; attempt++ {
+		res, id, err := uploadImageFrom(laptopClient, laptopID, filepath.Ext(imagePath), file, uploadID)
+		if err == nil {
+			log.Printf("Image is uploaded with ID: %s and size: %d, deduplicated: %t", res.GetId(), res.GetSize(), res.GetDeduplicated())
+			return res.GetId()
+		}
+
+		code := status.Code(err)
+		retryable := code == codes.Unavailable || code == codes.DeadlineExceeded || code == codes.Aborted
+		if !retryable || attempt == uploadAttempts {
+			log.Fatal("Cannot upload image: ", err)
+		}
+
+		uploadID = id
+		log.Printf("Upload %s failed, resuming it: %v", uploadID, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// uploadImageFrom sends the image from the offset the server already has. It
+// returns the upload ID sent by the server, so that a failed upload can be
+// resumed
+func uploadImageFrom(
+	laptopClient pb.LaptopServiceClient,
+	laptopID string,
+	imageType string,
+	file *os.File,
+	uploadID string,
+) (*pb.UploadImageResponse, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	stream, err := laptopClient.UploadImage(ctx)
 	if err != nil {
-		log.Fatal("cannot upload image: ", err)
+		return nil, uploadID, err
 	}
 
 	req := &pb.UploadImageRequest{
 		Data: &pb.UploadImageRequest_Info{
 			Info: &pb.ImageInfo{
 				LaptopId:  laptopID,
-				ImageType: filepath.Ext(imagePath),
+				ImageType: imageType,
+				UploadId:  uploadID,
 			},
 		},
 	}
 
 	err = stream.Send(req)
 	if err != nil {
-		log.Fatal("Cannot send the image info: ", err)
+		_, err = stream.CloseAndRecv()
+		return nil, uploadID, err
+	}
+
+	header, err := stream.Header()
+	if err != nil {
+		return nil, uploadID, err
+	}
+	ids := header.Get("upload-id")
+	offsets := header.Get("upload-offset")
+	if len(ids) == 0 || len(offsets) == 0 {
+		// the server failed the upload without sending the header
+		_, err = stream.CloseAndRecv()
+		return nil, uploadID, err
+	}
+	uploadID = ids[0]
+
+	offset, err := strconv.ParseInt(offsets[0], 10, 64)
+	if err != nil {
+		return nil, uploadID, fmt.Errorf("Invalid upload offset: %w", err)
+	}
+	if offset > 0 {
+		log.Printf("Resuming upload %s at offset %d", uploadID, offset)
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, uploadID, fmt.Errorf("Cannot seek the image file: %w", err)
 	}
 
 	reader := bufio.NewReader(file)
@@ -132,31 +214,70 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 			break
 		}
 		if err != nil {
-			log.Fatal("Cannot read the image file: ", err)
+			return nil, uploadID, fmt.Errorf("Cannot read the image file: %w", err)
 		}
 
 		req := &pb.UploadImageRequest{
-			Data: &pb.UploadImageRequest_ChunkData{
-				ChunkData: buffer[:n],
+			Data: &pb.UploadImageRequest_Chunk{
+				Chunk: &pb.Chunk{
+					Data:   buffer[:n],
+					Offset: uint64(offset),
+				},
 			},
 		}
-		err = stream.Send(req)
 
+		err = stream.Send(req)
 		if err != nil {
-			err2 := stream.RecvMsg(nil)
-			log.Fatal("Cannot send the image file: ", err, err2)
+			// the status of the stream tells why it failed
+			_, err = stream.CloseAndRecv()
+			return nil, uploadID, err
 		}
+		offset += int64(n)
 	}
 
 	res, err := stream.CloseAndRecv()
+	return res, uploadID, err
+}
+
+func downloadImage(laptopClient pb.LaptopServiceClient, imageID string, variant string, imagePath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := laptopClient.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageID, Variant: variant})
 	if err != nil {
-		log.Fatal("Cannot receive the response: ", err)
+		log.Fatal("Cannot download image: ", err)
 	}
 
-	log.Printf("Image is uploaded with ID: %s and size: %d", res.GetId(), res.GetSize())
+	file, err := os.Create(imagePath)
+	if err != nil {
+		log.Fatal("Cannot create the file: ", err)
+	}
+	defer file.Close()
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Cannot receive the image: ", err)
+		}
+
+		if info := res.GetInfo(); info != nil {
+			log.Printf("Downloading image %s of laptop %s with size: %d", info.GetId(), info.GetLaptopId(), info.GetSize())
+			continue
+		}
+
+		_, err = file.Write(res.GetChunkData())
+		if err != nil {
+			log.Fatal("Cannot write the image file: ", err)
+		}
+	}
+
+	log.Printf("Image is downloaded to: %s", imagePath)
 }
 
-func rateLaptopClient(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores []float64) error {
+func rateLaptopClient(laptopClient pb.LaptopServiceClient, userID string, laptopIDs []string, scores []float64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -187,6 +308,7 @@ func rateLaptopClient(laptopClient pb.LaptopServiceClient, laptopIDs []string, s
 		req := &pb.RateLaptopRequest{
 			LaptopId: laptopID,
 			Score:    scores[i],
+			UserId:   userID,
 		}
 
 		err := stream.Send(req)
@@ -209,10 +331,19 @@ func rateLaptopClient(laptopClient pb.LaptopServiceClient, laptopIDs []string, s
 func testUploadImage(laptopClient pb.LaptopServiceClient) {
 	laptop := sample.NewLaptop()
 	createLaptop(laptopClient, laptop)
-	uploadImage(laptopClient, laptop.GetId(), "tmp/image.jpeg")
+	imageID := uploadImage(laptopClient, laptop.GetId(), "tmp/image.jpeg")
+
+	res, err := laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	if err != nil {
+		log.Fatal("Cannot list images: ", err)
+	}
+	log.Printf("Laptop %s has %d images", laptop.GetId(), len(res.GetImages()))
+
+	downloadImage(laptopClient, imageID, "", fmt.Sprintf("tmp/%s.jpeg", imageID))
+	downloadImage(laptopClient, imageID, "thumbnail", fmt.Sprintf("tmp/%s-thumbnail.jpeg", imageID))
 }
 
-func testRateLaptop(laptopClient pb.LaptopServiceClient) {
+func testRateLaptop(laptopClient pb.LaptopServiceClient, userID string) {
 	n := 3
 	laptopIDs := make([]string, n)
 
@@ -236,26 +367,109 @@ func testRateLaptop(laptopClient pb.LaptopServiceClient) {
 			scores[i] = sample.RandomLaptopScore()
 		}
 
-		err := rateLaptopClient(laptopClient, laptopIDs, scores)
+		err := rateLaptopClient(laptopClient, userID, laptopIDs, scores)
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		for _, laptopID := range laptopIDs {
+			getLaptopRating(laptopClient, laptopID)
+		}
+	}
+}
+
+func getLaptopRating(laptopClient pb.LaptopServiceClient, laptopID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := laptopClient.GetLaptopRating(ctx, &pb.GetLaptopRatingRequest{LaptopId: laptopID})
+	if err != nil {
+		log.Fatal("Cannot get laptop rating: ", err)
+	}
+
+	log.Printf(
+		"Laptop %s is rated by %d users: mean %.2f, median %.2f, standard deviation %.2f",
+		laptopID, res.GetCount(), res.GetMean(), res.GetMedian(), res.GetStdDev(),
+	)
+	for _, bucket := range res.GetBuckets() {
+		log.Printf("  score %v: %d", bucket.GetScore(), bucket.GetCount())
 	}
 }
 
+// tokenRefreshMargin is how long before its expiry the access token is refreshed
+const tokenRefreshMargin = 30 * time.Second
+
+// maxRetries is how many times a call rejected by the rate limiter is retried
+const maxRetries = 3
+
 func main() {
 	serverAddress := flag.String("address", "", "the server address")
+	userID := flag.String("user-id", "guest", "the user giving the ratings")
+	enableTLS := flag.Bool("tls", false, "connect over TLS, which the other tls flags imply")
+	tlsCA := flag.String("tls-ca", "", "the CA certificate file to verify the server certificate with, the system CAs are used when empty")
+	tlsCert := flag.String("tls-cert", "", "the client certificate file, for servers requiring mutual TLS")
+	tlsKey := flag.String("tls-key", "", "the client private key file")
+	username := flag.String("username", "", "the user to log in as, with the password in PCBOOK_PASSWORD. Calls are not authenticated when empty")
+	useAPIKey := flag.Bool("api-key", false, "authenticate with the API key in PCBOOK_API_KEY instead of logging in")
 	flag.Parse()
 	log.Printf("Dial server at address: %s", *serverAddress)
 
-	conn, err := grpc.Dial(*serverAddress, grpc.WithInsecure())
+	transportOption := grpc.WithInsecure()
+	if *enableTLS || *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		config, err := certs.ClientConfig(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal("Cannot load TLS credentials: ", err)
+		}
+		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(config))
+	}
+
+	// the retries come first, so that the retried calls get a new access token
+	retryInterceptor := client.NewRetryInterceptor(maxRetries)
+	dialOptions := []grpc.DialOption{
+		transportOption,
+		grpc.WithChainUnaryInterceptor(retryInterceptor.Unary()),
+		grpc.WithChainStreamInterceptor(retryInterceptor.Stream()),
+	}
+	if *useAPIKey {
+		if *username != "" {
+			log.Fatal("Cannot both log in and use an API key")
+		}
+		apiKey := os.Getenv("PCBOOK_API_KEY")
+		if apiKey == "" {
+			log.Fatal("PCBOOK_API_KEY is required to use an API key")
+		}
+
+		interceptor := client.NewAPIKeyInterceptor(apiKey)
+		dialOptions = append(
+			dialOptions,
+			grpc.WithChainUnaryInterceptor(interceptor.Unary()),
+			grpc.WithChainStreamInterceptor(interceptor.Stream()),
+		)
+	} else if *username != "" {
+		// the ratings are given by the logged in user
+		*userID = *username
+
+		authConn, err := grpc.Dial(*serverAddress, transportOption)
+		if err != nil {
+			log.Fatal("Cannot dial the server: ", err)
+		}
+
+		authClient := client.NewAuthClient(authConn, *username, os.Getenv("PCBOOK_PASSWORD"))
+		interceptor := client.NewAuthInterceptor(authClient, tokenRefreshMargin)
+		dialOptions = append(
+			dialOptions,
+			grpc.WithChainUnaryInterceptor(interceptor.Unary()),
+			grpc.WithChainStreamInterceptor(interceptor.Stream()),
+		)
+	}
+
+	conn, err := grpc.Dial(*serverAddress, dialOptions...)
 	if err != nil {
 		log.Fatal("Cannot dial the server: ", err)
 	}
 
 	laptopClient := pb.NewLaptopServiceClient(conn)
 	// testUploadImage(laptopClient)
-	testRateLaptop(laptopClient)
+	testRateLaptop(laptopClient, *userID)
 	// testSearchLaptop(laptopClient)
 }
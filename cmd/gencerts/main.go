@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/keshavbhattad/pcbook/certs"
+)
+
+// gencerts writes a throwaway certificate authority, together with a server
+// and a client certificate it signed, to try the server over TLS
+func main() {
+	outDir := flag.String("out-dir", "cert", "the directory to write the certificates and the keys to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "the DNS names and IP addresses of the server, separated by commas")
+	clientName := flag.String("client-name", "pcbook-client", "the common name of the client certificate")
+	validFor := flag.Duration("valid-for", 365*24*time.Hour, "how long the certificates are valid")
+	flag.Parse()
+
+	err := os.MkdirAll(*outDir, 0755)
+	if err != nil {
+		log.Fatal("Cannot create output directory: ", err)
+	}
+
+	ca, err := certs.NewCA("pcbook CA", *validFor)
+	if err != nil {
+		log.Fatal("Cannot create CA: ", err)
+	}
+
+	server, err := ca.IssueServer("pcbook-server", strings.Split(*hosts, ","), *validFor)
+	if err != nil {
+		log.Fatal("Cannot issue server certificate: ", err)
+	}
+
+	client, err := ca.IssueClient(*clientName, *validFor)
+	if err != nil {
+		log.Fatal("Cannot issue client certificate: ", err)
+	}
+
+	names := []string{"ca", "server", "client"}
+	for i, pair := range []*certs.KeyPair{ca, server, client} {
+		certFile := filepath.Join(*outDir, names[i]+"-cert.pem")
+		keyFile := filepath.Join(*outDir, names[i]+"-key.pem")
+
+		err := pair.WriteFiles(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Cannot write %s certificate: %v", names[i], err)
+		}
+		log.Printf("Wrote %s and %s", certFile, keyFile)
+	}
+}
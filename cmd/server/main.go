@@ -1,39 +1,434 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gitlab.com/keshavbhattad/pcbook/certs"
 	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/s3"
 	"gitlab.com/keshavbhattad/pcbook/service"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
+func newLaptopStore(storeType string, dataDir string) (service.LaptopStore, error) {
+	switch storeType {
+	case "memory":
+		return service.NewInMemoryLaptopStore(), nil
+	case "file":
+		return service.NewFileLaptopStore(dataDir, service.DefaultCompactThreshold)
+	case "bolt":
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, err
+		}
+		return service.NewBoltLaptopStore(filepath.Join(dataDir, "laptops.db"))
+	default:
+		return nil, fmt.Errorf("Unknown laptop store type: %s", storeType)
+	}
+}
+
+func newRatingStore(storeType string, dataDir string, prior service.RatingPrior) (service.RatingStore, error) {
+	switch storeType {
+	case "memory":
+		ratingStore := service.NewInMemoryRatingStore()
+		ratingStore.SetPrior(prior)
+		return ratingStore, nil
+	case "file":
+		ratingStore, err := service.NewFileRatingStore(dataDir, service.DefaultRatingSnapshotInterval)
+		if err != nil {
+			return nil, err
+		}
+		ratingStore.SetPrior(prior)
+		return ratingStore, nil
+	default:
+		return nil, fmt.Errorf("Unknown rating store type: %s", storeType)
+	}
+}
+
+// newReviewStore returns a review store of the same type as the rating store,
+// since the score of a review is also a rating
+func newReviewStore(storeType string, dataDir string) (service.ReviewStore, error) {
+	switch storeType {
+	case "memory":
+		return service.NewInMemoryReviewStore(), nil
+	case "file":
+		return service.NewFileReviewStore(dataDir)
+	default:
+		return nil, fmt.Errorf("Unknown review store type: %s", storeType)
+	}
+}
+
+func newImageStore(storeType string, imageDir string, s3Config s3.Config) (service.ImageStore, error) {
+	switch storeType {
+	case "disk":
+		imageStore, err := service.NewDiskImageStore(imageDir)
+		if err != nil {
+			return nil, err
+		}
+
+		report, err := imageStore.Reconcile()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range report.OrphanFiles {
+			log.Printf("Image file %s has no metadata", name)
+		}
+		for _, id := range report.MissingFiles {
+			log.Printf("Image %s has no file", id)
+		}
+		for _, name := range report.InvalidMetadata {
+			log.Printf("Image metadata %s cannot be read", name)
+		}
+		for _, id := range report.OrphanVariants {
+			log.Printf("Image variant %s has no image", id)
+		}
+
+		return imageStore, nil
+	case "s3":
+		client, err := s3.NewClient(s3Config)
+		if err != nil {
+			return nil, err
+		}
+		return service.NewS3ImageStore(client, service.DefaultS3PartSize), nil
+	default:
+		return nil, fmt.Errorf("Unknown image store type: %s", storeType)
+	}
+}
+
+// newServerOptions returns the options serving TLS with the certificate, or
+// none when it is empty
+func newServerOptions(certFile string, keyFile string, clientCAFile string) ([]grpc.ServerOption, error) {
+	if certFile == "" {
+		if keyFile != "" || clientCAFile != "" {
+			return nil, errors.New("TLS options require a server certificate")
+		}
+		log.Print("Serving without TLS")
+		return nil, nil
+	}
+
+	config, err := certs.ServerConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(config))}, nil
+}
+
+// serveMetrics serves the Prometheus metrics over HTTP
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	// the timeouts keep slow clients from holding connections open
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+
+	log.Printf("Serving metrics on %s/metrics", address)
+	err := server.ListenAndServe()
+	if err != nil {
+		log.Fatal("Cannot serve metrics: ", err)
+	}
+}
+
+const (
+	authServicePath   = "/keshavbhattad.pcbook.AuthService/"
+	laptopServicePath = "/keshavbhattad.pcbook.LaptopService/"
+	reviewServicePath = "/keshavbhattad.pcbook.ReviewService/"
+	apiKeyServicePath = "/keshavbhattad.pcbook.APIKeyService/"
+)
+
+// publicMethods returns the methods that can be called without logging in.
+// The methods that are neither public nor in accessibleRoles are denied
+func publicMethods() []string {
+	return []string{
+		authServicePath + "Login",
+		laptopServicePath + "GetLaptop",
+		laptopServicePath + "AggregateLaptops",
+		laptopServicePath + "ListImages",
+		laptopServicePath + "DownloadImage",
+		laptopServicePath + "GetLaptopRating",
+		laptopServicePath + "TopRatedLaptops",
+		reviewServicePath + "ListReviews",
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+	}
+}
+
+// accessibleRoles returns the roles allowed to call each method
+func accessibleRoles() map[string][]string {
+	return map[string][]string{
+		laptopServicePath + "CreateLaptop": {"admin"},
+		laptopServicePath + "UpdateLaptop": {"admin"},
+		laptopServicePath + "DeleteLaptop": {"admin"},
+		laptopServicePath + "UploadImage":  {"admin"},
+		laptopServicePath + "QueryUpload":  {"admin"},
+		laptopServicePath + "DeleteImage":  {"admin"},
+		laptopServicePath + "ListRatings":  {"admin"},
+		laptopServicePath + "SearchLaptop": {"admin", "user"},
+		laptopServicePath + "RateLaptop":   {"admin", "user"},
+		reviewServicePath + "SubmitReview": {"admin", "user"},
+		reviewServicePath + "VoteReview":   {"admin", "user"},
+		apiKeyServicePath + "CreateAPIKey": {"admin"},
+		apiKeyServicePath + "RevokeAPIKey": {"admin"},
+		apiKeyServicePath + "ListAPIKeys":  {"admin"},
+	}
+}
+
+// requiredScopes returns the scope an API key needs to call each method.
+// The other methods are not allowed to the keys, like ListRatings, as it
+// lists the ratings of every user
+func requiredScopes() map[string]string {
+	return map[string]string{
+		laptopServicePath + "GetLaptop":        service.ScopeReadCatalog,
+		laptopServicePath + "SearchLaptop":     service.ScopeReadCatalog,
+		laptopServicePath + "AggregateLaptops": service.ScopeReadCatalog,
+		laptopServicePath + "ListImages":       service.ScopeReadCatalog,
+		laptopServicePath + "DownloadImage":    service.ScopeReadCatalog,
+		laptopServicePath + "GetLaptopRating":  service.ScopeReadCatalog,
+		laptopServicePath + "TopRatedLaptops":  service.ScopeReadCatalog,
+		laptopServicePath + "CreateLaptop":     service.ScopeWriteCatalog,
+		laptopServicePath + "UpdateLaptop":     service.ScopeWriteCatalog,
+		laptopServicePath + "DeleteLaptop":     service.ScopeWriteCatalog,
+		laptopServicePath + "DeleteImage":      service.ScopeWriteCatalog,
+		laptopServicePath + "UploadImage":      service.ScopeUploadImages,
+		laptopServicePath + "QueryUpload":      service.ScopeUploadImages,
+		laptopServicePath + "RateLaptop":       service.ScopeRate,
+		reviewServicePath + "ListReviews":      service.ScopeReadCatalog,
+	}
+}
+
 func main() {
 	port := flag.Int("port", 0, "the server port")
+	storeType := flag.String("laptop-store", "memory", "the laptop store to use: memory, file or bolt")
+	dataDir := flag.String("data-dir", "data", "the directory where the file and bolt laptop stores and the file rating store keep their data")
+	ratingStoreType := flag.String("rating-store", "memory", "the rating and review store to use: memory, which only keeps the last ratings for ListRatings, or file")
+	imageVariants := flag.String("image-variants", "thumbnail=128x128,medium=640x640", "the resized variants made of every uploaded image, as name=WIDTHxHEIGHT separated by commas")
+	imageStoreType := flag.String("image-store", "disk", "the image store to use: disk or s3")
+	imageDir := flag.String("image-dir", "images", "the directory where the disk image store keeps the images")
+	s3Endpoint := flag.String("s3-endpoint", "https://s3.amazonaws.com", "the endpoint of the S3 compatible service of the s3 image store")
+	s3Region := flag.String("s3-region", "us-east-1", "the region of the s3 image store bucket")
+	s3Bucket := flag.String("s3-bucket", "", "the bucket of the s3 image store, credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Timeout := flag.Duration("s3-timeout", s3.DefaultTimeout, "how long a request to the S3 compatible service may take")
+	uploadDir := flag.String("upload-dir", "uploads", "the directory where uploads in progress are kept until they are complete")
+	uploadTTL := flag.Duration("upload-ttl", service.DefaultUploadTTL, "how long an upload receiving no data is kept before it is removed")
+	maxImageSize := flag.Int("max-image-size", service.DefaultMaxImageSize, "the largest image size in bytes accepted by UploadImage")
+	minScore := flag.Float64("min-score", service.DefaultMinScore, "the lowest score accepted by RateLaptop")
+	maxScore := flag.Float64("max-score", service.DefaultMaxScore, "the highest score accepted by RateLaptop")
+	scoreStep := flag.Float64("score-step", service.DefaultScoreStep, "the step of the scores accepted by RateLaptop from the lowest score")
+	priorCount := flag.Float64("rating-prior-count", service.DefaultRatingPrior.Count, "the number of scores in the middle of the score range added to the ratings of TopRatedLaptops")
+	tlsCert := flag.String("tls-cert", "", "the server certificate file, the server serves plaintext when empty")
+	tlsKey := flag.String("tls-key", "", "the server private key file")
+	tlsClientCA := flag.String("tls-client-ca", "", "the CA certificate file to verify client certificates with, for mutual TLS")
+	usersFile := flag.String("users-file", "", "the users who can log in, as username:bcrypt-hash:role lines, the key signing the access tokens is read from JWT_SECRET. Calls are not authenticated when empty")
+	apiKeyFile := flag.String("api-key-file", "api-keys.json", "the file keeping the hashes of the API keys created with APIKeyService")
+	tokenDuration := flag.Duration("token-duration", 15*time.Minute, "how long the access tokens are valid")
+	rateLimits := flag.String("rate-limits", "SearchLaptop=5:20,RateLaptop=50:200", "the calls per second and the burst allowed to each client, as method=RATE:BURST separated by commas, * for the other methods. Each message of a client stream counts as a call")
+	maxStreams := flag.Int("max-streams", 16, "the largest number of streams a client can have open at once, 0 for no limit")
+	metricsAddress := flag.String("metrics-address", "localhost:9090", "the address of the HTTP server of the Prometheus metrics at /metrics, which has no authentication, disabled when empty")
 	flag.Parse()
+
+	if !(*priorCount >= 0) {
+		log.Fatalf("Invalid rating prior count: %v", *priorCount)
+	}
+
+	s3Config := s3.Config{
+		Endpoint: *s3Endpoint,
+		Region:   *s3Region,
+		Bucket:   *s3Bucket,
+		Credentials: s3.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+		Timeout: *s3Timeout,
+	}
+
+	variants, err := service.ParseImageVariants(*imageVariants)
+	if err != nil {
+		log.Fatal("Cannot parse image variants: ", err)
+	}
+
+	limits, err := service.ParseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatal("Cannot parse rate limits: ", err)
+	}
+	if *uploadTTL <= 0 {
+		log.Fatalf("Invalid upload TTL: %v", *uploadTTL)
+	}
+	if *maxStreams < 0 {
+		log.Fatalf("Invalid max streams: %d", *maxStreams)
+	}
+
 	log.Printf("Starting server on port %d", *port)
 
-	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("images")
-	ratingStore := service.NewInMemoryRatingStore()
+	laptopStore, err := newLaptopStore(*storeType, *dataDir)
+	if err != nil {
+		log.Fatal("Cannot create laptop store: ", err)
+	}
+	imageStore, err := newImageStore(*imageStoreType, *imageDir, s3Config)
+	if err != nil {
+		log.Fatal("Cannot create image store: ", err)
+	}
+
+	uploadStore, err := service.NewDiskUploadStore(*uploadDir)
+	if err != nil {
+		log.Fatal("Cannot create upload store: ", err)
+	}
+	go service.ExpireUploads(context.Background(), uploadStore, *uploadTTL, *uploadTTL/10)
+
+	prior := service.RatingPrior{Mean: (*minScore + *maxScore) / 2, Count: *priorCount}
+	ratingStore, err := newRatingStore(*ratingStoreType, *dataDir, prior)
+	if err != nil {
+		log.Fatal("Cannot create rating store: ", err)
+	}
 
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
-	grpcServer := grpc.NewServer()
+	laptopServer.SetMaxImageSize(*maxImageSize)
+	laptopServer.SetImageVariants(variants)
+	laptopServer.SetUploadStore(uploadStore)
+	err = laptopServer.SetScoreRange(*minScore, *maxScore, *scoreStep)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reviewStore, err := newReviewStore(*ratingStoreType, *dataDir)
+	if err != nil {
+		log.Fatal("Cannot create review store: ", err)
+	}
+
+	reviewServer := service.NewReviewServer(reviewStore, laptopStore, ratingStore)
+	err = reviewServer.SetScoreRange(*minScore, *maxScore, *scoreStep)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serverOptions, err := newServerOptions(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		log.Fatal("Cannot load TLS credentials: ", err)
+	}
+
+	if *metricsAddress != "" {
+		err = service.RegisterStoreMetrics(prometheus.DefaultRegisterer, laptopStore, imageStore, ratingStore)
+		if err != nil {
+			log.Fatal("Cannot register store metrics: ", err)
+		}
+
+		// the metrics come first, to count the calls rejected by the other interceptors
+		metrics := service.NewMetrics(prometheus.DefaultRegisterer)
+		serverOptions = append(
+			serverOptions,
+			grpc.ChainUnaryInterceptor(metrics.Unary()),
+			grpc.ChainStreamInterceptor(metrics.Stream()),
+		)
+	}
+
+	var authServer *service.AuthServer
+	var apiKeyServer *service.APIKeyServer
+	if *usersFile != "" {
+		jwtSecret := os.Getenv("JWT_SECRET")
+		if jwtSecret == "" {
+			log.Fatal("JWT_SECRET is required to authenticate the calls")
+		}
+
+		userStore := service.NewInMemoryUserStore()
+		err = service.LoadUserFile(userStore, *usersFile)
+		if err != nil {
+			log.Fatal("Cannot load users: ", err)
+		}
+
+		jwtManager := service.NewJWTManager(jwtSecret, *tokenDuration)
+		authServer = service.NewAuthServer(userStore, jwtManager)
+
+		apiKeyStore, err := service.NewFileAPIKeyStore(*apiKeyFile)
+		if err != nil {
+			log.Fatal("Cannot load API keys: ", err)
+		}
+		apiKeyServer = service.NewAPIKeyServer(apiKeyStore)
+
+		interceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles(), publicMethods())
+		interceptor.SetAPIKeys(apiKeyStore, requiredScopes())
+		serverOptions = append(
+			serverOptions,
+			grpc.ChainUnaryInterceptor(interceptor.Unary()),
+			grpc.ChainStreamInterceptor(interceptor.Stream()),
+		)
+	} else {
+		log.Print("Serving without authentication")
+	}
+
+	// the rate limiter comes after the auth interceptor, which identifies the clients
+	rateLimiter := service.NewRateLimiter(limits, *maxStreams)
+	serverOptions = append(
+		serverOptions,
+		grpc.ChainUnaryInterceptor(rateLimiter.Unary()),
+		grpc.ChainStreamInterceptor(rateLimiter.Stream()),
+	)
+
+	grpcServer := grpc.NewServer(serverOptions...)
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterReviewServiceServer(grpcServer, reviewServer)
+	if authServer != nil {
+		pb.RegisterAuthServiceServer(grpcServer, authServer)
+		pb.RegisterAPIKeyServiceServer(grpcServer, apiKeyServer)
+	}
 	reflection.Register(grpcServer)
 
+	if *metricsAddress != "" {
+		go serveMetrics(*metricsAddress)
+	}
+
 	address := fmt.Sprintf("0.0.0.0:%d", *port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		log.Fatal("Cannot start the server: ", err)
 	}
 
+	// the stores are closed once the calls in progress are done, so that the
+	// file stores flush what they keep in memory
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		log.Print("Stopping the server")
+		grpcServer.GracefulStop()
+	}()
+
 	err = grpcServer.Serve(listener)
 	if err != nil {
 		log.Fatal("Cannot start server: ", err)
 	}
+
+	closeStore("rating", ratingStore)
+	closeStore("review", reviewStore)
+	closeStore("laptop", laptopStore)
+}
+
+// closeStore closes the store if it keeps files open
+func closeStore(name string, store interface{}) {
+	closer, ok := store.(io.Closer)
+	if !ok {
+		return
+	}
+	err := closer.Close()
+	if err != nil {
+		log.Printf("Cannot close %s store: %v", name, err)
+	}
 }
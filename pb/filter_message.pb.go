@@ -9,6 +9,7 @@ package pb
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	wrapperspb "google.golang.org/protobuf/types/known/wrapperspb"
 	reflect "reflect"
 	sync "sync"
 )
@@ -20,15 +21,46 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Numeric bounds left at zero and empty lists do not restrict the search:
+// zero means unset, so a filter cannot ask for a maximum of zero, e.g.
+// max_price_inr = 0 does not mean free laptops. Use the query of
+// SearchLaptopRequest for such bounds, e.g. "price_inr<=0".
+// Memory bounds are compared after converting both sides to the same unit.
 type Filter struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	MaxPriceInr float64 `protobuf:"fixed64,1,opt,name=max_price_inr,json=maxPriceInr,proto3" json:"max_price_inr,omitempty"`
-	MinCpuCores uint32  `protobuf:"varint,2,opt,name=min_cpu_cores,json=minCpuCores,proto3" json:"min_cpu_cores,omitempty"`
-	MinCpuGhz   float64 `protobuf:"fixed64,3,opt,name=min_cpu_ghz,json=minCpuGhz,proto3" json:"min_cpu_ghz,omitempty"`
-	MinRam      *Memory `protobuf:"bytes,4,opt,name=min_ram,json=minRam,proto3" json:"min_ram,omitempty"`
+	MaxPriceInr    float64  `protobuf:"fixed64,1,opt,name=max_price_inr,json=maxPriceInr,proto3" json:"max_price_inr,omitempty"`
+	MinCpuCores    uint32   `protobuf:"varint,2,opt,name=min_cpu_cores,json=minCpuCores,proto3" json:"min_cpu_cores,omitempty"`
+	MinCpuGhz      float64  `protobuf:"fixed64,3,opt,name=min_cpu_ghz,json=minCpuGhz,proto3" json:"min_cpu_ghz,omitempty"`
+	MinRam         *Memory  `protobuf:"bytes,4,opt,name=min_ram,json=minRam,proto3" json:"min_ram,omitempty"`
+	Brands         []string `protobuf:"bytes,5,rep,name=brands,proto3" json:"brands,omitempty"`
+	MinPriceInr    float64  `protobuf:"fixed64,6,opt,name=min_price_inr,json=minPriceInr,proto3" json:"min_price_inr,omitempty"`
+	MinReleaseYear uint32   `protobuf:"varint,7,opt,name=min_release_year,json=minReleaseYear,proto3" json:"min_release_year,omitempty"`
+	MaxReleaseYear uint32   `protobuf:"varint,8,opt,name=max_release_year,json=maxReleaseYear,proto3" json:"max_release_year,omitempty"`
+	CpuBrands      []string `protobuf:"bytes,9,rep,name=cpu_brands,json=cpuBrands,proto3" json:"cpu_brands,omitempty"`
+	MaxCpuCores    uint32   `protobuf:"varint,10,opt,name=max_cpu_cores,json=maxCpuCores,proto3" json:"max_cpu_cores,omitempty"`
+	MaxCpuGhz      float64  `protobuf:"fixed64,11,opt,name=max_cpu_ghz,json=maxCpuGhz,proto3" json:"max_cpu_ghz,omitempty"`
+	MaxRam         *Memory  `protobuf:"bytes,12,opt,name=max_ram,json=maxRam,proto3" json:"max_ram,omitempty"`
+	// A laptop matches when at least one of its GPUs has the brand and memory.
+	GpuBrands    []string `protobuf:"bytes,13,rep,name=gpu_brands,json=gpuBrands,proto3" json:"gpu_brands,omitempty"`
+	MinGpuMemory *Memory  `protobuf:"bytes,14,opt,name=min_gpu_memory,json=minGpuMemory,proto3" json:"min_gpu_memory,omitempty"`
+	// Drivers match any storage of the laptop, capacity is the sum of all of them.
+	StorageDrivers      []Storage_Driver      `protobuf:"varint,15,rep,packed,name=storage_drivers,json=storageDrivers,proto3,enum=keshavbhattad.pcbook.Storage_Driver" json:"storage_drivers,omitempty"`
+	MinStorage          *Memory               `protobuf:"bytes,16,opt,name=min_storage,json=minStorage,proto3" json:"min_storage,omitempty"`
+	MaxStorage          *Memory               `protobuf:"bytes,17,opt,name=max_storage,json=maxStorage,proto3" json:"max_storage,omitempty"`
+	MinScreenSize       float32               `protobuf:"fixed32,18,opt,name=min_screen_size,json=minScreenSize,proto3" json:"min_screen_size,omitempty"`
+	MaxScreenSize       float32               `protobuf:"fixed32,19,opt,name=max_screen_size,json=maxScreenSize,proto3" json:"max_screen_size,omitempty"`
+	MinResolutionWidth  uint32                `protobuf:"varint,20,opt,name=min_resolution_width,json=minResolutionWidth,proto3" json:"min_resolution_width,omitempty"`
+	MinResolutionHeight uint32                `protobuf:"varint,21,opt,name=min_resolution_height,json=minResolutionHeight,proto3" json:"min_resolution_height,omitempty"`
+	Panels              []Screen_Panel        `protobuf:"varint,22,rep,packed,name=panels,proto3,enum=keshavbhattad.pcbook.Screen_Panel" json:"panels,omitempty"`
+	Multitouch          *wrapperspb.BoolValue `protobuf:"bytes,23,opt,name=multitouch,proto3" json:"multitouch,omitempty"`
+	KeyboardLayouts     []Keyboard_Layout     `protobuf:"varint,24,rep,packed,name=keyboard_layouts,json=keyboardLayouts,proto3,enum=keshavbhattad.pcbook.Keyboard_Layout" json:"keyboard_layouts,omitempty"`
+	Backlit             *wrapperspb.BoolValue `protobuf:"bytes,25,opt,name=backlit,proto3" json:"backlit,omitempty"`
+	// Weights given in pounds are converted to kilograms before comparing.
+	MinWeightKg float64 `protobuf:"fixed64,26,opt,name=min_weight_kg,json=minWeightKg,proto3" json:"min_weight_kg,omitempty"`
+	MaxWeightKg float64 `protobuf:"fixed64,27,opt,name=max_weight_kg,json=maxWeightKg,proto3" json:"max_weight_kg,omitempty"`
 }
 
 func (x *Filter) Reset() {
@@ -91,6 +123,167 @@ func (x *Filter) GetMinRam() *Memory {
 	return nil
 }
 
+func (x *Filter) GetBrands() []string {
+	if x != nil {
+		return x.Brands
+	}
+	return nil
+}
+
+func (x *Filter) GetMinPriceInr() float64 {
+	if x != nil {
+		return x.MinPriceInr
+	}
+	return 0
+}
+
+func (x *Filter) GetMinReleaseYear() uint32 {
+	if x != nil {
+		return x.MinReleaseYear
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxReleaseYear() uint32 {
+	if x != nil {
+		return x.MaxReleaseYear
+	}
+	return 0
+}
+
+func (x *Filter) GetCpuBrands() []string {
+	if x != nil {
+		return x.CpuBrands
+	}
+	return nil
+}
+
+func (x *Filter) GetMaxCpuCores() uint32 {
+	if x != nil {
+		return x.MaxCpuCores
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxCpuGhz() float64 {
+	if x != nil {
+		return x.MaxCpuGhz
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxRam() *Memory {
+	if x != nil {
+		return x.MaxRam
+	}
+	return nil
+}
+
+func (x *Filter) GetGpuBrands() []string {
+	if x != nil {
+		return x.GpuBrands
+	}
+	return nil
+}
+
+func (x *Filter) GetMinGpuMemory() *Memory {
+	if x != nil {
+		return x.MinGpuMemory
+	}
+	return nil
+}
+
+func (x *Filter) GetStorageDrivers() []Storage_Driver {
+	if x != nil {
+		return x.StorageDrivers
+	}
+	return nil
+}
+
+func (x *Filter) GetMinStorage() *Memory {
+	if x != nil {
+		return x.MinStorage
+	}
+	return nil
+}
+
+func (x *Filter) GetMaxStorage() *Memory {
+	if x != nil {
+		return x.MaxStorage
+	}
+	return nil
+}
+
+func (x *Filter) GetMinScreenSize() float32 {
+	if x != nil {
+		return x.MinScreenSize
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxScreenSize() float32 {
+	if x != nil {
+		return x.MaxScreenSize
+	}
+	return 0
+}
+
+func (x *Filter) GetMinResolutionWidth() uint32 {
+	if x != nil {
+		return x.MinResolutionWidth
+	}
+	return 0
+}
+
+func (x *Filter) GetMinResolutionHeight() uint32 {
+	if x != nil {
+		return x.MinResolutionHeight
+	}
+	return 0
+}
+
+func (x *Filter) GetPanels() []Screen_Panel {
+	if x != nil {
+		return x.Panels
+	}
+	return nil
+}
+
+func (x *Filter) GetMultitouch() *wrapperspb.BoolValue {
+	if x != nil {
+		return x.Multitouch
+	}
+	return nil
+}
+
+func (x *Filter) GetKeyboardLayouts() []Keyboard_Layout {
+	if x != nil {
+		return x.KeyboardLayouts
+	}
+	return nil
+}
+
+func (x *Filter) GetBacklit() *wrapperspb.BoolValue {
+	if x != nil {
+		return x.Backlit
+	}
+	return nil
+}
+
+func (x *Filter) GetMinWeightKg() float64 {
+	if x != nil {
+		return x.MinWeightKg
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxWeightKg() float64 {
+	if x != nil {
+		return x.MaxWeightKg
+	}
+	return 0
+}
+
 var File_filter_message_proto protoreflect.FileDescriptor
 
 var file_filter_message_proto_rawDesc = []byte{
@@ -98,21 +291,96 @@ var file_filter_message_proto_rawDesc = []byte{
 	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x14, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68,
 	0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x1a, 0x14, 0x6d, 0x65,
 	0x6d, 0x6f, 0x72, 0x79, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x22, 0xa7, 0x01, 0x0a, 0x06, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x22, 0x0a,
-	0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x69, 0x6e, 0x72, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x50, 0x72, 0x69, 0x63, 0x65, 0x49, 0x6e,
-	0x72, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x63, 0x6f, 0x72,
-	0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x6d, 0x69, 0x6e, 0x43, 0x70, 0x75,
-	0x43, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x1e, 0x0a, 0x0b, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x70, 0x75,
-	0x5f, 0x67, 0x68, 0x7a, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x6d, 0x69, 0x6e, 0x43,
-	0x70, 0x75, 0x47, 0x68, 0x7a, 0x12, 0x35, 0x0a, 0x07, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x61, 0x6d,
-	0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62,
-	0x68, 0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65,
-	0x6d, 0x6f, 0x72, 0x79, 0x52, 0x06, 0x6d, 0x69, 0x6e, 0x52, 0x61, 0x6d, 0x42, 0x2c, 0x0a, 0x22,
-	0x63, 0x6f, 0x6d, 0x2e, 0x67, 0x69, 0x74, 0x6c, 0x61, 0x62, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61,
-	0x76, 0x62, 0x68, 0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
-	0x70, 0x62, 0x50, 0x01, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x33,
+	0x74, 0x6f, 0x1a, 0x15, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x5f, 0x6d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x14, 0x73, 0x63, 0x72, 0x65, 0x65,
+	0x6e, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a,
+	0x16, 0x6b, 0x65, 0x79, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x77, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72,
+	0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xff, 0x09, 0x0a, 0x06, 0x46, 0x69, 0x6c, 0x74,
+	0x65, 0x72, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f,
+	0x69, 0x6e, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x50, 0x72,
+	0x69, 0x63, 0x65, 0x49, 0x6e, 0x72, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x70,
+	0x75, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x6d,
+	0x69, 0x6e, 0x43, 0x70, 0x75, 0x43, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x1e, 0x0a, 0x0b, 0x6d, 0x69,
+	0x6e, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x67, 0x68, 0x7a, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x09, 0x6d, 0x69, 0x6e, 0x43, 0x70, 0x75, 0x47, 0x68, 0x7a, 0x12, 0x35, 0x0a, 0x07, 0x6d, 0x69,
+	0x6e, 0x5f, 0x72, 0x61, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x6b, 0x65,
+	0x73, 0x68, 0x61, 0x76, 0x62, 0x68, 0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x06, 0x6d, 0x69, 0x6e, 0x52, 0x61,
+	0x6d, 0x12, 0x16, 0x0a, 0x06, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x06, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x69, 0x6e,
+	0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x69, 0x6e, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x0b, 0x6d, 0x69, 0x6e, 0x50, 0x72, 0x69, 0x63, 0x65, 0x49, 0x6e, 0x72, 0x12, 0x28, 0x0a,
+	0x10, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61,
+	0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x6d, 0x69, 0x6e, 0x52, 0x65, 0x6c, 0x65,
+	0x61, 0x73, 0x65, 0x59, 0x65, 0x61, 0x72, 0x12, 0x28, 0x0a, 0x10, 0x6d, 0x61, 0x78, 0x5f, 0x72,
+	0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61, 0x72, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x0e, 0x6d, 0x61, 0x78, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x59, 0x65, 0x61,
+	0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x70, 0x75, 0x5f, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x18,
+	0x09, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x63, 0x70, 0x75, 0x42, 0x72, 0x61, 0x6e, 0x64, 0x73,
+	0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x63, 0x6f, 0x72, 0x65,
+	0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x43, 0x70, 0x75, 0x43,
+	0x6f, 0x72, 0x65, 0x73, 0x12, 0x1e, 0x0a, 0x0b, 0x6d, 0x61, 0x78, 0x5f, 0x63, 0x70, 0x75, 0x5f,
+	0x67, 0x68, 0x7a, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x6d, 0x61, 0x78, 0x43, 0x70,
+	0x75, 0x47, 0x68, 0x7a, 0x12, 0x35, 0x0a, 0x07, 0x6d, 0x61, 0x78, 0x5f, 0x72, 0x61, 0x6d, 0x18,
+	0x0c, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68,
+	0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x52, 0x06, 0x6d, 0x61, 0x78, 0x52, 0x61, 0x6d, 0x12, 0x1d, 0x0a, 0x0a, 0x67,
+	0x70, 0x75, 0x5f, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x18, 0x0d, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x09, 0x67, 0x70, 0x75, 0x42, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x12, 0x42, 0x0a, 0x0e, 0x6d, 0x69,
+	0x6e, 0x5f, 0x67, 0x70, 0x75, 0x5f, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68, 0x61, 0x74, 0x74,
+	0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79,
+	0x52, 0x0c, 0x6d, 0x69, 0x6e, 0x47, 0x70, 0x75, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x12, 0x4d,
+	0x0a, 0x0f, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x5f, 0x64, 0x72, 0x69, 0x76, 0x65, 0x72,
+	0x73, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x0e, 0x32, 0x24, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76,
+	0x62, 0x68, 0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53,
+	0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x2e, 0x44, 0x72, 0x69, 0x76, 0x65, 0x72, 0x52, 0x0e, 0x73,
+	0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x44, 0x72, 0x69, 0x76, 0x65, 0x72, 0x73, 0x12, 0x3d, 0x0a,
+	0x0b, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x18, 0x10, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68, 0x61, 0x74, 0x74,
+	0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79,
+	0x52, 0x0a, 0x6d, 0x69, 0x6e, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x12, 0x3d, 0x0a, 0x0b,
+	0x6d, 0x61, 0x78, 0x5f, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x1c, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68, 0x61, 0x74, 0x74, 0x61,
+	0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52,
+	0x0a, 0x6d, 0x61, 0x78, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x12, 0x26, 0x0a, 0x0f, 0x6d,
+	0x69, 0x6e, 0x5f, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x12,
+	0x20, 0x01, 0x28, 0x02, 0x52, 0x0d, 0x6d, 0x69, 0x6e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x53,
+	0x69, 0x7a, 0x65, 0x12, 0x26, 0x0a, 0x0f, 0x6d, 0x61, 0x78, 0x5f, 0x73, 0x63, 0x72, 0x65, 0x65,
+	0x6e, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x13, 0x20, 0x01, 0x28, 0x02, 0x52, 0x0d, 0x6d, 0x61,
+	0x78, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x30, 0x0a, 0x14, 0x6d,
+	0x69, 0x6e, 0x5f, 0x72, 0x65, 0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x77, 0x69,
+	0x64, 0x74, 0x68, 0x18, 0x14, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x12, 0x6d, 0x69, 0x6e, 0x52, 0x65,
+	0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x57, 0x69, 0x64, 0x74, 0x68, 0x12, 0x32, 0x0a,
+	0x15, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x65, 0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x5f,
+	0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x15, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x13, 0x6d, 0x69,
+	0x6e, 0x52, 0x65, 0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x48, 0x65, 0x69, 0x67, 0x68,
+	0x74, 0x12, 0x3a, 0x0a, 0x06, 0x70, 0x61, 0x6e, 0x65, 0x6c, 0x73, 0x18, 0x16, 0x20, 0x03, 0x28,
+	0x0e, 0x32, 0x22, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68, 0x61, 0x74, 0x74, 0x61,
+	0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x2e,
+	0x50, 0x61, 0x6e, 0x65, 0x6c, 0x52, 0x06, 0x70, 0x61, 0x6e, 0x65, 0x6c, 0x73, 0x12, 0x3a, 0x0a,
+	0x0a, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x74, 0x6f, 0x75, 0x63, 0x68, 0x18, 0x17, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x42, 0x6f, 0x6f, 0x6c, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x0a, 0x6d,
+	0x75, 0x6c, 0x74, 0x69, 0x74, 0x6f, 0x75, 0x63, 0x68, 0x12, 0x50, 0x0a, 0x10, 0x6b, 0x65, 0x79,
+	0x62, 0x6f, 0x61, 0x72, 0x64, 0x5f, 0x6c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x73, 0x18, 0x18, 0x20,
+	0x03, 0x28, 0x0e, 0x32, 0x25, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68, 0x61, 0x74,
+	0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4b, 0x65, 0x79, 0x62, 0x6f,
+	0x61, 0x72, 0x64, 0x2e, 0x4c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x52, 0x0f, 0x6b, 0x65, 0x79, 0x62,
+	0x6f, 0x61, 0x72, 0x64, 0x4c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x73, 0x12, 0x34, 0x0a, 0x07, 0x62,
+	0x61, 0x63, 0x6b, 0x6c, 0x69, 0x74, 0x18, 0x19, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x42,
+	0x6f, 0x6f, 0x6c, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x07, 0x62, 0x61, 0x63, 0x6b, 0x6c, 0x69,
+	0x74, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f,
+	0x6b, 0x67, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x6d, 0x69, 0x6e, 0x57, 0x65, 0x69,
+	0x67, 0x68, 0x74, 0x4b, 0x67, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x77, 0x65, 0x69,
+	0x67, 0x68, 0x74, 0x5f, 0x6b, 0x67, 0x18, 0x1b, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x6d, 0x61,
+	0x78, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x4b, 0x67, 0x42, 0x2c, 0x0a, 0x22, 0x63, 0x6f, 0x6d,
+	0x2e, 0x67, 0x69, 0x74, 0x6c, 0x61, 0x62, 0x2e, 0x6b, 0x65, 0x73, 0x68, 0x61, 0x76, 0x62, 0x68,
+	0x61, 0x74, 0x74, 0x61, 0x64, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x70, 0x62, 0x50,
+	0x01, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -129,16 +397,29 @@ func file_filter_message_proto_rawDescGZIP() []byte {
 
 var file_filter_message_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
 var file_filter_message_proto_goTypes = []interface{}{
-	(*Filter)(nil), // 0: keshavbhattad.pcbook.Filter
-	(*Memory)(nil), // 1: keshavbhattad.pcbook.Memory
+	(*Filter)(nil),               // 0: keshavbhattad.pcbook.Filter
+	(*Memory)(nil),               // 1: keshavbhattad.pcbook.Memory
+	(Storage_Driver)(0),          // 2: keshavbhattad.pcbook.Storage.Driver
+	(Screen_Panel)(0),            // 3: keshavbhattad.pcbook.Screen.Panel
+	(*wrapperspb.BoolValue)(nil), // 4: google.protobuf.BoolValue
+	(Keyboard_Layout)(0),         // 5: keshavbhattad.pcbook.Keyboard.Layout
 }
 var file_filter_message_proto_depIdxs = []int32{
-	1, // 0: keshavbhattad.pcbook.Filter.min_ram:type_name -> keshavbhattad.pcbook.Memory
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1,  // 0: keshavbhattad.pcbook.Filter.min_ram:type_name -> keshavbhattad.pcbook.Memory
+	1,  // 1: keshavbhattad.pcbook.Filter.max_ram:type_name -> keshavbhattad.pcbook.Memory
+	1,  // 2: keshavbhattad.pcbook.Filter.min_gpu_memory:type_name -> keshavbhattad.pcbook.Memory
+	2,  // 3: keshavbhattad.pcbook.Filter.storage_drivers:type_name -> keshavbhattad.pcbook.Storage.Driver
+	1,  // 4: keshavbhattad.pcbook.Filter.min_storage:type_name -> keshavbhattad.pcbook.Memory
+	1,  // 5: keshavbhattad.pcbook.Filter.max_storage:type_name -> keshavbhattad.pcbook.Memory
+	3,  // 6: keshavbhattad.pcbook.Filter.panels:type_name -> keshavbhattad.pcbook.Screen.Panel
+	4,  // 7: keshavbhattad.pcbook.Filter.multitouch:type_name -> google.protobuf.BoolValue
+	5,  // 8: keshavbhattad.pcbook.Filter.keyboard_layouts:type_name -> keshavbhattad.pcbook.Keyboard.Layout
+	4,  // 9: keshavbhattad.pcbook.Filter.backlit:type_name -> google.protobuf.BoolValue
+	10, // [10:10] is the sub-list for method output_type
+	10, // [10:10] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_filter_message_proto_init() }
@@ -147,6 +428,9 @@ func file_filter_message_proto_init() {
 		return
 	}
 	file_memory_message_proto_init()
+	file_storage_message_proto_init()
+	file_screen_message_proto_init()
+	file_keyboard_message_proto_init()
 	if !protoimpl.UnsafeEnabled {
 		file_filter_message_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Filter); i {
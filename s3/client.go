@@ -0,0 +1,324 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMinPartSize is the smallest size of the parts of a multipart upload
+// but the last one, as enforced by S3
+const DefaultMinPartSize = 5 << 20
+
+// DefaultTimeout is how long a request may take, including reading the
+// response body, when the config does not set a timeout
+const DefaultTimeout = time.Minute
+
+// Config tells a Client where the bucket is and how to sign the requests
+type Config struct {
+	// Endpoint is the base URL of the S3 API, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint    string
+	Region      string
+	Bucket      string
+	Credentials Credentials
+	// Timeout bounds each request, DefaultTimeout when zero
+	Timeout time.Duration
+}
+
+// Client calls the object API of an S3 compatible service. It addresses
+// buckets by path, which all S3 compatible services support
+type Client struct {
+	endpoint   *url.URL
+	bucket     string
+	signer     *Signer
+	httpClient *http.Client
+}
+
+// Error is an error returned by the S3 service
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("S3 error %d %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+// IsNotFound reports whether the error is about a key that does not exist
+func IsNotFound(err error) bool {
+	var s3Err *Error
+	return errors.As(err, &s3Err) && (s3Err.Code == "NoSuchKey" || s3Err.StatusCode == http.StatusNotFound && s3Err.Code == "")
+}
+
+// Part is a part of a multipart upload
+type Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func NewClient(config Config) (*Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid S3 endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q: the scheme and the host are required", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	client := &Client{
+		endpoint:   endpoint,
+		bucket:     config.Bucket,
+		signer:     NewSigner(config.Credentials, config.Region),
+		httpClient: &http.Client{Timeout: timeout},
+	}
+	return client, nil
+}
+
+// do sends a signed request for the key and returns the response when its
+// status is successful
+func (client *Client) do(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	u := *client.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + client.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create S3 request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+
+	client.signer.Sign(req, PayloadHash(body), time.Now())
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot send S3 request: %w", err)
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		return nil, readError(res)
+	}
+	return res, nil
+}
+
+func readError(res *http.Response) error {
+	s3Err := &Error{StatusCode: res.StatusCode}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err == nil && len(data) > 0 {
+		xml.Unmarshal(data, s3Err)
+	}
+	if s3Err.Message == "" {
+		s3Err.Message = http.StatusText(res.StatusCode)
+	}
+	return s3Err
+}
+
+// decode reads the XML body of a response. Some errors, like the ones of
+// CompleteMultipartUpload, come with a successful status
+func decode(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("Cannot read S3 response: %w", err)
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err == nil && root.XMLName.Local == "Error" {
+		s3Err := &Error{StatusCode: res.StatusCode}
+		xml.Unmarshal(data, s3Err)
+		return s3Err
+	}
+
+	err = xml.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("Cannot decode S3 response: %w", err)
+	}
+	return nil
+}
+
+func (client *Client) PutObject(ctx context.Context, key string, data []byte, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	res, err := client.do(ctx, http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// GetObject returns a reader of the object data, which the caller must
+// close, together with the size of the object
+func (client *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	res, err := client.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// DeleteObject removes the object. Like S3, it succeeds if the key does not exist
+func (client *Client) DeleteObject(ctx context.Context, key string) error {
+	res, err := client.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObjects returns the keys starting with prefix, in lexicographic order
+func (client *Client) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := client.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &listBucketResult{}
+		err = decode(res, result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		if result.NextContinuationToken == "" {
+			return nil, errors.New("Truncated S3 listing without continuation token")
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// CreateMultipartUpload starts a multipart upload of the key and returns its upload ID
+func (client *Client) CreateMultipartUpload(ctx context.Context, key string, contentType string) (string, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	res, err := client.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return "", err
+	}
+
+	result := &struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	err = decode(res, result)
+	if err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("S3 returned no upload ID")
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads a part of a multipart upload. Part numbers start at 1
+func (client *Client) UploadPart(ctx context.Context, key string, uploadID string, partNumber int, data []byte) (*Part, error) {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	query.Set("uploadId", uploadID)
+
+	res, err := client.do(ctx, http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return &Part{PartNumber: partNumber, ETag: res.Header.Get("ETag")}, nil
+}
+
+// CompleteMultipartUpload assembles the parts into the object
+func (client *Client) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, parts []*Part) error {
+	body, err := xml.Marshal(&struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []*Part  `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("Cannot encode S3 request: %w", err)
+	}
+
+	res, err := client.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+
+	result := &struct {
+		Key string `xml:"Key"`
+	}{}
+	return decode(res, result)
+}
+
+// AbortMultipartUpload discards the parts uploaded so far
+func (client *Client) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	res, err := client.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
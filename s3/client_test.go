@@ -0,0 +1,147 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/s3"
+	"gitlab.com/keshavbhattad/pcbook/s3/s3test"
+)
+
+var testCredentials = s3.Credentials{
+	AccessKeyID:     "test-access-key",
+	SecretAccessKey: "test-secret-key",
+}
+
+func startFakeServer(t *testing.T) (*s3test.FakeServer, *s3.Client) {
+	fake := s3test.NewFakeServer(testCredentials, "us-east-1", "images")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := s3.NewClient(s3.Config{
+		Endpoint:    server.URL,
+		Region:      "us-east-1",
+		Bucket:      "images",
+		Credentials: testCredentials,
+	})
+	require.NoError(t, err)
+
+	return fake, client
+}
+
+func TestClientObjects(t *testing.T) {
+	t.Parallel()
+
+	fake, client := startFakeServer(t)
+	fake.MaxKeys = 2
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("laptops/a b+c/%d.jpeg", i)
+		err := client.PutObject(ctx, key, []byte(key), "image/jpeg")
+		require.NoError(t, err)
+	}
+	err := client.PutObject(ctx, "other/key", []byte("other"), "")
+	require.NoError(t, err)
+
+	keys, err := client.ListObjects(ctx, "laptops/")
+	require.NoError(t, err)
+	require.Len(t, keys, 5)
+	require.Equal(t, "laptops/a b+c/0.jpeg", keys[0])
+
+	reader, size, err := client.GetObject(ctx, keys[3])
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, keys[3], string(data))
+	require.Equal(t, int64(len(data)), size)
+
+	err = client.DeleteObject(ctx, keys[3])
+	require.NoError(t, err)
+
+	_, _, err = client.GetObject(ctx, keys[3])
+	require.True(t, s3.IsNotFound(err))
+
+	err = client.DeleteObject(ctx, keys[3])
+	require.NoError(t, err)
+}
+
+func TestClientMultipartUpload(t *testing.T) {
+	t.Parallel()
+
+	fake, client := startFakeServer(t)
+	fake.MinPartSize = 10
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	data = append(data, "end"...)
+
+	uploadID, err := client.CreateMultipartUpload(ctx, "big", "image/png")
+	require.NoError(t, err)
+
+	var parts []*s3.Part
+	for i := 0; i*10 < len(data); i++ {
+		end := (i + 1) * 10
+		if end > len(data) {
+			end = len(data)
+		}
+		part, err := client.UploadPart(ctx, "big", uploadID, i+1, data[i*10:end])
+		require.NoError(t, err)
+		parts = append(parts, part)
+	}
+
+	err = client.CompleteMultipartUpload(ctx, "big", uploadID, parts)
+	require.NoError(t, err)
+	require.Zero(t, fake.Uploads())
+
+	reader, _, err := client.GetObject(ctx, "big")
+	require.NoError(t, err)
+	uploaded, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, data, uploaded)
+
+	// parts smaller than the minimum are rejected on completion
+	uploadID, err = client.CreateMultipartUpload(ctx, "small", "")
+	require.NoError(t, err)
+	first, err := client.UploadPart(ctx, "small", uploadID, 1, []byte("tiny"))
+	require.NoError(t, err)
+	second, err := client.UploadPart(ctx, "small", uploadID, 2, []byte("tiny"))
+	require.NoError(t, err)
+
+	err = client.CompleteMultipartUpload(ctx, "small", uploadID, []*s3.Part{first, second})
+	require.Error(t, err)
+
+	err = client.AbortMultipartUpload(ctx, "small", uploadID)
+	require.NoError(t, err)
+	require.Zero(t, fake.Uploads())
+	require.Equal(t, []string{"big"}, fake.Keys("images"))
+}
+
+func TestClientInvalidCredentials(t *testing.T) {
+	t.Parallel()
+
+	fake := s3test.NewFakeServer(testCredentials, "us-east-1", "images")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := s3.NewClient(s3.Config{
+		Endpoint:    server.URL,
+		Region:      "us-east-1",
+		Bucket:      "images",
+		Credentials: s3.Credentials{AccessKeyID: "test-access-key", SecretAccessKey: "wrong"},
+	})
+	require.NoError(t, err)
+
+	err = client.PutObject(context.Background(), "key", []byte("data"), "")
+	var s3Err *s3.Error
+	require.ErrorAs(t, err, &s3Err)
+	require.Equal(t, "SignatureDoesNotMatch", s3Err.Code)
+	require.Empty(t, fake.Keys("images"))
+}
@@ -0,0 +1,300 @@
+// Package s3test provides a fake S3 service for the tests of the clients of
+// the s3 package
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"gitlab.com/keshavbhattad/pcbook/s3"
+)
+
+// FakeServer is an in-memory implementation of the part of the S3 API used by
+// s3.Client, for tests. It checks the request signatures like S3 does
+type FakeServer struct {
+	// MinPartSize is the smallest size of the parts of a multipart upload but the last one
+	MinPartSize int
+	// MaxKeys is the largest number of keys returned in a page of a listing
+	MaxKeys int
+
+	signer  *s3.Signer
+	mutex   sync.Mutex
+	buckets map[string]map[string]*fakeObject
+	uploads map[string]*fakeUpload
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	etag        string
+}
+
+type fakeUpload struct {
+	bucket      string
+	key         string
+	contentType string
+	parts       map[int]*fakeObject
+}
+
+// NewFakeServer creates a fake S3 service with empty buckets, which only
+// accepts requests signed with the credentials for the region
+func NewFakeServer(credentials s3.Credentials, region string, buckets ...string) *FakeServer {
+	server := &FakeServer{
+		MinPartSize: s3.DefaultMinPartSize,
+		MaxKeys:     1000,
+		signer:      s3.NewSigner(credentials, region),
+		buckets:     make(map[string]map[string]*fakeObject),
+		uploads:     make(map[string]*fakeUpload),
+	}
+	for _, bucket := range buckets {
+		server.buckets[bucket] = make(map[string]*fakeObject)
+	}
+	return server
+}
+
+// Keys returns the keys of the objects in the bucket, for tests to check what was stored
+func (server *FakeServer) Keys(bucket string) []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	var keys []string
+	for key := range server.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Uploads returns the number of multipart uploads in progress
+func (server *FakeServer) Uploads() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return len(server.uploads)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	xml.NewEncoder(w).Encode(&struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func md5ETag(data []byte) string {
+	hash := md5.Sum(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+func (server *FakeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	err = server.signer.Verify(req)
+	if err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != s3.UnsignedPayload && payloadHash != s3.PayloadHash(body) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The payload hash does not match the body")
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	objects := server.buckets[bucket]
+	if objects == nil {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The bucket does not exist")
+		return
+	}
+
+	query := req.URL.Query()
+	_, hasUploads := query["uploads"]
+	_, hasUploadID := query["uploadId"]
+
+	switch {
+	case key == "" && req.Method == http.MethodGet:
+		server.listObjects(w, objects, query.Get("prefix"), query.Get("continuation-token"))
+	case key == "":
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Bucket operation not supported")
+	case req.Method == http.MethodPost && hasUploads:
+		uploadID := uuid.New().String()
+		server.uploads[uploadID] = &fakeUpload{
+			bucket:      bucket,
+			key:         key,
+			contentType: req.Header.Get("Content-Type"),
+			parts:       make(map[int]*fakeObject),
+		}
+		writeXML(w, &struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string   `xml:"Bucket"`
+			Key      string   `xml:"Key"`
+			UploadID string   `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadID: uploadID})
+	case hasUploadID:
+		server.serveMultipart(w, req, objects, bucket, key, body)
+	case req.Method == http.MethodPut:
+		object := &fakeObject{data: body, contentType: req.Header.Get("Content-Type"), etag: md5ETag(body)}
+		objects[key] = object
+		w.Header().Set("ETag", object.etag)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		object := objects[key]
+		if object == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		w.Header().Set("ETag", object.etag)
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		if req.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case req.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Object operation not supported")
+	}
+}
+
+func (server *FakeServer) listObjects(w http.ResponseWriter, objects map[string]*fakeObject, prefix string, token string) {
+	var keys []string
+	for key := range objects {
+		// the continuation token is the last key of the previous page
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+	result := &struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Prefix                string    `xml:"Prefix"`
+		KeyCount              int       `xml:"KeyCount"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+		Contents              []content `xml:"Contents"`
+	}{Prefix: prefix}
+
+	if len(keys) > server.MaxKeys {
+		keys = keys[:server.MaxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key, Size: len(objects[key].data)})
+	}
+	result.KeyCount = len(keys)
+
+	writeXML(w, result)
+}
+
+func (server *FakeServer) serveMultipart(
+	w http.ResponseWriter,
+	req *http.Request,
+	objects map[string]*fakeObject,
+	bucket string,
+	key string,
+	body []byte,
+) {
+	uploadID := req.URL.Query().Get("uploadId")
+	upload := server.uploads[uploadID]
+	if upload == nil || upload.bucket != bucket || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		partNumber, err := strconv.Atoi(req.URL.Query().Get("partNumber"))
+		if err != nil || partNumber < 1 || partNumber > 10000 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid part number")
+			return
+		}
+		part := &fakeObject{data: body, etag: md5ETag(body)}
+		upload.parts[partNumber] = part
+		w.Header().Set("ETag", part.etag)
+
+	case http.MethodPost:
+		request := &struct {
+			Parts []s3.Part `xml:"Part"`
+		}{}
+		if err := xml.Unmarshal(body, request); err != nil || len(request.Parts) == 0 {
+			writeError(w, http.StatusBadRequest, "MalformedXML", "Invalid list of parts")
+			return
+		}
+
+		var data []byte
+		var etags []byte
+		for i, p := range request.Parts {
+			part := upload.parts[p.PartNumber]
+			if part == nil || part.etag != p.ETag {
+				writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d is not uploaded", p.PartNumber))
+				return
+			}
+			if i > 0 && p.PartNumber <= request.Parts[i-1].PartNumber {
+				writeError(w, http.StatusBadRequest, "InvalidPartOrder", "Parts must be in ascending order")
+				return
+			}
+			if i < len(request.Parts)-1 && len(part.data) < server.MinPartSize {
+				writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("Part %d is too small", p.PartNumber))
+				return
+			}
+			data = append(data, part.data...)
+			hash, _ := hex.DecodeString(strings.Trim(part.etag, `"`))
+			etags = append(etags, hash...)
+		}
+
+		hash := md5.Sum(etags)
+		etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(hash[:]), len(request.Parts))
+		objects[key] = &fakeObject{data: data, contentType: upload.contentType, etag: etag}
+		delete(server.uploads, uploadID)
+
+		writeXML(w, &struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string   `xml:"Bucket"`
+			Key     string   `xml:"Key"`
+			ETag    string   `xml:"ETag"`
+		}{Bucket: bucket, Key: key, ETag: etag})
+
+	case http.MethodDelete:
+		delete(server.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Multipart operation not supported")
+	}
+}
@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	scopeFormat   = "20060102"
+
+	// EmptyPayloadHash is the SHA-256 of an empty body
+	EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// UnsignedPayload is sent as the payload hash when the body is not signed
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var ErrInvalidSignature = errors.New("Invalid request signature")
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// Signer signs requests with AWS Signature Version 4
+type Signer struct {
+	Credentials Credentials
+	Region      string
+	Service     string
+}
+
+func NewSigner(credentials Credentials, region string) *Signer {
+	return &Signer{
+		Credentials: credentials,
+		Region:      region,
+		Service:     "s3",
+	}
+}
+
+// PayloadHash returns the hex encoded SHA-256 of the body
+func PayloadHash(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+// Sign adds the date, the payload hash and the authorization headers to the
+// request. The host, the x-amz-* headers and the content headers are signed
+func (signer *Signer) Sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" || name == "range" {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	sort.Strings(signedHeaders)
+
+	signature := signer.signature(req, signedHeaders, payloadHash, amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm,
+		signer.Credentials.AccessKeyID,
+		signer.scope(amzDate),
+		strings.Join(signedHeaders, ";"),
+		signature,
+	))
+}
+
+// Verify checks the authorization header of a signed request. The payload
+// hash is taken from the request headers, so the caller checks it against
+// the body
+func (signer *Signer) Verify(req *http.Request) error {
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, signAlgorithm+" ") {
+		return fmt.Errorf("%w: unsupported authorization", ErrInvalidSignature)
+	}
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, signAlgorithm+" "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+
+	amzDate := req.Header.Get("X-Amz-Date")
+	if _, err := time.Parse(amzDateFormat, amzDate); err != nil {
+		return fmt.Errorf("%w: invalid date %q", ErrInvalidSignature, amzDate)
+	}
+
+	credential := signer.Credentials.AccessKeyID + "/" + signer.scope(amzDate)
+	if fields["Credential"] != credential {
+		return fmt.Errorf("%w: unknown credential %q", ErrInvalidSignature, fields["Credential"])
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	signature := signer.signature(req, signedHeaders, req.Header.Get("X-Amz-Content-Sha256"), amzDate)
+	if !hmac.Equal([]byte(signature), []byte(fields["Signature"])) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (signer *Signer) scope(amzDate string) string {
+	return strings.Join([]string{amzDate[:len(scopeFormat)], signer.Region, signer.Service, "aws4_request"}, "/")
+}
+
+func (signer *Signer) signature(req *http.Request, signedHeaders []string, payloadHash string, amzDate string) string {
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders(req, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		signAlgorithm,
+		amzDate,
+		signer.scope(amzDate),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+signer.Credentials.SecretAccessKey), amzDate[:len(scopeFormat)])
+	key = hmacSHA256(key, signer.Region)
+	key = hmacSHA256(key, signer.Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986, and
+// the slashes when encoding a path
+func uriEncode(value string, path bool) string {
+	builder := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			builder.WriteByte(c)
+		case c == '/' && path:
+			builder.WriteByte(c)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return uriEncode(u.Path, true)
+}
+
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaders(req *http.Request, signedHeaders []string) string {
+	builder := strings.Builder{}
+	for _, name := range signedHeaders {
+		var value string
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		} else {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		builder.WriteString(name)
+		builder.WriteByte(':')
+		builder.WriteString(strings.Join(strings.Fields(value), " "))
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
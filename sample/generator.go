@@ -121,6 +121,7 @@ func NewLaptop() *pb.Laptop {
 		ReleaseYear: uint32(randomInt(2012, 2021)),
 		UpdatedAt:   ptypes.TimestampNow(),
 	}
+	laptop.CreatedAt = laptop.UpdatedAt
 	return laptop
 }
 
@@ -1,7 +1,11 @@
 package serializer
 
 import (
+	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 
 	"github.com/golang/protobuf/proto"
@@ -48,3 +52,89 @@ func ReadProtobufFromBinaryFile(fileName string, message proto.Message) error {
 
 	return nil
 }
+
+// WriteProtobufToBinaryStream writes message to w as a single record,
+// prefixed with its length so that several records can share one stream
+func WriteProtobufToBinaryStream(w io.Writer, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal proto message to binary: %w", err)
+	}
+
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(len(data)))
+
+	_, err = w.Write(append(header[:n], data...))
+	if err != nil {
+		return fmt.Errorf("Cannot write binary record: %w", err)
+	}
+
+	return nil
+}
+
+// MaxBinaryRecordSize is the largest record ReadProtobufFromBinaryStream
+// accepts, so that a corrupt size is not taken for a huge record
+const MaxBinaryRecordSize = 64 << 20
+
+// ErrInvalidRecord is returned for a record whose size cannot be valid
+var ErrInvalidRecord = errors.New("invalid binary record")
+
+// readRecordSize reads the varint size of a record. Unlike
+// binary.ReadUvarint, it tells an overflow apart from the errors of r
+func readRecordSize(r *bufio.Reader) (uint64, error) {
+	var buf [binary.MaxVarintLen64]byte
+
+	for i := range buf {
+		b, err := r.ReadByte()
+		if err == io.EOF && i == 0 {
+			return 0, io.EOF
+		}
+		if err == io.EOF {
+			return 0, fmt.Errorf("Cannot read binary record size: %w", io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Cannot read binary record size: %w", err)
+		}
+
+		buf[i] = b
+		if b < 0x80 {
+			size, n := binary.Uvarint(buf[:i+1])
+			if n <= 0 {
+				break
+			}
+			return size, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Binary record size overflows 64 bits: %w", ErrInvalidRecord)
+}
+
+// ReadProtobufFromBinaryStream reads the next record written by
+// WriteProtobufToBinaryStream. It returns io.EOF when the stream ends cleanly,
+// io.ErrUnexpectedEOF when it ends inside a record and ErrInvalidRecord when
+// the size of the record is corrupt
+func ReadProtobufFromBinaryStream(r *bufio.Reader, message proto.Message) error {
+	size, err := readRecordSize(r)
+	if err != nil {
+		return err
+	}
+	if size > MaxBinaryRecordSize {
+		return fmt.Errorf("Binary record size %d is larger than %d bytes: %w", size, MaxBinaryRecordSize, ErrInvalidRecord)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("Cannot read binary record: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot read binary record: %w", err)
+	}
+
+	err = proto.Unmarshal(data, message)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal binary to proto message: %w", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,9 @@
 package serializer_test
 
 import (
+	"bufio"
+	"bytes"
+	"io"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
@@ -29,3 +32,42 @@ func TestFileSerializer(t *testing.T) {
 	err = serializer.WriteProtobufToJSONFile(laptop1, JSONFile)
 	require.NoError(t, err)
 }
+
+func TestBinaryStreamSerializer(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	var stream bytes.Buffer
+	require.NoError(t, serializer.WriteProtobufToBinaryStream(&stream, laptop))
+	record := stream.Bytes()
+
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"ok", record, nil},
+		{"empty", nil, io.EOF},
+		{"torn_size", []byte{0xff}, io.ErrUnexpectedEOF},
+		{"torn_record", record[:len(record)-1], io.ErrUnexpectedEOF},
+		{"size_overflow", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, serializer.ErrInvalidRecord},
+		{"size_too_large", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, serializer.ErrInvalidRecord},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			other := &pb.Laptop{}
+			err := serializer.ReadProtobufFromBinaryStream(bufio.NewReader(bytes.NewReader(tc.data)), other)
+			if tc.err == nil {
+				require.NoError(t, err)
+				require.True(t, proto.Equal(laptop, other))
+				return
+			}
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The scopes of the API keys, each allowing a group of methods.
+// ScopeImpersonate allows no method, but lets the key act as the users it
+// sends, e.g. to import their ratings
+const (
+	ScopeReadCatalog  = "catalog:read"
+	ScopeWriteCatalog = "catalog:write"
+	ScopeUploadImages = "images:upload"
+	ScopeRate         = "ratings:write"
+	ScopeImpersonate  = "users:impersonate"
+)
+
+// Scopes lists the scopes an API key can be given
+var Scopes = []string{ScopeReadCatalog, ScopeWriteCatalog, ScopeUploadImages, ScopeRate, ScopeImpersonate}
+
+// apiKeyPrefix makes the keys easy to recognize, e.g. by secret scanners
+const apiKeyPrefix = "pcbook_"
+
+// APIKey is a credential of a machine client, allowed to call the methods of
+// its scopes. Only the hash of the key is kept
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"sha256"`
+	Scopes    []string  `json:"scopes"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// RevokedAt is zero while the key is valid
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// NewAPIKey returns a new API key with the scopes, together with the key
+// itself, which cannot be recovered from the returned APIKey
+func NewAPIKey(name string, scopes []string, createdBy string) (*APIKey, string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot generate API key: %w", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiKey := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Hash:      HashAPIKey(key),
+		Scopes:    append([]string(nil), scopes...),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+	return apiKey, key, nil
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up with. Unlike
+// passwords, the keys are random, so a fast hash without salt is enough
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// IsValidScope reports whether the scope is one of Scopes
+func IsValidScope(scope string) bool {
+	for _, valid := range Scopes {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func (apiKey *APIKey) HasScope(scope string) bool {
+	for _, s := range apiKey.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// UserID returns the user the key acts as when it sends no user
+func (apiKey *APIKey) UserID() string {
+	return "apikey:" + apiKey.ID
+}
+
+func (apiKey *APIKey) IsRevoked() bool {
+	return !apiKey.RevokedAt.IsZero()
+}
+
+func (apiKey *APIKey) Clone() *APIKey {
+	other := *apiKey
+	other.Scopes = append([]string(nil), apiKey.Scopes...)
+	return &other
+}
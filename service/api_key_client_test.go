@@ -0,0 +1,189 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/client"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dialTestAPIKeyServer returns a connection calling with the API key
+func dialTestAPIKeyServer(t *testing.T, serverAddress string, apiKey string) *grpc.ClientConn {
+	interceptor := client.NewAPIKeyInterceptor(apiKey)
+
+	return dialTestServer(
+		t,
+		serverAddress,
+		grpc.WithChainUnaryInterceptor(interceptor.Unary()),
+		grpc.WithChainStreamInterceptor(interceptor.Stream()),
+	)
+}
+
+func TestClientCreateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	serverAddress, _ := startTestAuthServer(t, time.Minute)
+	adminClient := pb.NewAPIKeyServiceClient(dialTestAuthServer(t, serverAddress, "admin1", time.Second))
+	userClient := pb.NewAPIKeyServiceClient(dialTestAuthServer(t, serverAddress, "user1", time.Second))
+
+	testCases := []struct {
+		name   string
+		client pb.APIKeyServiceClient
+		req    *pb.CreateAPIKeyRequest
+		code   codes.Code
+	}{
+		{
+			name:   "ok",
+			client: adminClient,
+			req:    &pb.CreateAPIKeyRequest{Name: "importer", Scopes: []string{service.ScopeReadCatalog, service.ScopeRate}},
+			code:   codes.OK,
+		},
+		{
+			name:   "not_admin",
+			client: userClient,
+			req:    &pb.CreateAPIKeyRequest{Name: "importer", Scopes: []string{service.ScopeReadCatalog}},
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "no_name",
+			client: adminClient,
+			req:    &pb.CreateAPIKeyRequest{Name: " ", Scopes: []string{service.ScopeReadCatalog}},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "no_scope",
+			client: adminClient,
+			req:    &pb.CreateAPIKeyRequest{Name: "importer"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "unknown_scope",
+			client: adminClient,
+			req:    &pb.CreateAPIKeyRequest{Name: "importer", Scopes: []string{"catalog:admin"}},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := tc.client.CreateAPIKey(context.Background(), tc.req)
+			require.Equal(t, tc.code, status.Code(err))
+			if tc.code != codes.OK {
+				return
+			}
+
+			require.True(t, strings.HasPrefix(res.GetKey(), "pcbook_"))
+			require.NotEmpty(t, res.GetApiKey().GetId())
+			require.Equal(t, tc.req.GetName(), res.GetApiKey().GetName())
+			require.Equal(t, tc.req.GetScopes(), res.GetApiKey().GetScopes())
+			require.Equal(t, "admin1", res.GetApiKey().GetCreatedBy())
+			require.Nil(t, res.GetApiKey().GetRevokedAt())
+		})
+	}
+}
+
+func TestClientAPIKeyScopes(t *testing.T) {
+	t.Parallel()
+
+	serverAddress, _ := startTestAuthServer(t, time.Minute)
+	adminConn := dialTestAuthServer(t, serverAddress, "admin1", time.Second)
+	apiKeyClient := pb.NewAPIKeyServiceClient(adminConn)
+
+	created, err := apiKeyClient.CreateAPIKey(context.Background(), &pb.CreateAPIKeyRequest{
+		Name:   "importer",
+		Scopes: []string{service.ScopeReadCatalog, service.ScopeRate},
+	})
+	require.NoError(t, err)
+	keyConn := dialTestAPIKeyServer(t, serverAddress, created.GetKey())
+	keyClient := pb.NewLaptopServiceClient(keyConn)
+
+	laptop := sample.NewLaptop()
+	_, err = pb.NewLaptopServiceClient(adminConn).CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = keyClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	search, err := keyClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.NoError(t, err)
+
+	// a key rates as itself, and cannot act as a user without ScopeImpersonate
+	rate, err := keyClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 7}))
+	res, err := rate.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetRatedCount())
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: "apikey:" + created.GetApiKey().GetId(), Score: 8}))
+	res, err = rate.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetRatedCount())
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: "user1", Score: 7}))
+	_, err = rate.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	impersonator, err := apiKeyClient.CreateAPIKey(context.Background(), &pb.CreateAPIKeyRequest{
+		Name:   "rating importer",
+		Scopes: []string{service.ScopeRate, service.ScopeImpersonate},
+	})
+	require.NoError(t, err)
+	impersonatorClient := pb.NewLaptopServiceClient(dialTestAPIKeyServer(t, serverAddress, impersonator.GetKey()))
+
+	rate, err = impersonatorClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	for i, userID := range []string{"user1", "user2"} {
+		require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: userID, Score: 7}))
+		res, err := rate.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint32(i+2), res.GetRatedCount())
+	}
+	require.NoError(t, rate.CloseSend())
+
+	// the scopes are checked on the public methods too
+	_, err = keyClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+	_, err = impersonatorClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.GetId()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	unknownClient := pb.NewLaptopServiceClient(dialTestAPIKeyServer(t, serverAddress, "pcbook_unknown"))
+	_, err = unknownClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the keys cannot call the methods without scope
+	_, err = pb.NewAPIKeyServiceClient(keyConn).ListAPIKeys(context.Background(), &pb.ListAPIKeysRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	revoked, err := apiKeyClient.RevokeAPIKey(context.Background(), &pb.RevokeAPIKeyRequest{Id: created.GetApiKey().GetId()})
+	require.NoError(t, err)
+	require.NotNil(t, revoked.GetApiKey().GetRevokedAt())
+
+	search, err = keyClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = apiKeyClient.RevokeAPIKey(context.Background(), &pb.RevokeAPIKeyRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	listed, err := apiKeyClient.ListAPIKeys(context.Background(), &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, listed.GetApiKeys(), 2)
+	for _, apiKey := range listed.GetApiKeys() {
+		require.Equal(t, apiKey.GetId() == created.GetApiKey().GetId(), apiKey.GetRevokedAt() != nil)
+	}
+}
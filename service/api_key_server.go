@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// APIKeyServer mints, revokes and lists the API keys, for the admins
+type APIKeyServer struct {
+	apiKeyStore APIKeyStore
+}
+
+func NewAPIKeyServer(apiKeyStore APIKeyStore) *APIKeyServer {
+	return &APIKeyServer{
+		apiKeyStore: apiKeyStore,
+	}
+}
+
+func (server *APIKeyServer) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	name := strings.TrimSpace(req.GetName())
+	log.Printf("Received create-API-key request for: %s with scopes: %v", name, req.GetScopes())
+
+	if name == "" {
+		return nil, logError(status.Error(codes.InvalidArgument, "Name is required to create an API key"))
+	}
+	if len(req.GetScopes()) == 0 {
+		return nil, logError(status.Error(codes.InvalidArgument, "At least one scope is required to create an API key"))
+	}
+	for _, scope := range req.GetScopes() {
+		if !IsValidScope(scope) {
+			return nil, logError(status.Errorf(codes.InvalidArgument, "Unknown scope %q, the scopes are %s", scope, strings.Join(Scopes, ", ")))
+		}
+	}
+
+	createdBy := ""
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		createdBy = claims.Username
+	}
+
+	apiKey, key, err := NewAPIKey(name, req.GetScopes(), createdBy)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot create API key: %v", err))
+	}
+
+	err = server.apiKeyStore.Save(apiKey)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot save API key: %v", err))
+	}
+
+	log.Printf("Created API key with ID: %s", apiKey.ID)
+
+	res := &pb.CreateAPIKeyResponse{
+		ApiKey: apiKeyToProto(apiKey),
+		Key:    key,
+	}
+	return res, nil
+}
+
+func (server *APIKeyServer) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	log.Printf("Received revoke-API-key request for ID: %s", req.GetId())
+
+	apiKey, err := server.apiKeyStore.Revoke(req.GetId())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot revoke API key: %v", err))
+	}
+
+	res := &pb.RevokeAPIKeyResponse{
+		ApiKey: apiKeyToProto(apiKey),
+	}
+	return res, nil
+}
+
+func (server *APIKeyServer) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	apiKeys, err := server.apiKeyStore.List()
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot list API keys: %v", err))
+	}
+
+	res := &pb.ListAPIKeysResponse{}
+	for _, apiKey := range apiKeys {
+		res.ApiKeys = append(res.ApiKeys, apiKeyToProto(apiKey))
+	}
+	return res, nil
+}
+
+func apiKeyToProto(apiKey *APIKey) *pb.APIKey {
+	message := &pb.APIKey{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		CreatedBy: apiKey.CreatedBy,
+		CreatedAt: timestamppb.New(apiKey.CreatedAt),
+	}
+	if apiKey.IsRevoked() {
+		message.RevokedAt = timestamppb.New(apiKey.RevokedAt)
+	}
+	return message
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type APIKeyStore interface {
+	// Save returns ErrAlreadyExists if a key with the same ID or hash exists
+	Save(apiKey *APIKey) error
+	Find(id string) (*APIKey, error)
+	FindByHash(hash string) (*APIKey, error)
+	// Revoke marks the key as revoked and returns it. Revoking a revoked key
+	// keeps its first revocation time
+	Revoke(id string) (*APIKey, error)
+	// List returns all the keys, revoked or not, oldest first
+	List() ([]*APIKey, error)
+}
+
+type InMemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+	// byHash maps the hash of a key to its ID
+	byHash map[string]string
+}
+
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys:   make(map[string]*APIKey),
+		byHash: make(map[string]string),
+	}
+}
+
+func (store *InMemoryAPIKeyStore) Save(apiKey *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.keys[apiKey.ID] != nil || store.byHash[apiKey.Hash] != "" {
+		return ErrAlreadyExists
+	}
+
+	store.keys[apiKey.ID] = apiKey.Clone()
+	store.byHash[apiKey.Hash] = apiKey.ID
+	return nil
+}
+
+func (store *InMemoryAPIKeyStore) Find(id string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKey := store.keys[id]
+	if apiKey == nil {
+		return nil, fmt.Errorf("Cannot find the API key with ID %s: %w", id, ErrNotFound)
+	}
+
+	return apiKey.Clone(), nil
+}
+
+func (store *InMemoryAPIKeyStore) FindByHash(hash string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKey := store.keys[store.byHash[hash]]
+	if apiKey == nil {
+		return nil, fmt.Errorf("Cannot find the API key: %w", ErrNotFound)
+	}
+
+	return apiKey.Clone(), nil
+}
+
+func (store *InMemoryAPIKeyStore) Revoke(id string) (*APIKey, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	apiKey := store.keys[id]
+	if apiKey == nil {
+		return nil, fmt.Errorf("Cannot find the API key with ID %s: %w", id, ErrNotFound)
+	}
+
+	if !apiKey.IsRevoked() {
+		apiKey.RevokedAt = time.Now().UTC()
+	}
+	return apiKey.Clone(), nil
+}
+
+// put replaces the stored key with the same ID
+func (store *InMemoryAPIKeyStore) put(apiKey *APIKey) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.keys[apiKey.ID] = apiKey.Clone()
+	store.byHash[apiKey.Hash] = apiKey.ID
+}
+
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKeys := make([]*APIKey, 0, len(store.keys))
+	for _, apiKey := range store.keys {
+		apiKeys = append(apiKeys, apiKey.Clone())
+	}
+
+	sort.Slice(apiKeys, func(i, j int) bool {
+		if !apiKeys[i].CreatedAt.Equal(apiKeys[j].CreatedAt) {
+			return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+		}
+		return apiKeys[i].ID < apiKeys[j].ID
+	})
+	return apiKeys, nil
+}
@@ -0,0 +1,266 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/client"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const testJWTSecret = "test secret"
+
+// countingUserStore counts the logins
+type countingUserStore struct {
+	*service.InMemoryUserStore
+	finds int32
+}
+
+func (store *countingUserStore) Find(username string) (*service.User, error) {
+	atomic.AddInt32(&store.finds, 1)
+	return store.InMemoryUserStore.Find(username)
+}
+
+func startTestAuthServer(t *testing.T, tokenDuration time.Duration) (string, *countingUserStore) {
+	userStore := &countingUserStore{InMemoryUserStore: service.NewInMemoryUserStore()}
+	for _, username := range []string{"admin", "user"} {
+		user, err := service.NewUser(username+"1", "secret", username)
+		require.NoError(t, err)
+		require.NoError(t, userStore.Save(user))
+	}
+
+	jwtManager := service.NewJWTManager(testJWTSecret, tokenDuration)
+	interceptor := service.NewAuthInterceptor(jwtManager, map[string][]string{
+		"/keshavbhattad.pcbook.LaptopService/CreateLaptop": {"admin"},
+		"/keshavbhattad.pcbook.LaptopService/SearchLaptop": {"admin", "user"},
+		"/keshavbhattad.pcbook.LaptopService/RateLaptop":   {"admin", "user"},
+		"/keshavbhattad.pcbook.APIKeyService/CreateAPIKey": {"admin"},
+		"/keshavbhattad.pcbook.APIKeyService/RevokeAPIKey": {"admin"},
+		"/keshavbhattad.pcbook.APIKeyService/ListAPIKeys":  {"admin"},
+	}, []string{
+		"/keshavbhattad.pcbook.AuthService/Login",
+		"/keshavbhattad.pcbook.LaptopService/GetLaptop",
+	})
+
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	interceptor.SetAPIKeys(apiKeyStore, map[string]string{
+		"/keshavbhattad.pcbook.LaptopService/GetLaptop":    service.ScopeReadCatalog,
+		"/keshavbhattad.pcbook.LaptopService/CreateLaptop": service.ScopeWriteCatalog,
+		"/keshavbhattad.pcbook.LaptopService/SearchLaptop": service.ScopeReadCatalog,
+		"/keshavbhattad.pcbook.LaptopService/RateLaptop":   service.ScopeRate,
+	})
+
+	serverTLS, _ := testTLSConfigs(t)
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(serverTLS)),
+		grpc.ChainUnaryInterceptor(interceptor.Unary()),
+		grpc.ChainStreamInterceptor(interceptor.Stream()),
+	)
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, service.NewInMemoryRatingStore())
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterAuthServiceServer(grpcServer, service.NewAuthServer(userStore, jwtManager))
+	pb.RegisterAPIKeyServiceServer(grpcServer, service.NewAPIKeyServer(apiKeyStore))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	return listener.Addr().String(), userStore
+}
+
+func dialTestServer(t *testing.T, serverAddress string, options ...grpc.DialOption) *grpc.ClientConn {
+	_, clientTLS := testTLSConfigs(t)
+	options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+
+	conn, err := grpc.Dial(serverAddress, options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// dialTestAuthServer returns a connection logged in as the user
+func dialTestAuthServer(t *testing.T, serverAddress string, username string, refreshMargin time.Duration) *grpc.ClientConn {
+	authClient := client.NewAuthClient(dialTestServer(t, serverAddress), username, "secret")
+	interceptor := client.NewAuthInterceptor(authClient, refreshMargin)
+
+	return dialTestServer(
+		t,
+		serverAddress,
+		grpc.WithChainUnaryInterceptor(interceptor.Unary()),
+		grpc.WithChainStreamInterceptor(interceptor.Stream()),
+	)
+}
+
+// newTestAuthLaptopClient returns a client logged in as the user
+func newTestAuthLaptopClient(t *testing.T, serverAddress string, username string, refreshMargin time.Duration) pb.LaptopServiceClient {
+	return pb.NewLaptopServiceClient(dialTestAuthServer(t, serverAddress, username, refreshMargin))
+}
+
+func TestClientLogin(t *testing.T) {
+	t.Parallel()
+
+	serverAddress, _ := startTestAuthServer(t, time.Minute)
+	authClient := pb.NewAuthServiceClient(dialTestServer(t, serverAddress))
+
+	testCases := []struct {
+		name     string
+		username string
+		password string
+		code     codes.Code
+	}{
+		{"ok", "admin1", "secret", codes.OK},
+		{"wrong_password", "admin1", "wrong", codes.Unauthenticated},
+		{"unknown_user", "nobody", "secret", codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := authClient.Login(context.Background(), &pb.LoginRequest{Username: tc.username, Password: tc.password})
+			require.Equal(t, tc.code, status.Code(err))
+			if tc.code != codes.OK {
+				return
+			}
+
+			claims, err := service.NewJWTManager(testJWTSecret, time.Minute).Verify(res.GetAccessToken())
+			require.NoError(t, err)
+			require.Equal(t, "admin1", claims.Username)
+			require.Equal(t, "admin", claims.Role)
+			require.WithinDuration(t, time.Now().Add(time.Minute), res.GetExpiresAt().AsTime(), 2*time.Second)
+
+			_, err = service.NewJWTManager("other secret", time.Minute).Verify(res.GetAccessToken())
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestClientAuthorization(t *testing.T) {
+	t.Parallel()
+
+	serverAddress, _ := startTestAuthServer(t, time.Minute)
+	adminClient := newTestAuthLaptopClient(t, serverAddress, "admin1", time.Second)
+	userClient := newTestAuthLaptopClient(t, serverAddress, "user1", time.Second)
+	anonymousClient := pb.NewLaptopServiceClient(dialTestServer(t, serverAddress))
+
+	laptop := sample.NewLaptop()
+	req := &pb.CreateLaptopRequest{Laptop: laptop}
+
+	_, err := anonymousClient.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = userClient.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = adminClient.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = anonymousClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+
+	// the methods neither public nor in the role map are denied to everyone
+	_, err = anonymousClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = adminClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	search, err := userClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.NoError(t, err)
+
+	search, err = anonymousClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the ratings are given by the logged in user
+	rate, err := userClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8}))
+	res, err := rate.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetRatedCount())
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: "user1", Score: 6}))
+	res, err = rate.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetRatedCount())
+	require.NoError(t, rate.CloseSend())
+	_, err = rate.Recv()
+	require.Equal(t, io.EOF, err)
+
+	rate, err = userClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: "admin1", Score: 1}))
+	_, err = rate.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthInterceptorWithoutMetadata(t *testing.T) {
+	t.Parallel()
+
+	interceptor := service.NewAuthInterceptor(
+		service.NewJWTManager(testJWTSecret, time.Minute),
+		map[string][]string{"/keshavbhattad.pcbook.LaptopService/CreateLaptop": {"admin"}},
+		[]string{"/keshavbhattad.pcbook.LaptopService/GetLaptop"},
+	)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+
+	testCases := []struct {
+		method string
+		code   codes.Code
+	}{
+		{"/keshavbhattad.pcbook.LaptopService/GetLaptop", codes.OK},
+		{"/keshavbhattad.pcbook.LaptopService/CreateLaptop", codes.Unauthenticated},
+		{"/keshavbhattad.pcbook.LaptopService/DeleteLaptop", codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		info := &grpc.UnaryServerInfo{FullMethod: tc.method}
+		_, err := interceptor.Unary()(context.Background(), nil, info, handler)
+		require.Equal(t, tc.code, status.Code(err), tc.method)
+	}
+}
+
+func TestClientAuthRefresh(t *testing.T) {
+	t.Parallel()
+
+	serverAddress, userStore := startTestAuthServer(t, time.Minute)
+
+	testCases := []struct {
+		name          string
+		refreshMargin time.Duration
+		logins        int32
+	}{
+		{"valid", 10 * time.Second, 1},
+		// the token is always about to expire, so it is refreshed on each call
+		{"expiring", 2 * time.Minute, 3},
+	}
+
+	for _, tc := range testCases {
+		laptopClient := newTestAuthLaptopClient(t, serverAddress, "admin1", tc.refreshMargin)
+		finds := atomic.LoadInt32(&userStore.finds)
+
+		for i := 0; i < 3; i++ {
+			_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+			require.NoError(t, err)
+		}
+		require.Equal(t, tc.logins, atomic.LoadInt32(&userStore.finds)-finds, tc.name)
+	}
+}
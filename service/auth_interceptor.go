@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationHeader is the metadata carrying the access token, as
+// "Bearer <token>" or the token alone
+const authorizationHeader = "authorization"
+
+// apiKeyHeader is the metadata carrying the API key of a machine client
+const apiKeyHeader = "x-api-key"
+
+// AuthInterceptor checks that the caller of a method has one of the roles
+// allowed to call it, or an API key with the scope it requires. The public
+// methods can be called by anyone, without a token, and the methods that are
+// neither public nor in the role map by no one
+type AuthInterceptor struct {
+	jwtManager      *JWTManager
+	accessibleRoles map[string][]string
+	publicMethods   map[string]bool
+	apiKeyStore     APIKeyStore
+	requiredScopes  map[string]string
+}
+
+// NewAuthInterceptor takes the roles allowed to call each method, keyed by
+// the full method name, e.g. /keshavbhattad.pcbook.LaptopService/CreateLaptop,
+// and the full names of the public methods
+func NewAuthInterceptor(jwtManager *JWTManager, accessibleRoles map[string][]string, publicMethods []string) *AuthInterceptor {
+	interceptor := &AuthInterceptor{
+		jwtManager:      jwtManager,
+		accessibleRoles: accessibleRoles,
+		publicMethods:   make(map[string]bool, len(publicMethods)),
+	}
+	for _, method := range publicMethods {
+		interceptor.publicMethods[method] = true
+	}
+	return interceptor
+}
+
+// SetAPIKeys accepts the API keys of the store as an alternative to the
+// access tokens. A key can call the methods requiring one of its scopes,
+// keyed by the full method name like the roles. Methods without a scope
+// cannot be called with a key, even the public ones
+func (interceptor *AuthInterceptor) SetAPIKeys(apiKeyStore APIKeyStore, requiredScopes map[string]string) {
+	interceptor.apiKeyStore = apiKeyStore
+	interceptor.requiredScopes = requiredScopes
+}
+
+type claimsKey struct{}
+
+type apiKeyKey struct{}
+
+// ClaimsFromContext returns the claims of the token the call was authorized
+// with, or false if the method does not require a token
+func ClaimsFromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*UserClaims)
+	return claims, ok
+}
+
+// APIKeyFromContext returns the API key the call was authorized with, or
+// false if the call was not made with a key
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyKey{}).(*APIKey)
+	return apiKey, ok
+}
+
+// callerID returns the authenticated user of the call, or the requested user
+// when the method does not require a token. Acting as another user than the
+// authenticated one is denied. A machine client with an API key acts as the
+// key, unless the key has ScopeImpersonate, e.g. to import ratings, in which
+// case it acts as the requested user
+func callerID(ctx context.Context, requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+
+	if apiKey, ok := APIKeyFromContext(ctx); ok {
+		if requested == "" || requested == apiKey.UserID() {
+			return apiKey.UserID(), nil
+		}
+		if !apiKey.HasScope(ScopeImpersonate) {
+			return "", status.Errorf(codes.PermissionDenied, "Cannot act as user %s with an API key without the %s scope", requested, ScopeImpersonate)
+		}
+		return requested, nil
+	}
+
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return requested, nil
+	}
+	if requested != "" && requested != claims.Username {
+		return "", status.Errorf(codes.PermissionDenied, "Cannot act as user %s when logged in as %s", requested, claims.Username)
+	}
+	return claims.Username, nil
+}
+
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// contextStream replaces the context of a stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *contextStream) Context() context.Context {
+	return stream.ctx
+}
+
+// authorize returns the context with the claims of the token of the call, or
+// with its API key
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	md, hasMetadata := metadata.FromIncomingContext(ctx)
+
+	// a key is checked on every method, public or not, so that it only calls
+	// the methods of its scopes
+	if keys := md[apiKeyHeader]; len(keys) > 0 && len(md[authorizationHeader]) == 0 && interceptor.apiKeyStore != nil {
+		return interceptor.authorizeAPIKey(ctx, method, keys[0])
+	}
+
+	if interceptor.publicMethods[method] {
+		return ctx, nil
+	}
+
+	if !hasMetadata {
+		return nil, status.Error(codes.Unauthenticated, "Metadata is not provided")
+	}
+
+	// a method added to a service is denied until it is given roles
+	accessibleRoles, ok := interceptor.accessibleRoles[method]
+	if !ok {
+		log.Printf("Method %s is neither public nor in the role map", method)
+		return nil, status.Error(codes.PermissionDenied, "No permission to access this RPC")
+	}
+
+	values := md[authorizationHeader]
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "Authorization token is not provided")
+	}
+
+	accessToken := strings.TrimPrefix(values[0], "Bearer ")
+	claims, err := interceptor.jwtManager.Verify(accessToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "Access token is invalid: %v", err)
+	}
+
+	for _, role := range accessibleRoles {
+		if role == claims.Role {
+			return context.WithValue(ctx, claimsKey{}, claims), nil
+		}
+	}
+
+	log.Printf("User %s with role %s is denied %s", claims.Username, claims.Role, method)
+	return nil, status.Error(codes.PermissionDenied, "No permission to access this RPC")
+}
+
+// authorizeAPIKey returns the context with the API key if it has the scope
+// required by the method
+func (interceptor *AuthInterceptor) authorizeAPIKey(ctx context.Context, method string, key string) (context.Context, error) {
+	apiKey, err := interceptor.apiKeyStore.FindByHash(HashAPIKey(key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "API key is invalid")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Cannot find API key: %v", err)
+	}
+	if apiKey.IsRevoked() {
+		return nil, status.Error(codes.Unauthenticated, "API key is revoked")
+	}
+
+	scope := interceptor.requiredScopes[method]
+	if scope == "" || !apiKey.HasScope(scope) {
+		log.Printf("API key %s with scopes %v is denied %s", apiKey.ID, apiKey.Scopes, method)
+		return nil, status.Error(codes.PermissionDenied, "No permission to access this RPC")
+	}
+
+	return context.WithValue(ctx, apiKeyKey{}, apiKey), nil
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type AuthServer struct {
+	userStore  UserStore
+	jwtManager *JWTManager
+}
+
+func NewAuthServer(userStore UserStore, jwtManager *JWTManager) *AuthServer {
+	return &AuthServer{
+		userStore:  userStore,
+		jwtManager: jwtManager,
+	}
+}
+
+func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	log.Printf("Received login request for user: %s", req.GetUsername())
+
+	user, err := server.userStore.Find(req.GetUsername())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot find user: %v", err))
+	}
+
+	// the same error, after the same work, for an unknown user and a wrong
+	// password, so that the usernames cannot be guessed
+	if user == nil {
+		dummyUser().IsCorrectPassword(req.GetPassword())
+		return nil, logError(status.Error(codes.Unauthenticated, "Incorrect username or password"))
+	}
+	if !user.IsCorrectPassword(req.GetPassword()) {
+		return nil, logError(status.Error(codes.Unauthenticated, "Incorrect username or password"))
+	}
+
+	accessToken, expiresAt, err := server.jwtManager.Generate(user)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot generate access token: %v", err))
+	}
+
+	res := &pb.LoginResponse{
+		AccessToken: accessToken,
+		ExpiresAt:   timestamppb.New(expiresAt),
+	}
+	return res, nil
+}
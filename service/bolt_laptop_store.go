@@ -0,0 +1,478 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	bolt "go.etcd.io/bbolt"
+)
+
+var laptopBucket = []byte("laptops")
+
+// metaBucket holds the number of laptops, under laptopCountKey, which is
+// updated in the transactions adding and deleting laptops
+var (
+	metaBucket     = []byte("meta")
+	laptopCountKey = []byte("laptop_count")
+)
+
+// laptopIndex is a secondary index on one numeric laptop field. Every entry
+// key is the order-preserving encoding of the field value followed by the
+// laptop ID, so a range of values maps to a contiguous range of keys
+type laptopIndex struct {
+	bucket []byte
+	value  func(laptop *pb.Laptop) []byte
+}
+
+var (
+	priceIndex = laptopIndex{
+		bucket: []byte("index_price_inr"),
+		value:  func(laptop *pb.Laptop) []byte { return encodeFloat(laptop.GetPriceInr()) },
+	}
+	cpuCoresIndex = laptopIndex{
+		bucket: []byte("index_cpu_cores"),
+		value:  func(laptop *pb.Laptop) []byte { return encodeUint(uint64(laptop.GetCpu().GetNumberOfCores())) },
+	}
+	cpuGhzIndex = laptopIndex{
+		bucket: []byte("index_cpu_ghz"),
+		value:  func(laptop *pb.Laptop) []byte { return encodeFloat(laptop.GetCpu().GetMinGhz()) },
+	}
+	ramIndex = laptopIndex{
+		bucket: []byte("index_ram_bits"),
+		value:  func(laptop *pb.Laptop) []byte { return encodeUint(toBit(laptop.GetRam())) },
+	}
+
+	laptopIndexes = []laptopIndex{priceIndex, cpuCoresIndex, cpuGhzIndex, ramIndex}
+)
+
+// indexRange selects the index entries whose value lies between min and max,
+// both inclusive. A nil bound leaves that side of the range open
+type indexRange struct {
+	index laptopIndex
+	min   []byte
+	max   []byte
+}
+
+// BoltLaptopStore stores laptops in an embedded bbolt database and keeps
+// secondary indexes on the fields used by the search filter
+type BoltLaptopStore struct {
+	db *bolt.DB
+}
+
+func NewBoltLaptopStore(path string) (*BoltLaptopStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open laptop database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(laptopBucket); err != nil {
+			return err
+		}
+		for _, index := range laptopIndexes {
+			if _, err := tx.CreateBucketIfNotExists(index.bucket); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		// the databases created before the count was kept are counted once
+		if meta.Get(laptopCountKey) == nil {
+			return meta.Put(laptopCountKey, encodeUint(uint64(tx.Bucket(laptopBucket).Stats().KeyN)))
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Cannot create laptop buckets: %w", err)
+	}
+
+	return &BoltLaptopStore{db: db}, nil
+}
+
+func (store *BoltLaptopStore) Close() error {
+	return store.db.Close()
+}
+
+func (store *BoltLaptopStore) Save(laptop *pb.Laptop) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return saveLaptop(tx, laptop)
+	})
+}
+
+// SaveAll saves all laptops in a single transaction, which is much faster
+// than calling Save for each of them when loading a large catalog
+func (store *BoltLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, laptop := range laptops {
+			if err := saveLaptop(tx, laptop); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *BoltLaptopStore) Find(id string) (*pb.Laptop, error) {
+	var laptop *pb.Laptop
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		laptop, err = getLaptop(tx, []byte(id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if laptop == nil {
+		return nil, fmt.Errorf("Cannot find the laptop with ID %s: %w", id, ErrNotFound)
+	}
+	return laptop, nil
+}
+
+func (store *BoltLaptopStore) Update(laptop *pb.Laptop) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		old, err := getLaptop(tx, []byte(laptop.Id))
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return ErrNotFound
+		}
+
+		err = unindexLaptop(tx, old)
+		if err != nil {
+			return err
+		}
+
+		return putLaptop(tx, laptop)
+	})
+}
+
+func (store *BoltLaptopStore) Delete(id string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		old, err := getLaptop(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return ErrNotFound
+		}
+
+		err = unindexLaptop(tx, old)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(laptopBucket).Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		return addLaptopCount(tx, -1)
+	})
+}
+
+// Search answers the range conditions of the filter with index scans and
+// only loads the laptops that satisfy all of them. The page is sent after the
+// read transaction ends, so that a slow client does not keep it open, which
+// would stop the writers from growing the database
+func (store *BoltLaptopStore) Search(
+	ctx context.Context,
+	filter *pb.Filter,
+	options *SearchOptions,
+	found func(laptop *pb.Laptop) error,
+) (string, error) {
+	var page []*pb.Laptop
+	var nextPageToken string
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		ids, err := searchIndexes(ctx, tx, filterRanges(filter))
+		if err != nil {
+			return err
+		}
+
+		var qualified []*pb.Laptop
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return errors.New("Context is canceled")
+			}
+
+			laptop, err := getLaptop(tx, []byte(id))
+			if err != nil {
+				return err
+			}
+
+			if laptop != nil && isQualified(filter, laptop) && options.matches(laptop) {
+				qualified = append(qualified, laptop)
+			}
+		}
+
+		page, nextPageToken, err = sortAndPage(qualified, options)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, laptop := range page {
+		err = found(laptop)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return nextPageToken, nil
+}
+
+// Count returns the number of laptops in the store, which is kept in the
+// meta bucket
+func (store *BoltLaptopStore) Count() (int, error) {
+	count := 0
+	err := store.db.View(func(tx *bolt.Tx) error {
+		count = int(binary.BigEndian.Uint64(tx.Bucket(metaBucket).Get(laptopCountKey)))
+		return nil
+	})
+	return count, err
+}
+
+func (store *BoltLaptopStore) Aggregate(
+	ctx context.Context,
+	filter *pb.Filter,
+	query *LaptopQuery,
+	facets []string,
+) (*pb.AggregateLaptopsResponse, error) {
+	aggregator, err := newLaptopAggregator(facets)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.db.View(func(tx *bolt.Tx) error {
+		ids, err := searchIndexes(ctx, tx, filterRanges(filter))
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return errors.New("Context is canceled")
+			}
+
+			laptop, err := getLaptop(tx, []byte(id))
+			if err != nil {
+				return err
+			}
+
+			if laptop != nil && isQualified(filter, laptop) && query.Match(laptop) {
+				aggregator.add(laptop)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregator.result(), nil
+}
+
+func filterRanges(filter *pb.Filter) []indexRange {
+	var ranges []indexRange
+
+	if filter.GetMinPriceInr() > 0 || filter.GetMaxPriceInr() > 0 {
+		r := indexRange{index: priceIndex}
+		if filter.GetMinPriceInr() > 0 {
+			r.min = encodeFloat(filter.GetMinPriceInr())
+		}
+		if filter.GetMaxPriceInr() > 0 {
+			r.max = encodeFloat(filter.GetMaxPriceInr())
+		}
+		ranges = append(ranges, r)
+	}
+
+	if filter.GetMinCpuCores() > 0 || filter.GetMaxCpuCores() > 0 {
+		r := indexRange{index: cpuCoresIndex, min: encodeUint(uint64(filter.GetMinCpuCores()))}
+		if filter.GetMaxCpuCores() > 0 {
+			r.max = encodeUint(uint64(filter.GetMaxCpuCores()))
+		}
+		ranges = append(ranges, r)
+	}
+
+	if filter.GetMinCpuGhz() > 0 || filter.GetMaxCpuGhz() > 0 {
+		r := indexRange{index: cpuGhzIndex}
+		if filter.GetMinCpuGhz() > 0 {
+			r.min = encodeFloat(filter.GetMinCpuGhz())
+		}
+		if filter.GetMaxCpuGhz() > 0 {
+			r.max = encodeFloat(filter.GetMaxCpuGhz())
+		}
+		ranges = append(ranges, r)
+	}
+
+	minRam, maxRam := toBit(filter.GetMinRam()), toBit(filter.GetMaxRam())
+	if minRam > 0 || maxRam > 0 {
+		r := indexRange{index: ramIndex, min: encodeUint(minRam)}
+		if maxRam > 0 {
+			r.max = encodeUint(maxRam)
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+// searchIndexes scans every range and returns the sorted IDs of the laptops
+// that appear in all of them. Without any range every laptop is returned
+func searchIndexes(ctx context.Context, tx *bolt.Tx, ranges []indexRange) ([]string, error) {
+	if len(ranges) == 0 {
+		ranges = []indexRange{{index: priceIndex}}
+	}
+
+	var candidates map[string]bool
+	scanned := 0
+
+	for _, r := range ranges {
+		matches := make(map[string]bool)
+		cursor := tx.Bucket(r.index.bucket).Cursor()
+
+		var key []byte
+		if r.min != nil {
+			key, _ = cursor.Seek(r.min)
+		} else {
+			key, _ = cursor.First()
+		}
+
+		for ; key != nil; key, _ = cursor.Next() {
+			if r.max != nil && bytes.Compare(key[:8], r.max) > 0 {
+				break
+			}
+
+			id := string(key[8:])
+			if candidates == nil || candidates[id] {
+				matches[id] = true
+			}
+
+			scanned++
+			if scanned%4096 == 0 && ctx.Err() != nil {
+				return nil, errors.New("Context is canceled")
+			}
+		}
+
+		candidates = matches
+		if len(candidates) == 0 {
+			break
+		}
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func saveLaptop(tx *bolt.Tx, laptop *pb.Laptop) error {
+	if tx.Bucket(laptopBucket).Get([]byte(laptop.Id)) != nil {
+		return ErrAlreadyExists
+	}
+
+	err := putLaptop(tx, laptop)
+	if err != nil {
+		return err
+	}
+
+	return addLaptopCount(tx, 1)
+}
+
+// addLaptopCount changes the number of laptops kept in the meta bucket
+func addLaptopCount(tx *bolt.Tx, delta int64) error {
+	meta := tx.Bucket(metaBucket)
+	count := int64(binary.BigEndian.Uint64(meta.Get(laptopCountKey))) + delta
+
+	err := meta.Put(laptopCountKey, encodeUint(uint64(count)))
+	if err != nil {
+		return fmt.Errorf("Cannot update laptop count: %w", err)
+	}
+	return nil
+}
+
+func putLaptop(tx *bolt.Tx, laptop *pb.Laptop) error {
+	data, err := proto.Marshal(laptop)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal laptop: %w", err)
+	}
+
+	err = tx.Bucket(laptopBucket).Put([]byte(laptop.Id), data)
+	if err != nil {
+		return fmt.Errorf("Cannot save laptop: %w", err)
+	}
+
+	for _, index := range laptopIndexes {
+		err = tx.Bucket(index.bucket).Put(indexKey(index, laptop), []byte{})
+		if err != nil {
+			return fmt.Errorf("Cannot update index %s: %w", index.bucket, err)
+		}
+	}
+
+	return nil
+}
+
+func unindexLaptop(tx *bolt.Tx, laptop *pb.Laptop) error {
+	for _, index := range laptopIndexes {
+		err := tx.Bucket(index.bucket).Delete(indexKey(index, laptop))
+		if err != nil {
+			return fmt.Errorf("Cannot update index %s: %w", index.bucket, err)
+		}
+	}
+	return nil
+}
+
+func getLaptop(tx *bolt.Tx, id []byte) (*pb.Laptop, error) {
+	data := tx.Bucket(laptopBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+
+	laptop := &pb.Laptop{}
+	err := proto.Unmarshal(data, laptop)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal laptop: %w", err)
+	}
+	return laptop, nil
+}
+
+func indexKey(index laptopIndex, laptop *pb.Laptop) []byte {
+	return append(index.value(laptop), laptop.Id...)
+}
+
+func encodeUint(value uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, value)
+	return key
+}
+
+// encodeFloat maps value to 8 bytes that sort in the same order as the numbers
+func encodeFloat(value float64) []byte {
+	bits := math.Float64bits(value)
+	if bits&(1<<63) == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return encodeUint(bits)
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+var benchLaptops = flag.Int("bench-laptops", 1000000, "number of laptops loaded by the search benchmarks")
+
+func TestBoltLaptopStoreSearch(t *testing.T) {
+	t.Parallel()
+
+	boltStore, err := service.NewBoltLaptopStore(filepath.Join(t.TempDir(), "laptops.db"))
+	require.NoError(t, err)
+	defer boltStore.Close()
+
+	memoryStore := service.NewInMemoryLaptopStore()
+
+	laptops := make([]*pb.Laptop, 200)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		require.NoError(t, memoryStore.Save(laptops[i]))
+	}
+	require.NoError(t, boltStore.SaveAll(laptops))
+
+	err = boltStore.Save(laptops[0])
+	require.ErrorIs(t, err, service.ErrAlreadyExists)
+
+	laptops[1].PriceInr = 1
+	laptops[1].Cpu.NumberOfCores = 16
+	require.NoError(t, boltStore.Update(laptops[1]))
+	require.NoError(t, memoryStore.Update(laptops[1]))
+
+	require.NoError(t, boltStore.Delete(laptops[2].Id))
+	require.NoError(t, memoryStore.Delete(laptops[2].Id))
+
+	_, err = boltStore.Find(laptops[2].Id)
+	require.ErrorIs(t, err, service.ErrNotFound)
+
+	count, err := boltStore.Count()
+	require.NoError(t, err)
+	require.Equal(t, len(laptops)-1, count)
+
+	// a failed save leaves the count unchanged
+	require.ErrorIs(t, boltStore.SaveAll([]*pb.Laptop{sample.NewLaptop(), laptops[0]}), service.ErrAlreadyExists)
+	count, err = boltStore.Count()
+	require.NoError(t, err)
+	require.Equal(t, len(laptops)-1, count)
+
+	filters := []*pb.Filter{
+		{MaxPriceInr: 100000},
+		{MaxPriceInr: 80000, MinCpuCores: 4},
+		{MaxPriceInr: 90000, MinCpuCores: 4, MinCpuGhz: 2.5, MinRam: &pb.Memory{Value: 5, Unit: pb.Memory_GIGYBYTE}},
+		{MaxPriceInr: 10, MinCpuCores: 12},
+		{MaxPriceInr: 0},
+	}
+
+	for _, filter := range filters {
+		require.Equal(t, searchIDs(t, memoryStore, filter), searchIDs(t, boltStore, filter), "filter: %v", filter)
+	}
+}
+
+func searchIDs(t testing.TB, store service.LaptopStore, filter *pb.Filter) map[string]bool {
+	ids := make(map[string]bool)
+	_, err := store.Search(context.Background(), filter, nil, func(laptop *pb.Laptop) error {
+		ids[laptop.Id] = true
+		return nil
+	})
+	require.NoError(t, err)
+	return ids
+}
+
+var benchFilter = &pb.Filter{
+	MaxPriceInr: 55000,
+	MinCpuCores: 8,
+	MinCpuGhz:   3.3,
+	MinRam:      &pb.Memory{Value: 6, Unit: pb.Memory_GIGYBYTE},
+}
+
+func BenchmarkInMemoryLaptopStoreSearch(b *testing.B) {
+	store := service.NewInMemoryLaptopStore()
+	for i := 0; i < *benchLaptops; i++ {
+		require.NoError(b, store.Save(sample.NewLaptop()))
+	}
+
+	benchmarkSearch(b, store)
+}
+
+func BenchmarkBoltLaptopStoreSearch(b *testing.B) {
+	store, err := service.NewBoltLaptopStore(filepath.Join(b.TempDir(), "laptops.db"))
+	require.NoError(b, err)
+	defer store.Close()
+
+	batch := make([]*pb.Laptop, 0, 10000)
+	for i := 0; i < *benchLaptops; i++ {
+		batch = append(batch, sample.NewLaptop())
+		if len(batch) == cap(batch) || i == *benchLaptops-1 {
+			require.NoError(b, store.SaveAll(batch))
+			batch = batch[:0]
+		}
+	}
+
+	benchmarkSearch(b, store)
+}
+
+func benchmarkSearch(b *testing.B, store service.LaptopStore) {
+	// the in-memory store logs every laptop it finds, which would be measured
+	// instead of the search
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searchIDs(b, store, benchFilter)
+	}
+}
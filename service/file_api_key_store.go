@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileAPIKeyStore keeps the API keys in memory and writes all of them to a
+// JSON file on every change, which is read on startup. There are few keys,
+// and they rarely change
+type FileAPIKeyStore struct {
+	mutex  sync.Mutex
+	memory *InMemoryAPIKeyStore
+	path   string
+}
+
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	store := &FileAPIKeyStore{
+		memory: NewInMemoryAPIKeyStore(),
+		path:   path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read API key file: %w", err)
+	}
+
+	var apiKeys []*APIKey
+	err = json.Unmarshal(data, &apiKeys)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse API key file %s: %w", path, err)
+	}
+	for _, apiKey := range apiKeys {
+		err := store.memory.Save(apiKey)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load the API key %s: %w", apiKey.ID, err)
+		}
+	}
+
+	log.Printf("Loaded %d API keys from %s", len(apiKeys), path)
+	return store, nil
+}
+
+// Save writes the file with the new key before the key can be used
+func (store *FileAPIKeyStore) Save(apiKey *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	apiKeys, err := store.memory.List()
+	if err != nil {
+		return err
+	}
+	for _, other := range apiKeys {
+		if other.ID == apiKey.ID || other.Hash == apiKey.Hash {
+			return ErrAlreadyExists
+		}
+	}
+
+	err = store.write(append(apiKeys, apiKey))
+	if err != nil {
+		return err
+	}
+
+	return store.memory.Save(apiKey)
+}
+
+func (store *FileAPIKeyStore) Find(id string) (*APIKey, error) {
+	return store.memory.Find(id)
+}
+
+func (store *FileAPIKeyStore) FindByHash(hash string) (*APIKey, error) {
+	return store.memory.FindByHash(hash)
+}
+
+// Revoke writes the file with the revoked key before the key is rejected, so
+// that a revoked key stays revoked after a restart
+func (store *FileAPIKeyStore) Revoke(id string) (*APIKey, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	apiKey, err := store.memory.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.IsRevoked() {
+		return apiKey, nil
+	}
+	apiKey.RevokedAt = time.Now().UTC()
+
+	apiKeys, err := store.memory.List()
+	if err != nil {
+		return nil, err
+	}
+	for i, other := range apiKeys {
+		if other.ID == id {
+			apiKeys[i] = apiKey
+		}
+	}
+
+	err = store.write(apiKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	store.memory.put(apiKey)
+	return apiKey, nil
+}
+
+func (store *FileAPIKeyStore) List() ([]*APIKey, error) {
+	return store.memory.List()
+}
+
+// write replaces the file with the keys. It writes a temporary file first and
+// renames it, so that a crash never leaves a truncated file behind
+func (store *FileAPIKeyStore) write(apiKeys []*APIKey) error {
+	data, err := json.MarshalIndent(apiKeys, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Cannot marshal API keys: %w", err)
+	}
+
+	tmpPath := store.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot write API key file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, store.path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Cannot write API key file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package service_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestFileAPIKeyStoreReload(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+
+	store, err := service.NewFileAPIKeyStore(path)
+	require.NoError(t, err)
+
+	first, firstKey, err := service.NewAPIKey("importer", []string{service.ScopeReadCatalog}, "admin1")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(first))
+	second, _, err := service.NewAPIKey("uploader", []string{service.ScopeUploadImages}, "admin1")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(second))
+	require.ErrorIs(t, store.Save(first), service.ErrAlreadyExists)
+
+	revoked, err := store.Revoke(second.ID)
+	require.NoError(t, err)
+	require.True(t, revoked.IsRevoked())
+
+	reloaded, err := service.NewFileAPIKeyStore(path)
+	require.NoError(t, err)
+
+	apiKeys, err := reloaded.List()
+	require.NoError(t, err)
+	require.Len(t, apiKeys, 2)
+
+	found, err := reloaded.FindByHash(service.HashAPIKey(firstKey))
+	require.NoError(t, err)
+	require.Equal(t, first.ID, found.ID)
+	require.Equal(t, first.Scopes, found.Scopes)
+	require.False(t, found.IsRevoked())
+
+	found, err = reloaded.Find(second.ID)
+	require.NoError(t, err)
+	require.True(t, revoked.RevokedAt.Equal(found.RevokedAt))
+}
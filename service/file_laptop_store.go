@@ -0,0 +1,327 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/serializer"
+)
+
+const (
+	laptopLogFile = "laptops.log"
+
+	// DefaultCompactThreshold is the number of log entries written after the
+	// last compaction before the log is rewritten
+	DefaultCompactThreshold = 1000
+)
+
+const (
+	opSaveLaptop   byte = 'S'
+	opDeleteLaptop byte = 'D'
+)
+
+// FileLaptopStore keeps laptops in memory and records every change in an
+// append-only log of protobuf records, which is replayed on startup
+type FileLaptopStore struct {
+	mutex            sync.Mutex
+	memory           *InMemoryLaptopStore
+	logPath          string
+	logFile          *os.File
+	logSize          int64
+	logEntries       int
+	compactThreshold int
+}
+
+func NewFileLaptopStore(dataDir string, compactThreshold int) (*FileLaptopStore, error) {
+	err := os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create data directory: %w", err)
+	}
+
+	store := &FileLaptopStore{
+		memory:           NewInMemoryLaptopStore(),
+		logPath:          filepath.Join(dataDir, laptopLogFile),
+		compactThreshold: compactThreshold,
+	}
+
+	torn, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// the compaction drops the incomplete entry, the log is kept aside in
+	// case the entry was not the last one written
+	if torn {
+		backupPath := store.logPath + ".bak"
+		log.Printf("Keeping the log with the incomplete entry in %s", backupPath)
+		err = os.Rename(store.logPath, backupPath)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot keep log file: %w", err)
+		}
+	}
+
+	err = store.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded %d laptops from %s", len(store.memory.data), store.logPath)
+	return store, nil
+}
+
+func (store *FileLaptopStore) Save(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, err := store.memory.Find(laptop.Id); err == nil {
+		return ErrAlreadyExists
+	}
+
+	err := store.append(opSaveLaptop, laptop)
+	if err != nil {
+		return err
+	}
+
+	err = store.memory.Save(laptop)
+	if err != nil {
+		return err
+	}
+
+	return store.compactIfNeeded()
+}
+
+func (store *FileLaptopStore) Find(id string) (*pb.Laptop, error) {
+	return store.memory.Find(id)
+}
+
+func (store *FileLaptopStore) Update(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, err := store.memory.Find(laptop.Id); err != nil {
+		return ErrNotFound
+	}
+
+	err := store.append(opSaveLaptop, laptop)
+	if err != nil {
+		return err
+	}
+
+	err = store.memory.Update(laptop)
+	if err != nil {
+		return err
+	}
+
+	return store.compactIfNeeded()
+}
+
+func (store *FileLaptopStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, err := store.memory.Find(id); err != nil {
+		return ErrNotFound
+	}
+
+	err := store.append(opDeleteLaptop, &pb.Laptop{Id: id})
+	if err != nil {
+		return err
+	}
+
+	err = store.memory.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	return store.compactIfNeeded()
+}
+
+func (store *FileLaptopStore) Search(
+	ctx context.Context,
+	filter *pb.Filter,
+	options *SearchOptions,
+	found func(laptop *pb.Laptop) error,
+) (string, error) {
+	return store.memory.Search(ctx, filter, options, found)
+}
+
+func (store *FileLaptopStore) Aggregate(
+	ctx context.Context,
+	filter *pb.Filter,
+	query *LaptopQuery,
+	facets []string,
+) (*pb.AggregateLaptopsResponse, error) {
+	return store.memory.Aggregate(ctx, filter, query, facets)
+}
+
+func (store *FileLaptopStore) Count() (int, error) {
+	return store.memory.Count()
+}
+
+// Compact rewrites the log so that it only holds the laptops currently in the store
+func (store *FileLaptopStore) Compact() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.compact()
+}
+
+// Close compacts the log and closes the underlying file
+func (store *FileLaptopStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.compact()
+	if err != nil {
+		return err
+	}
+
+	return store.logFile.Close()
+}
+
+func (store *FileLaptopStore) append(op byte, laptop *pb.Laptop) error {
+	var entry bytes.Buffer
+	entry.WriteByte(op)
+
+	err := serializer.WriteProtobufToBinaryStream(&entry, laptop)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.logFile.Write(entry.Bytes())
+	if err == nil {
+		err = store.logFile.Sync()
+	}
+	if err != nil {
+		// drop what was written of the entry, so that the next one is read
+		// from its start
+		store.logFile.Truncate(store.logSize)
+		return fmt.Errorf("Cannot write log entry: %w", err)
+	}
+
+	store.logSize += int64(entry.Len())
+	store.logEntries++
+	return nil
+}
+
+func (store *FileLaptopStore) compactIfNeeded() error {
+	if store.logEntries < store.compactThreshold {
+		return nil
+	}
+	return store.compact()
+}
+
+// load replays the log into memory, and reports whether it ends with an
+// incomplete entry, left behind by a crash in the middle of a write. The
+// entry is skipped. Any other corrupt entry fails the load, since the entries
+// after it cannot be read
+func (store *FileLaptopStore) load() (bool, error) {
+	file, err := os.Open(store.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Cannot open log file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for {
+		op, err := reader.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Cannot read log entry: %w", err)
+		}
+
+		laptop := &pb.Laptop{}
+		err = serializer.ReadProtobufFromBinaryStream(reader, laptop)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("Discarding incomplete entry at the end of %s", store.logPath)
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Cannot read log entry of %s: %w", store.logPath, err)
+		}
+
+		switch op {
+		case opSaveLaptop:
+			store.memory.data[laptop.Id] = laptop
+		case opDeleteLaptop:
+			delete(store.memory.data, laptop.Id)
+		default:
+			return false, fmt.Errorf("Unknown log entry type %q in %s", op, store.logPath)
+		}
+	}
+}
+
+// compact writes a fresh log holding one entry per laptop next to the current
+// one and atomically replaces it
+func (store *FileLaptopStore) compact() error {
+	tmpPath := store.logPath + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("Cannot create log file: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, laptop := range store.memory.data {
+		err = writer.WriteByte(opSaveLaptop)
+		if err == nil {
+			err = serializer.WriteProtobufToBinaryStream(writer, laptop)
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("Cannot write compacted log: %w", err)
+		}
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Cannot write compacted log: %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("Cannot close compacted log: %w", err)
+	}
+
+	err = os.Rename(tmpPath, store.logPath)
+	if err != nil {
+		return fmt.Errorf("Cannot replace log file: %w", err)
+	}
+
+	if store.logFile != nil {
+		store.logFile.Close()
+	}
+
+	store.logFile, err = os.OpenFile(store.logPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot open log file: %w", err)
+	}
+
+	stat, err := store.logFile.Stat()
+	if err != nil {
+		return fmt.Errorf("Cannot read log file: %w", err)
+	}
+
+	store.logSize = stat.Size()
+	store.logEntries = 0
+	return nil
+}
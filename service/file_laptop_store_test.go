@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/serializer"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestFileLaptopStoreReload(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	store, err := service.NewFileLaptopStore(dataDir, 3)
+	require.NoError(t, err)
+
+	laptops := make([]*pb.Laptop, 5)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		err = store.Save(laptops[i])
+		require.NoError(t, err)
+	}
+
+	err = store.Save(laptops[0])
+	require.ErrorIs(t, err, service.ErrAlreadyExists)
+
+	laptops[1].PriceInr = 12345
+	err = store.Update(laptops[1])
+	require.NoError(t, err)
+
+	err = store.Delete(laptops[2].Id)
+	require.NoError(t, err)
+
+	// simulate a crash in the middle of writing a log entry
+	logFile, err := os.OpenFile(filepath.Join(dataDir, "laptops.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = logFile.Write([]byte{'S', 0x7f, 0x01})
+	require.NoError(t, err)
+	require.NoError(t, logFile.Close())
+
+	reloaded, err := service.NewFileLaptopStore(dataDir, 3)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	_, err = os.Stat(filepath.Join(dataDir, "laptops.log.bak"))
+	require.NoError(t, err)
+
+	for i, laptop := range laptops {
+		other, err := reloaded.Find(laptop.Id)
+		if i == 2 {
+			require.ErrorIs(t, err, service.ErrNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		requireSameLaptop(t, laptop, other)
+	}
+
+	found := 0
+	_, err = reloaded.Search(context.Background(), &pb.Filter{MaxPriceInr: 100000}, nil, func(laptop *pb.Laptop) error {
+		found++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(laptops)-1, found)
+}
+
+func TestFileLaptopStoreCorrupt(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	store, err := service.NewFileLaptopStore(dataDir, 100)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(sample.NewLaptop()))
+
+	// corrupt the size of an entry followed by valid ones
+	logFile, err := os.OpenFile(filepath.Join(dataDir, "laptops.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = logFile.Write([]byte{'S', 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})
+	require.NoError(t, err)
+	require.NoError(t, logFile.Close())
+	require.NoError(t, store.Save(sample.NewLaptop()))
+
+	logPath := filepath.Join(dataDir, "laptops.log")
+	data, err := ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+
+	// the load fails instead of dropping the entries after the corrupt one
+	_, err = service.NewFileLaptopStore(dataDir, 100)
+	require.ErrorIs(t, err, serializer.ErrInvalidRecord)
+
+	other, err := ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	require.Equal(t, data, other)
+}
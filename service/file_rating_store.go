@@ -0,0 +1,365 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ratingLogFile      = "ratings.log"
+	ratingSnapshotFile = "ratings.snapshot"
+
+	// DefaultRatingSnapshotInterval is the number of ratings added after the
+	// last snapshot before a new one is written
+	DefaultRatingSnapshotInterval = 1000
+)
+
+// FileRatingStore records every rating in an append-only log of JSON lines,
+// which is kept for ListRatings. The aggregates are kept in memory and
+// snapshotted to disk every few ratings, so that only the ratings added after
+// the last snapshot are replayed on startup
+type FileRatingStore struct {
+	mutex            sync.Mutex
+	memory           *InMemoryRatingStore
+	logPath          string
+	snapshotPath     string
+	logFile          *os.File
+	logSize          int64
+	snapshotInterval int
+	// pending is the number of ratings added since the last snapshot
+	pending int
+}
+
+// ratingSnapshot holds the score of each user for each laptop, as of the
+// first logSize bytes of the log
+type ratingSnapshot struct {
+	LogSize int64                         `json:"log_size"`
+	Scores  map[string]map[string]float64 `json:"scores"`
+}
+
+func NewFileRatingStore(dataDir string, snapshotInterval int) (*FileRatingStore, error) {
+	err := os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create data directory: %w", err)
+	}
+
+	store := &FileRatingStore{
+		memory:           NewInMemoryRatingStore(),
+		logPath:          filepath.Join(dataDir, ratingLogFile),
+		snapshotPath:     filepath.Join(dataDir, ratingSnapshotFile),
+		snapshotInterval: snapshotInterval,
+	}
+
+	err = store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	store.logFile, err = os.OpenFile(store.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open log file: %w", err)
+	}
+
+	if store.pending > 0 {
+		err = store.snapshot()
+		if err != nil {
+			store.logFile.Close()
+			return nil, err
+		}
+	}
+
+	log.Printf("Loaded the ratings of %d laptops from %s", len(store.memory.rating), store.logPath)
+	return store, nil
+}
+
+func (store *FileRatingStore) Add(laptopID string, userID string, score float64) (*Rating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	event := &RatingEvent{
+		LaptopID: laptopID,
+		UserID:   userID,
+		Score:    score,
+		Time:     time.Now().UTC(),
+	}
+
+	err := store.append(event)
+	if err != nil {
+		return nil, err
+	}
+
+	store.memory.mutex.Lock()
+	rating := store.memory.apply(event)
+	store.memory.mutex.Unlock()
+
+	if store.pending >= store.snapshotInterval {
+		err = store.snapshot()
+		if err != nil {
+			// the rating is in the log, the next snapshot catches up
+			log.Printf("Cannot snapshot ratings: %v", err)
+		}
+	}
+
+	return rating, nil
+}
+
+func (store *FileRatingStore) Find(laptopID string) (*Rating, error) {
+	return store.memory.Find(laptopID)
+}
+
+// SetPrior changes the prior of the weighted averages of TopRated, which is
+// DefaultRatingPrior by default
+func (store *FileRatingStore) SetPrior(prior RatingPrior) {
+	store.memory.SetPrior(prior)
+}
+
+func (store *FileRatingStore) Count() (int, int) {
+	return store.memory.Count()
+}
+
+func (store *FileRatingStore) TopRated(minCount uint32, found func(entry *RankedRating) bool) {
+	store.memory.TopRated(minCount, found)
+}
+
+// ListRatings reads the ratings from the log. Ratings added while it runs are
+// not listed
+func (store *FileRatingStore) ListRatings(
+	ctx context.Context,
+	from time.Time,
+	to time.Time,
+	found func(event *RatingEvent) error,
+) error {
+	store.mutex.Lock()
+	size := store.logSize
+	store.mutex.Unlock()
+
+	file, err := os.Open(store.logPath)
+	if err != nil {
+		return fmt.Errorf("Cannot open log file: %w", err)
+	}
+	defer file.Close()
+
+	return readRatingLog(io.LimitReader(file, size), func(event *RatingEvent, end int64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !event.between(from, to) {
+			return nil
+		}
+		return found(event)
+	})
+}
+
+// Snapshot writes the aggregates to disk, so that the log is not replayed on
+// the next startup
+func (store *FileRatingStore) Snapshot() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.snapshot()
+}
+
+// Close snapshots the aggregates and closes the log
+func (store *FileRatingStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return store.logFile.Close()
+}
+
+func (store *FileRatingStore) append(event *RatingEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal rating: %w", err)
+	}
+	data = append(data, '\n')
+
+	_, err = store.logFile.Write(data)
+	if err == nil {
+		err = store.logFile.Sync()
+	}
+	if err != nil {
+		// drop what was written of the entry, so that the next one starts a line
+		store.logFile.Truncate(store.logSize)
+		return fmt.Errorf("Cannot write log entry: %w", err)
+	}
+
+	store.logSize += int64(len(data))
+	store.pending++
+	return nil
+}
+
+// readRatingLog calls found with each complete entry of the log and the
+// offset of its end
+func readRatingLog(reader io.Reader, found func(event *RatingEvent, end int64) error) error {
+	return readLogLines(reader, func(line []byte, end int64) error {
+		event := &RatingEvent{}
+		err := json.Unmarshal(line, event)
+		if err != nil {
+			return fmt.Errorf("Cannot parse log entry ending at offset %d: %w", end, err)
+		}
+		return found(event, end)
+	})
+}
+
+// readLogLines calls found with each line of a log of JSON lines and the
+// offset of its end. A line without its newline at the end of the log, left
+// behind by a crash in the middle of a write, is not an entry
+func readLogLines(reader io.Reader, found func(line []byte, end int64) error) error {
+	buffered := bufio.NewReader(reader)
+	var offset int64
+
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot read log entry: %w", err)
+		}
+		offset += int64(len(line))
+
+		err = found(line, offset)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// load restores the last snapshot and replays the log written after it. The
+// log is truncated after its last complete entry
+func (store *FileRatingStore) load() error {
+	snapshot, err := store.readSnapshot()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(store.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot open log file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Cannot read log file: %w", err)
+	}
+
+	if snapshot != nil && snapshot.LogSize <= stat.Size() {
+		for laptopID, scores := range snapshot.Scores {
+			for userID, score := range scores {
+				store.memory.apply(&RatingEvent{LaptopID: laptopID, UserID: userID, Score: score})
+			}
+		}
+		store.logSize = snapshot.LogSize
+	} else if snapshot != nil {
+		log.Printf("Ignoring %s, which is ahead of %s", store.snapshotPath, store.logPath)
+	}
+
+	_, err = file.Seek(store.logSize, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("Cannot read log file: %w", err)
+	}
+
+	start := store.logSize
+	err = readRatingLog(file, func(event *RatingEvent, end int64) error {
+		store.memory.apply(event)
+		store.logSize = start + end
+		store.pending++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if store.logSize < stat.Size() {
+		log.Printf("Discarding incomplete entry at the end of %s", store.logPath)
+		err = os.Truncate(store.logPath, store.logSize)
+		if err != nil {
+			return fmt.Errorf("Cannot truncate log file: %w", err)
+		}
+	}
+	return nil
+}
+
+// readSnapshot returns the last snapshot, or nil if there is none or it
+// cannot be read, in which case the whole log is replayed
+func (store *FileRatingStore) readSnapshot() (*ratingSnapshot, error) {
+	data, err := ioutil.ReadFile(store.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read snapshot file: %w", err)
+	}
+
+	snapshot := &ratingSnapshot{}
+	err = json.Unmarshal(data, snapshot)
+	if err != nil {
+		log.Printf("Ignoring invalid snapshot %s: %v", store.snapshotPath, err)
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+// snapshot writes the scores next to the current snapshot and atomically
+// replaces it
+func (store *FileRatingStore) snapshot() error {
+	store.memory.mutex.RLock()
+	data, err := json.Marshal(&ratingSnapshot{
+		LogSize: store.logSize,
+		Scores:  store.memory.scores,
+	})
+	store.memory.mutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("Cannot marshal snapshot: %w", err)
+	}
+
+	tmpPath := store.snapshotPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("Cannot create snapshot file: %w", err)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("Cannot write snapshot file: %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Cannot close snapshot file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, store.snapshotPath)
+	if err != nil {
+		return fmt.Errorf("Cannot replace snapshot file: %w", err)
+	}
+
+	store.pending = 0
+	return nil
+}
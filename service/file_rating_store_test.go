@@ -0,0 +1,134 @@
+package service_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func listRatings(t *testing.T, store service.RatingStore, from time.Time, to time.Time) []*service.RatingEvent {
+	var events []*service.RatingEvent
+	err := store.ListRatings(context.Background(), from, to, func(event *service.RatingEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	require.NoError(t, err)
+	return events
+}
+
+func TestFileRatingStoreReload(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	store, err := service.NewFileRatingStore(dataDir, 3)
+	require.NoError(t, err)
+
+	scores := []struct {
+		laptopID string
+		userID   string
+		score    float64
+	}{
+		{"laptop-1", "user-1", 4},
+		{"laptop-1", "user-2", 6},
+		{"laptop-2", "user-1", 9},
+		{"laptop-1", "user-3", 8},
+		// replaces the first score of user-1
+		{"laptop-1", "user-1", 10},
+	}
+
+	var middle time.Time
+	for i, s := range scores {
+		if i == 3 {
+			time.Sleep(time.Millisecond)
+			middle = time.Now()
+			time.Sleep(time.Millisecond)
+		}
+		_, err := store.Add(s.laptopID, s.userID, s.score)
+		require.NoError(t, err)
+	}
+
+	// the last two ratings are only in the log, after the snapshot
+	_, err = os.Stat(filepath.Join(dataDir, "ratings.snapshot"))
+	require.NoError(t, err)
+
+	// simulate a crash in the middle of writing a log entry
+	logFile, err := os.OpenFile(filepath.Join(dataDir, "ratings.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = logFile.Write([]byte(`{"laptop_id":"laptop-2","us`))
+	require.NoError(t, err)
+	require.NoError(t, logFile.Close())
+
+	reloaded, err := service.NewFileRatingStore(dataDir, 3)
+	require.NoError(t, err)
+
+	rating, err := reloaded.Find("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.Count)
+	require.Equal(t, 8.0, rating.Mean())
+	require.Equal(t, map[float64]uint32{6: 1, 8: 1, 10: 1}, rating.Histogram)
+
+	rating, err = reloaded.Find("laptop-2")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), rating.Count)
+
+	events := listRatings(t, reloaded, time.Time{}, time.Time{})
+	require.Len(t, events, len(scores))
+	for i, s := range scores {
+		require.Equal(t, s.laptopID, events[i].LaptopID)
+		require.Equal(t, s.userID, events[i].UserID)
+		require.Equal(t, s.score, events[i].Score)
+	}
+
+	require.Len(t, listRatings(t, reloaded, time.Time{}, middle), 3)
+	require.Len(t, listRatings(t, reloaded, middle, time.Time{}), 2)
+	require.Len(t, listRatings(t, reloaded, middle, time.Now().Add(time.Hour)), 2)
+
+	// the incomplete entry is dropped, so new ratings are readable
+	_, err = reloaded.Add("laptop-2", "user-2", 5)
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Close())
+
+	reloaded, err = service.NewFileRatingStore(dataDir, 3)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	rating, err = reloaded.Find("laptop-2")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+	require.Equal(t, 7.0, rating.Mean())
+	require.Len(t, listRatings(t, reloaded, time.Time{}, time.Time{}), len(scores)+1)
+}
+
+func TestFileRatingStoreWithoutSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	store, err := service.NewFileRatingStore(dataDir, 100)
+	require.NoError(t, err)
+
+	_, err = store.Add("laptop-1", "user-1", 3)
+	require.NoError(t, err)
+	_, err = store.Add("laptop-1", "user-2", 5)
+	require.NoError(t, err)
+
+	// a snapshot that cannot be read is ignored and the whole log is replayed
+	err = ioutil.WriteFile(filepath.Join(dataDir, "ratings.snapshot"), []byte("{"), 0644)
+	require.NoError(t, err)
+
+	reloaded, err := service.NewFileRatingStore(dataDir, 100)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	rating, err := reloaded.Find("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+	require.Equal(t, 4.0, rating.Mean())
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const reviewLogFile = "reviews.log"
+
+// FileReviewStore keeps reviews in memory and records every review and vote
+// in an append-only log of JSON lines, which is replayed on startup
+type FileReviewStore struct {
+	mutex   sync.Mutex
+	memory  *InMemoryReviewStore
+	logPath string
+	logFile *os.File
+	logSize int64
+}
+
+// reviewLogEntry is a line of the review log, holding either a saved review
+// or a vote
+type reviewLogEntry struct {
+	Review *Review     `json:"review,omitempty"`
+	Vote   *reviewVote `json:"vote,omitempty"`
+}
+
+type reviewVote struct {
+	ReviewID string `json:"review_id"`
+	UserID   string `json:"user_id"`
+	Helpful  bool   `json:"helpful"`
+}
+
+func NewFileReviewStore(dataDir string) (*FileReviewStore, error) {
+	err := os.MkdirAll(dataDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create data directory: %w", err)
+	}
+
+	store := &FileReviewStore{
+		memory:  NewInMemoryReviewStore(),
+		logPath: filepath.Join(dataDir, reviewLogFile),
+	}
+
+	err = store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	store.logFile, err = os.OpenFile(store.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open log file: %w", err)
+	}
+
+	log.Printf("Loaded %d reviews from %s", len(store.memory.reviews), store.logPath)
+	return store, nil
+}
+
+func (store *FileReviewStore) Save(review *Review) (*Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// the writes are serialized by the mutex of the file store, so the review
+	// does not change between next and put
+	store.memory.mutex.RLock()
+	saved := store.memory.next(review)
+	store.memory.mutex.RUnlock()
+
+	err := store.append(&reviewLogEntry{Review: saved})
+	if err != nil {
+		return nil, err
+	}
+
+	store.memory.mutex.Lock()
+	store.memory.put(saved)
+	store.memory.mutex.Unlock()
+
+	other := *saved
+	return &other, nil
+}
+
+func (store *FileReviewStore) Find(reviewID string) (*Review, error) {
+	return store.memory.Find(reviewID)
+}
+
+func (store *FileReviewStore) List(laptopID string, options *ReviewListOptions) ([]*Review, string, error) {
+	return store.memory.List(laptopID, options)
+}
+
+func (store *FileReviewStore) Vote(reviewID string, userID string, helpful bool) (*Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.memory.mutex.RLock()
+	err := store.memory.checkVote(reviewID, userID)
+	store.memory.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.append(&reviewLogEntry{Vote: &reviewVote{ReviewID: reviewID, UserID: userID, Helpful: helpful}})
+	if err != nil {
+		return nil, err
+	}
+
+	return store.memory.Vote(reviewID, userID, helpful)
+}
+
+// Close closes the log
+func (store *FileReviewStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.logFile.Close()
+}
+
+func (store *FileReviewStore) append(entry *reviewLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal review: %w", err)
+	}
+	data = append(data, '\n')
+
+	_, err = store.logFile.Write(data)
+	if err == nil {
+		err = store.logFile.Sync()
+	}
+	if err != nil {
+		// drop what was written of the entry, so that the next one starts a line
+		store.logFile.Truncate(store.logSize)
+		return fmt.Errorf("Cannot write log entry: %w", err)
+	}
+
+	store.logSize += int64(len(data))
+	return nil
+}
+
+// load replays the log. The log is truncated after its last complete entry
+func (store *FileReviewStore) load() error {
+	file, err := os.Open(store.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot open log file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Cannot read log file: %w", err)
+	}
+
+	err = readLogLines(file, func(line []byte, end int64) error {
+		entry := &reviewLogEntry{}
+		err := json.Unmarshal(line, entry)
+		if err != nil {
+			return fmt.Errorf("Cannot parse log entry ending at offset %d: %w", end, err)
+		}
+
+		switch {
+		case entry.Review != nil:
+			store.memory.put(entry.Review)
+		case entry.Vote != nil:
+			_, err = store.memory.Vote(entry.Vote.ReviewID, entry.Vote.UserID, entry.Vote.Helpful)
+			if err != nil {
+				return fmt.Errorf("Cannot replay log entry ending at offset %d: %w", end, err)
+			}
+		}
+
+		store.logSize = end
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if store.logSize < stat.Size() {
+		log.Printf("Discarding incomplete entry at the end of %s", store.logPath)
+		err = os.Truncate(store.logPath, store.logSize)
+		if err != nil {
+			return fmt.Errorf("Cannot truncate log file: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestFileReviewStoreReload(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	store, err := service.NewFileReviewStore(dataDir)
+	require.NoError(t, err)
+
+	first, err := store.Save(&service.Review{LaptopID: "laptop-1", Author: "user-1", Title: "Good", Body: "Good laptop", Score: 7})
+	require.NoError(t, err)
+	second, err := store.Save(&service.Review{LaptopID: "laptop-1", Author: "user-2", Title: "Bad", Body: "Bad laptop", Score: 2})
+	require.NoError(t, err)
+
+	_, err = store.Vote(first.ID, "user-2", true)
+	require.NoError(t, err)
+	_, err = store.Vote(first.ID, "user-3", true)
+	require.NoError(t, err)
+	_, err = store.Vote(first.ID, "user-3", false)
+	require.NoError(t, err)
+	_, err = store.Vote(second.ID, "user-2", true)
+	require.ErrorIs(t, err, service.ErrOwnReview)
+
+	// a new review of user-1 replaces the first one and keeps its votes
+	updated, err := store.Save(&service.Review{LaptopID: "laptop-1", Author: "user-1", Title: "Great", Body: "Great laptop", Score: 9})
+	require.NoError(t, err)
+	require.Equal(t, first.ID, updated.ID)
+	require.Equal(t, uint32(1), updated.HelpfulCount)
+	require.NoError(t, store.Close())
+
+	// simulate a crash in the middle of writing a log entry
+	logFile, err := os.OpenFile(filepath.Join(dataDir, "reviews.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = logFile.Write([]byte(`{"vote":{"review_id":`))
+	require.NoError(t, err)
+	require.NoError(t, logFile.Close())
+
+	reloaded, err := service.NewFileReviewStore(dataDir)
+	require.NoError(t, err)
+
+	review, err := reloaded.Find(first.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Great", review.Title)
+	require.Equal(t, 9.0, review.Score)
+	require.Equal(t, uint32(1), review.HelpfulCount)
+	require.True(t, updated.SubmittedAt.Equal(review.SubmittedAt))
+
+	reviews, _, err := reloaded.List("laptop-1", nil)
+	require.NoError(t, err)
+	require.Len(t, reviews, 2)
+
+	// the incomplete entry is dropped, so new votes are readable
+	_, err = reloaded.Vote(second.ID, "user-1", true)
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Close())
+
+	reloaded, err = service.NewFileReviewStore(dataDir)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	review, err = reloaded.Find(second.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), review.HelpfulCount)
+}
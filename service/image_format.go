@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnsupportedImage  = errors.New("Unsupported image format")
+	ErrImageTypeMismatch = errors.New("Image type does not match the image content")
+)
+
+// imageHeaderSize is the number of leading bytes needed to detect the format of an image
+const imageHeaderSize = 12
+
+// imageExtPattern matches the file extensions an image store accepts
+var imageExtPattern = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// imageFormat is an image format accepted by UploadImage
+type imageFormat struct {
+	mimeType string
+	// extension is the one used to store the image, whatever the client sent
+	extension string
+	// names are the image types a client may declare for this format
+	names []string
+	match func(header []byte) bool
+}
+
+var imageFormats = []*imageFormat{
+	{
+		mimeType:  "image/jpeg",
+		extension: ".jpeg",
+		names:     []string{"jpeg", "jpg", "jpe", "image/jpeg"},
+		match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF})
+		},
+	},
+	{
+		mimeType:  "image/png",
+		extension: ".png",
+		names:     []string{"png", "image/png"},
+		match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n"))
+		},
+	},
+	{
+		mimeType:  "image/gif",
+		extension: ".gif",
+		names:     []string{"gif", "image/gif"},
+		match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a"))
+		},
+	},
+	{
+		mimeType:  "image/webp",
+		extension: ".webp",
+		names:     []string{"webp", "image/webp"},
+		match: func(header []byte) bool {
+			return len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP"))
+		},
+	},
+}
+
+// detectImageFormat finds the format of an image from its first bytes and
+// checks it against the image type declared by the client, which can be an
+// extension like ".jpg" or a MIME type. An empty declared type is not checked
+func detectImageFormat(declaredType string, header []byte) (*imageFormat, error) {
+	var format *imageFormat
+	for _, f := range imageFormats {
+		if f.match(header) {
+			format = f
+			break
+		}
+	}
+
+	if format == nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	declared := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(declaredType)), ".")
+	if declared != "" && !containsFold(format.names, declared) {
+		return nil, fmt.Errorf("%w: declared %q but the content is %s", ErrImageTypeMismatch, declaredType, format.mimeType)
+	}
+
+	return format, nil
+}
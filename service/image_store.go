@@ -2,66 +2,591 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// imageUploadPrefix starts the name of the temporary files of images being uploaded
+const imageUploadPrefix = "upload-"
+
+// imageMetadataExt is the extension of the file holding the metadata of an
+// image, which is kept next to the image file
+const imageMetadataExt = ".json"
+
+// ImageStore keeps the images of the laptops. Its methods take the context of
+// the call, which bounds the requests of the stores that are remote
 type ImageStore interface {
-	Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error)
+	// Create starts a new image of the laptop, stored with the imageType
+	// extension. The data written to the returned writer only becomes part of
+	// the store once it is committed
+	Create(ctx context.Context, laptopID string, imageType string, mimeType string) (ImageWriter, error)
+	// CreateVariant starts a variant of the image. A committed variant
+	// replaces the previous one with the same name
+	CreateVariant(ctx context.Context, imageID string, variant string, imageType string, mimeType string) (ImageWriter, error)
+	Find(ctx context.Context, imageID string) (*ImageInfo, error)
+	// List returns the images of the laptop, without their variants
+	List(ctx context.Context, laptopID string) ([]*ImageInfo, error)
+	// Open returns the image info together with a reader of the image data,
+	// which the caller must close
+	Open(ctx context.Context, imageID string) (*ImageInfo, io.ReadCloser, error)
+	// OpenVariant is like Open for a variant of the image
+	OpenVariant(ctx context.Context, imageID string, variant string) (*ImageInfo, io.ReadCloser, error)
+	// Delete removes the image together with its variants
+	Delete(ctx context.Context, imageID string) error
+}
+
+// ImageWriter receives the data of an image while it is uploaded
+type ImageWriter interface {
+	io.Writer
+	// Commit adds the image to the store and returns its info
+	Commit() (*ImageInfo, error)
+	// Abort discards the data written so far. It does nothing after Commit
+	Abort() error
+}
+
+// SaveImage detects the format of the image data, checks it against the
+// declared image type and copies the whole data into a new image of the store
+func SaveImage(ctx context.Context, store ImageStore, laptopID string, imageType string, imageData io.Reader) (*ImageInfo, error) {
+	header := make([]byte, imageHeaderSize)
+	n, err := io.ReadFull(imageData, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("Cannot read image data: %w", err)
+	}
+	header = header[:n]
+
+	format, err := detectImageFormat(imageType, header)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := store.Create(ctx, laptopID, format.extension, format.mimeType)
+	if err != nil {
+		return nil, err
+	}
+	defer writer.Abort()
+
+	_, err = io.Copy(writer, io.MultiReader(bytes.NewReader(header), imageData))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot write image data: %w", err)
+	}
+
+	return writer.Commit()
 }
 
 type DiskImageStore struct {
 	mutex       sync.RWMutex
 	imageFolder string
 	images      map[string]*ImageInfo
+	// variants maps an image ID to the IDs of its variants by name
+	variants map[string]map[string]string
+	// refs counts the images sharing each file
+	refs map[string]int
 }
 
 type ImageInfo struct {
-	LaptopID string
-	Type     string
-	Path     string
+	ID         string    `json:"id"`
+	LaptopID   string    `json:"laptop_id"`
+	Type       string    `json:"type"`
+	MimeType   string    `json:"mime_type"`
+	Path       string    `json:"-"`
+	FileName   string    `json:"file_name"`
+	Size       int       `json:"size"`
+	Hash       string    `json:"sha256"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	// ParentID and Variant are set for a variant of the image ParentID
+	ParentID string `json:"parent_id,omitempty"`
+	Variant  string `json:"variant,omitempty"`
+	// Variants are the names of the variants of an image
+	Variants []string `json:"-"`
+	// Deduplicated is set by Commit when the same data was already in the
+	// store, so the image shares its file with other images
+	Deduplicated bool `json:"-"`
+}
+
+// ReconcileReport lists the inconsistencies found between the image files and their metadata
+type ReconcileReport struct {
+	// OrphanFiles are files in the image folder that no metadata refers to
+	OrphanFiles []string
+	// MissingFiles are the IDs of images whose file does not exist
+	MissingFiles []string
+	// InvalidMetadata are metadata files that cannot be read
+	InvalidMetadata []string
+	// OrphanVariants are the IDs of variants whose image does not exist
+	OrphanVariants []string
+}
+
+// IsEmpty reports whether the image files and their metadata are consistent
+func (report *ReconcileReport) IsEmpty() bool {
+	return len(report.OrphanFiles) == 0 && len(report.MissingFiles) == 0 && len(report.InvalidMetadata) == 0 &&
+		len(report.OrphanVariants) == 0
 }
 
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	return &DiskImageStore{
+// NewDiskImageStore creates a store that keeps images in imageFolder and
+// loads the metadata of the images saved there before
+func NewDiskImageStore(imageFolder string) (*DiskImageStore, error) {
+	store := &DiskImageStore{
 		imageFolder: imageFolder,
 		images:      make(map[string]*ImageInfo),
+		variants:    make(map[string]map[string]string),
+		refs:        make(map[string]int),
 	}
+
+	err := os.MkdirAll(imageFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create image folder: %w", err)
+	}
+
+	// images being written when the server stopped are never committed, uploads
+	// are resumed from the upload store instead
+	stale, err := filepath.Glob(filepath.Join(imageFolder, imageUploadPrefix+"*.tmp"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list interrupted uploads: %w", err)
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+
+	_, err = store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Create writes the image to a temporary file in the image folder, which is
+// renamed to its final name on commit
+func (store *DiskImageStore) Create(ctx context.Context, laptopID string, imageType string, mimeType string) (ImageWriter, error) {
+	return store.create(&ImageInfo{LaptopID: laptopID, Type: imageType, MimeType: mimeType})
 }
 
-func (store *DiskImageStore) Save(
-	laptopID string,
-	imageType string,
-	imageData bytes.Buffer,
-) (string, error) {
+func (store *DiskImageStore) CreateVariant(ctx context.Context, imageID string, variant string, imageType string, mimeType string) (ImageWriter, error) {
+	if !variantNamePattern.MatchString(variant) {
+		return nil, fmt.Errorf("Invalid image variant %q", variant)
+	}
+
+	parent, err := store.Find(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != "" {
+		return nil, fmt.Errorf("Image %s is already a variant", imageID)
+	}
+
+	return store.create(&ImageInfo{
+		LaptopID: parent.LaptopID,
+		Type:     imageType,
+		MimeType: mimeType,
+		ParentID: imageID,
+		Variant:  variant,
+	})
+}
+
+// create starts a writer for an image with the fields of info set so far
+func (store *DiskImageStore) create(info *ImageInfo) (ImageWriter, error) {
+	if !imageExtPattern.MatchString(info.Type) {
+		return nil, fmt.Errorf("Invalid image type %q", info.Type)
+	}
+
+	file, err := ioutil.TempFile(store.imageFolder, imageUploadPrefix+"*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create image file: %w", err)
+	}
+
+	writer := &diskImageWriter{
+		store: store,
+		file:  file,
+		hash:  sha256.New(),
+		info:  info,
+	}
+	return writer, nil
+}
+
+type diskImageWriter struct {
+	store *DiskImageStore
+	file  *os.File
+	hash  hash.Hash
+	size  int
+	info  *ImageInfo
+	done  bool
+}
+
+func (writer *diskImageWriter) Write(p []byte) (int, error) {
+	n, err := writer.file.Write(p)
+	writer.hash.Write(p[:n])
+	writer.size += n
+	return n, err
+}
+
+func (writer *diskImageWriter) Commit() (*ImageInfo, error) {
+	if writer.done {
+		return nil, errors.New("Image is already committed or aborted")
+	}
+	writer.done = true
+
+	tmpPath := writer.file.Name()
+
+	err := writer.file.Sync()
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("Cannot write image to the file: %w", err)
+	}
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
-		return "", fmt.Errorf("Cannot generate image id: %w", err)
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("Cannot generate image id: %w", err)
+	}
+
+	info := *writer.info
+	info.ID = imageID.String()
+	info.Size = writer.size
+	info.Hash = hex.EncodeToString(writer.hash.Sum(nil))
+	info.FileName = info.Hash + info.Type
+	info.Path = filepath.Join(writer.store.imageFolder, info.FileName)
+	info.UploadedAt = time.Now().UTC()
+
+	return writer.store.add(&info, tmpPath)
+}
+
+func (writer *diskImageWriter) Abort() error {
+	if writer.done {
+		return nil
+	}
+	writer.done = true
+
+	writer.file.Close()
+	err := os.Remove(writer.file.Name())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove image file: %w", err)
+	}
+	return nil
+}
+
+// add moves the data of a committed image to the file named after its hash,
+// unless another image already has the same data, and registers the image.
+// A variant replaces the previous variant with the same name, and is
+// discarded if its image was deleted meanwhile
+func (store *DiskImageStore) add(info *ImageInfo, tmpPath string) (*ImageInfo, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if info.ParentID != "" && store.images[info.ParentID] == nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("Cannot find the image with ID %s: %w", info.ParentID, ErrNotFound)
+	}
+
+	info.Deduplicated = store.refs[info.FileName] > 0
+	if info.Deduplicated {
+		os.Remove(tmpPath)
+	} else {
+		err := os.Rename(tmpPath, info.Path)
+		if err != nil {
+			os.Remove(tmpPath)
+			return nil, fmt.Errorf("Cannot move image file: %w", err)
+		}
+	}
+
+	err := store.writeMetadata(info)
+	if err != nil {
+		if !info.Deduplicated {
+			os.Remove(info.Path)
+		}
+		return nil, err
+	}
+	store.refs[info.FileName]++
+
+	var replaced *ImageInfo
+	if info.ParentID != "" {
+		if store.variants[info.ParentID] == nil {
+			store.variants[info.ParentID] = make(map[string]string)
+		}
+		replaced = store.images[store.variants[info.ParentID][info.Variant]]
+		store.variants[info.ParentID][info.Variant] = info.ID
+	}
+
+	store.images[info.ID] = info
+	if replaced != nil {
+		delete(store.images, replaced.ID)
+		if err := store.remove(replaced); err != nil {
+			log.Printf("Cannot remove replaced variant %s: %v", replaced.ID, err)
+		}
+	}
+
+	other := store.copyInfo(info)
+	info.Deduplicated = false
+	return other, nil
+}
+
+// remove deletes the metadata of an image, and its file when no other image
+// refers to it. The caller must hold the mutex
+func (store *DiskImageStore) remove(info *ImageInfo) error {
+	// the metadata goes first, so that a failure in between leaves an orphan
+	// file for Reconcile to report rather than an image without data
+	err := os.Remove(store.metadataPath(info.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove image metadata: %w", err)
+	}
+
+	store.refs[info.FileName]--
+	if store.refs[info.FileName] > 0 {
+		return nil
+	}
+	delete(store.refs, info.FileName)
+
+	err = os.Remove(info.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove image file: %w", err)
 	}
 
-	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType)
+	return nil
+}
+
+// copyInfo returns a copy of the image info with the names of its variants
+func (store *DiskImageStore) copyInfo(info *ImageInfo) *ImageInfo {
+	other := *info
+	other.Variants = nil
+	for name := range store.variants[info.ID] {
+		other.Variants = append(other.Variants, name)
+	}
+	sort.Strings(other.Variants)
+	return &other
+}
+
+func (store *DiskImageStore) Find(ctx context.Context, imageID string) (*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return nil, fmt.Errorf("Cannot find the image with ID %s: %w", imageID, ErrNotFound)
+	}
+
+	return store.copyInfo(info), nil
+}
+
+func (store *DiskImageStore) List(ctx context.Context, laptopID string) ([]*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
 
-	file, err := os.Create(imagePath)
+	var images []*ImageInfo
+	for _, info := range store.images {
+		if info.LaptopID == laptopID && info.ParentID == "" {
+			images = append(images, store.copyInfo(info))
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		if !images[i].UploadedAt.Equal(images[j].UploadedAt) {
+			return images[i].UploadedAt.Before(images[j].UploadedAt)
+		}
+		return images[i].ID < images[j].ID
+	})
+
+	return images, nil
+}
+
+// Count returns the number of images and of variants in the store
+func (store *DiskImageStore) Count() (int, int) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	variants := 0
+	for _, info := range store.images {
+		if info.ParentID != "" {
+			variants++
+		}
+	}
+	return len(store.images) - variants, variants
+}
+
+func (store *DiskImageStore) Open(ctx context.Context, imageID string) (*ImageInfo, io.ReadCloser, error) {
+	info, err := store.Find(ctx, imageID)
 	if err != nil {
-		return "", fmt.Errorf("Cannot create image file: %w", err)
+		return nil, nil, err
 	}
 
-	_, err = imageData.WriteTo(file)
+	file, err := os.Open(info.Path)
 	if err != nil {
-		return "", fmt.Errorf("Cannot write image to the file: %w", err)
+		return nil, nil, fmt.Errorf("Cannot open image file: %w", err)
+	}
+
+	return info, file, nil
+}
+
+func (store *DiskImageStore) OpenVariant(ctx context.Context, imageID string, variant string) (*ImageInfo, io.ReadCloser, error) {
+	store.mutex.RLock()
+	if store.images[imageID] == nil {
+		store.mutex.RUnlock()
+		return nil, nil, fmt.Errorf("Cannot find the image with ID %s: %w", imageID, ErrNotFound)
+	}
+	variantID, ok := store.variants[imageID][variant]
+	store.mutex.RUnlock()
+
+	if !ok {
+		return nil, nil, fmt.Errorf("Cannot find the variant %s of image %s: %w", variant, imageID, ErrNotFound)
 	}
 
+	return store.Open(ctx, variantID)
+}
+
+func (store *DiskImageStore) Delete(ctx context.Context, imageID string) error {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	store.images[imageID.String()] = &ImageInfo{
-		LaptopID: laptopID,
-		Type:     imageType,
-		Path:     imagePath,
+	info := store.images[imageID]
+	if info == nil {
+		return fmt.Errorf("Cannot find the image with ID %s: %w", imageID, ErrNotFound)
+	}
+
+	for _, variantID := range store.variants[imageID] {
+		variant := store.images[variantID]
+		delete(store.images, variantID)
+		if err := store.remove(variant); err != nil {
+			return err
+		}
 	}
+	delete(store.variants, imageID)
+
+	if info.ParentID != "" {
+		delete(store.variants[info.ParentID], info.Variant)
+	}
+	delete(store.images, imageID)
+
+	return store.remove(info)
+}
+
+// Reconcile reloads the metadata from the image folder and reports the files
+// without metadata and the metadata without files
+func (store *DiskImageStore) Reconcile() (*ReconcileReport, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.load()
+}
+
+func (store *DiskImageStore) metadataPath(imageID string) string {
+	return filepath.Join(store.imageFolder, imageID+imageMetadataExt)
+}
+
+// writeMetadata writes the metadata to a temporary file first and renames it,
+// so that a crash never leaves a truncated metadata file behind
+func (store *DiskImageStore) writeMetadata(info *ImageInfo) error {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Cannot marshal image metadata: %w", err)
+	}
+
+	metadataPath := store.metadataPath(info.ID)
+	tmpPath := metadataPath + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot write image metadata: %w", err)
+	}
+
+	err = os.Rename(tmpPath, metadataPath)
+	if err != nil {
+		return fmt.Errorf("Cannot write image metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (store *DiskImageStore) load() (*ReconcileReport, error) {
+	entries, err := ioutil.ReadDir(store.imageFolder)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read image folder: %w", err)
+	}
+
+	report := &ReconcileReport{}
+	images := make(map[string]*ImageInfo)
+	files := make(map[string]bool)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+
+		if !strings.HasSuffix(name, imageMetadataExt) {
+			files[name] = true
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(store.imageFolder, name))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read image metadata: %w", err)
+		}
+
+		info := &ImageInfo{}
+		err = json.Unmarshal(data, info)
+		if err != nil || info.ID == "" || info.ID+imageMetadataExt != name {
+			log.Printf("Skipping invalid image metadata %s", name)
+			report.InvalidMetadata = append(report.InvalidMetadata, name)
+			continue
+		}
+
+		info.Path = filepath.Join(store.imageFolder, info.FileName)
+		images[info.ID] = info
+	}
+
+	// several images share a file when they have the same data
+	refs := make(map[string]int)
+	for id, info := range images {
+		if !files[info.FileName] {
+			report.MissingFiles = append(report.MissingFiles, id)
+		}
+		refs[info.FileName]++
+	}
+
+	for name := range files {
+		if refs[name] == 0 {
+			report.OrphanFiles = append(report.OrphanFiles, name)
+		}
+	}
+
+	variants := make(map[string]map[string]string)
+	for id, info := range images {
+		if info.ParentID == "" {
+			continue
+		}
+		// a variant left behind by a crash while its image was deleted
+		if images[info.ParentID] == nil {
+			report.OrphanVariants = append(report.OrphanVariants, id)
+			continue
+		}
+		if variants[info.ParentID] == nil {
+			variants[info.ParentID] = make(map[string]string)
+		}
+		variants[info.ParentID][info.Variant] = id
+	}
+
+	sort.Strings(report.OrphanFiles)
+	sort.Strings(report.MissingFiles)
+	sort.Strings(report.OrphanVariants)
 
-	return imageID.String(), nil
+	store.images = images
+	store.variants = variants
+	store.refs = refs
+	return report, nil
 }
@@ -0,0 +1,238 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestDiskImageStoreReload(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+
+	store, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	laptopID := "laptop-1"
+	keptInfo, err := service.SaveImage(context.Background(), store, laptopID, ".jpeg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	kept := keptInfo.ID
+
+	// different data, so that the file is not shared with the kept image
+	lostData := append(append([]byte{}, imageData...), 0)
+	lostInfo, err := service.SaveImage(context.Background(), store, laptopID, ".jpeg", bytes.NewReader(lostData))
+	require.NoError(t, err)
+	lost := lostInfo.ID
+	require.NoError(t, os.Remove(lostInfo.Path))
+
+	aborted, err := store.Create(context.Background(), laptopID, ".jpeg", "image/jpeg")
+	require.NoError(t, err)
+	_, err = aborted.Write(imageData)
+	require.NoError(t, err)
+	require.NoError(t, aborted.Abort())
+
+	orphan := "orphan.jpeg"
+	err = ioutil.WriteFile(filepath.Join(imageFolder, orphan), imageData, 0644)
+	require.NoError(t, err)
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	info, err := reloaded.Find(context.Background(), kept)
+	require.NoError(t, err)
+	require.Equal(t, laptopID, info.LaptopID)
+	require.Equal(t, ".jpeg", info.Type)
+	require.Equal(t, "image/jpeg", info.MimeType)
+	require.Equal(t, len(imageData), info.Size)
+	hash := sha256.Sum256(imageData)
+	require.Equal(t, hex.EncodeToString(hash[:]), info.Hash)
+	require.False(t, info.UploadedAt.IsZero())
+
+	images, err := reloaded.List(context.Background(), laptopID)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+
+	report, err := reloaded.Reconcile()
+	require.NoError(t, err)
+	require.Equal(t, []string{orphan}, report.OrphanFiles)
+	require.Equal(t, []string{lost}, report.MissingFiles)
+	require.Empty(t, report.InvalidMetadata)
+}
+
+func TestDiskImageStoreDeduplicate(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+
+	store, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	first, err := service.SaveImage(context.Background(), store, "laptop-1", ".jpeg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	require.False(t, first.Deduplicated)
+
+	second, err := service.SaveImage(context.Background(), store, "laptop-2", ".jpeg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	require.True(t, second.Deduplicated)
+	require.NotEqual(t, first.ID, second.ID)
+	require.Equal(t, first.Path, second.Path)
+
+	hash := sha256.Sum256(imageData)
+	require.Equal(t, hex.EncodeToString(hash[:])+".jpeg", filepath.Base(first.Path))
+
+	// the references are counted again from the metadata on reload
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	require.NoError(t, reloaded.Delete(context.Background(), first.ID))
+	_, err = os.Stat(second.Path)
+	require.NoError(t, err)
+
+	_, reader, err := reloaded.Open(context.Background(), second.ID)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, imageData, data)
+
+	require.NoError(t, reloaded.Delete(context.Background(), second.ID))
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	third, err := service.SaveImage(context.Background(), reloaded, "laptop-1", ".jpeg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	require.False(t, third.Deduplicated)
+}
+
+func TestDiskImageStoreVariants(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	store, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	src := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	buffer := bytes.Buffer{}
+	require.NoError(t, png.Encode(&buffer, src))
+
+	laptopID := "laptop-1"
+	info, err := service.SaveImage(context.Background(), store, laptopID, ".png", &buffer)
+	require.NoError(t, err)
+
+	variants := []service.ImageVariant{
+		{Name: "thumbnail", MaxWidth: 60, MaxHeight: 60},
+		{Name: "large", MaxWidth: 1000, MaxHeight: 1000},
+	}
+	saved, err := service.SaveImageVariants(context.Background(), store, info, variants)
+	require.NoError(t, err)
+	require.Len(t, saved, 2)
+
+	sizes := map[string]image.Point{
+		"thumbnail": {60, 40},
+		"large":     {300, 200},
+	}
+	for name, size := range sizes {
+		variant, reader, err := store.OpenVariant(context.Background(), info.ID, name)
+		require.NoError(t, err)
+		require.Equal(t, info.ID, variant.ParentID)
+		require.Equal(t, name, variant.Variant)
+		require.Equal(t, "image/png", variant.MimeType)
+
+		config, format, err := image.DecodeConfig(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, "png", format)
+		require.Equal(t, size, image.Pt(config.Width, config.Height))
+	}
+
+	_, _, err = store.OpenVariant(context.Background(), info.ID, "medium")
+	require.True(t, errors.Is(err, service.ErrNotFound))
+
+	// a variant made again replaces the previous one
+	_, err = service.SaveImageVariants(context.Background(), store, info, variants[:1])
+	require.NoError(t, err)
+
+	reloaded, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	images, err := reloaded.List(context.Background(), laptopID)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	require.Equal(t, []string{"large", "thumbnail"}, images[0].Variants)
+
+	report, err := reloaded.Reconcile()
+	require.NoError(t, err)
+	require.True(t, report.IsEmpty())
+
+	require.NoError(t, reloaded.Delete(context.Background(), info.ID))
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestDiskImageStoreVariantsTooLarge(t *testing.T) {
+	t.Parallel()
+
+	store, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	// a PNG whose header claims 100000x100000 pixels, which must be rejected
+	// before it is decoded
+	buffer := bytes.Buffer{}
+	require.NoError(t, png.Encode(&buffer, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buffer.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	info, err := service.SaveImage(context.Background(), store, "laptop-1", ".png", bytes.NewReader(data))
+	require.NoError(t, err)
+
+	variants := []service.ImageVariant{{Name: "thumbnail", MaxWidth: 60, MaxHeight: 60}}
+	saved, err := service.SaveImageVariants(context.Background(), store, info, variants)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too large to resize")
+	require.Empty(t, saved)
+}
+
+func TestParseImageVariants(t *testing.T) {
+	t.Parallel()
+
+	variants, err := service.ParseImageVariants("thumbnail=128x128, medium=640X480")
+	require.NoError(t, err)
+	require.Equal(t, []service.ImageVariant{
+		{Name: "thumbnail", MaxWidth: 128, MaxHeight: 128},
+		{Name: "medium", MaxWidth: 640, MaxHeight: 480},
+	}, variants)
+
+	variants, err = service.ParseImageVariants("")
+	require.NoError(t, err)
+	require.Empty(t, variants)
+
+	for _, value := range []string{"thumbnail", "thumbnail=128", "thumbnail=0x10", "../x=10x10", "a=1x1,a=2x2"} {
+		_, err = service.ParseImageVariants(value)
+		require.Error(t, err, value)
+	}
+}
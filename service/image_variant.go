@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxVariantSourcePixels limits the size of the images variants are made of,
+// since a small compressed file can decode to a huge image. Decoding and
+// resizing such an image takes about 8 bytes per pixel
+const maxVariantSourcePixels = 4096 * 4096
+
+// variantSlots limits the number of images decoded at once for their variants
+var variantSlots = make(chan struct{}, 2)
+
+// variantJPEGQuality is the quality JPEG variants are encoded with
+const variantJPEGQuality = 85
+
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ImageVariant is a resized rendition of every uploaded image. The image is
+// scaled down to fit in MaxWidth x MaxHeight and keeps its aspect ratio
+type ImageVariant struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+// DefaultImageVariants are the variants made by a new LaptopServer
+var DefaultImageVariants = []ImageVariant{
+	{Name: "thumbnail", MaxWidth: 128, MaxHeight: 128},
+	{Name: "medium", MaxWidth: 640, MaxHeight: 640},
+}
+
+// ParseImageVariants parses a comma separated list of variants written as
+// name=WIDTHxHEIGHT, e.g. "thumbnail=128x128,medium=640x640"
+func ParseImageVariants(value string) ([]ImageVariant, error) {
+	var variants []ImageVariant
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid image variant %q: expected name=WIDTHxHEIGHT", item)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if !variantNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("Invalid image variant name %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Duplicate image variant %q", name)
+		}
+		seen[name] = true
+
+		size := strings.SplitN(strings.ToLower(strings.TrimSpace(parts[1])), "x", 2)
+		if len(size) != 2 {
+			return nil, fmt.Errorf("Invalid image variant size %q", parts[1])
+		}
+		width, err := strconv.Atoi(size[0])
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("Invalid image variant width %q", size[0])
+		}
+		height, err := strconv.Atoi(size[1])
+		if err != nil || height <= 0 {
+			return nil, fmt.Errorf("Invalid image variant height %q", size[1])
+		}
+
+		variants = append(variants, ImageVariant{Name: name, MaxWidth: width, MaxHeight: height})
+	}
+
+	return variants, nil
+}
+
+// SaveImageVariants decodes the image and saves a resized copy of it for each
+// variant, linked to the image in the store. Only JPEG and PNG images have
+// variants, other formats are left alone
+func SaveImageVariants(ctx context.Context, store ImageStore, info *ImageInfo, variants []ImageVariant) ([]*ImageInfo, error) {
+	if len(variants) == 0 || (info.MimeType != "image/jpeg" && info.MimeType != "image/png") {
+		return nil, nil
+	}
+
+	// the size is read from the header, before anything is decoded
+	config, err := decodeImageConfig(ctx, store, info.ID)
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > maxVariantSourcePixels {
+		return nil, fmt.Errorf("Image of %dx%d pixels is too large to resize", config.Width, config.Height)
+	}
+
+	select {
+	case variantSlots <- struct{}{}:
+		defer func() { <-variantSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	src, err := decodeImage(ctx, store, info.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []*ImageInfo
+	for _, variant := range variants {
+		resized := resizeImage(src, variant.MaxWidth, variant.MaxHeight)
+
+		buffer := bytes.Buffer{}
+		if info.MimeType == "image/png" {
+			err = png.Encode(&buffer, resized)
+		} else {
+			err = jpeg.Encode(&buffer, resized, &jpeg.Options{Quality: variantJPEGQuality})
+		}
+		if err != nil {
+			return saved, fmt.Errorf("Cannot encode image variant %s: %w", variant.Name, err)
+		}
+
+		writer, err := store.CreateVariant(ctx, info.ID, variant.Name, info.Type, info.MimeType)
+		if err != nil {
+			return saved, err
+		}
+
+		_, err = writer.Write(buffer.Bytes())
+		if err != nil {
+			writer.Abort()
+			return saved, fmt.Errorf("Cannot write image variant %s: %w", variant.Name, err)
+		}
+
+		variantInfo, err := writer.Commit()
+		if err != nil {
+			return saved, err
+		}
+		saved = append(saved, variantInfo)
+	}
+
+	return saved, nil
+}
+
+func decodeImageConfig(ctx context.Context, store ImageStore, imageID string) (image.Config, error) {
+	_, reader, err := store.Open(ctx, imageID)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer reader.Close()
+
+	config, _, err := image.DecodeConfig(bufio.NewReader(reader))
+	if err != nil {
+		return image.Config{}, fmt.Errorf("Cannot decode image: %w", err)
+	}
+	return config, nil
+}
+
+func decodeImage(ctx context.Context, store ImageStore, imageID string) (image.Image, error) {
+	_, reader, err := store.Open(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	src, _, err := image.Decode(bufio.NewReader(reader))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode image: %w", err)
+	}
+	return src, nil
+}
+
+// fitSize returns the largest size with the aspect ratio of width x height
+// that fits in maxWidth x maxHeight. Images are never scaled up
+func fitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+
+	if width*maxHeight > height*maxWidth {
+		return maxWidth, max1(height * maxWidth / width)
+	}
+	return max1(width * maxHeight / height), maxHeight
+}
+
+func max1(value int) int {
+	if value < 1 {
+		return 1
+	}
+	return value
+}
+
+// resizeImage scales the image down with a box filter: each pixel of the
+// result is the average of the source pixels it covers
+func resizeImage(src image.Image, maxWidth, maxHeight int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := fitSize(srcWidth, srcHeight, maxWidth, maxHeight)
+
+	rgba, ok := src.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := (y + 1) * srcHeight / height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := (x + 1) * srcWidth / width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					sum[0] += int(pixel[0])
+					sum[1] += int(pixel[1])
+					sum[2] += int(pixel[2])
+					sum[3] += int(pixel[3])
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for i := 0; i < 4; i++ {
+				dst.Pix[offset+i] = uint8(sum[i] / count)
+			}
+		}
+	}
+
+	return dst
+}
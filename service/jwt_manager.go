@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// JWTManager issues and verifies the access tokens of the users, signed with
+// HMAC-SHA256
+type JWTManager struct {
+	secretKey     []byte
+	tokenDuration time.Duration
+}
+
+type UserClaims struct {
+	jwt.StandardClaims
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
+	return &JWTManager{
+		secretKey:     []byte(secretKey),
+		tokenDuration: tokenDuration,
+	}
+}
+
+// Generate returns a token for the user together with its expiry time
+func (manager *JWTManager) Generate(user *User) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(manager.tokenDuration)
+
+	claims := &UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		Username: user.Username,
+		Role:     user.Role,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(manager.secretKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Cannot sign token: %w", err)
+	}
+	return signed, time.Unix(expiresAt.Unix(), 0), nil
+}
+
+// Verify checks the signature and the expiry time of the token and returns its claims
+func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
+	token, err := jwt.ParseWithClaims(
+		accessToken,
+		&UserClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("Unexpected token signing method: %v", token.Header["alg"])
+			}
+			return manager.secretKey, nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok || claims.Username == "" {
+		return nil, errors.New("Invalid token claims")
+	}
+	return claims, nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+)
+
+var ErrUnknownFacet = errors.New("Unknown facet field")
+
+// facetField describes how a facet reads a laptop field. Values are counted,
+// numbers are summarised by their minimum, maximum and average
+type facetField struct {
+	values  func(laptop *pb.Laptop) []string
+	numbers func(laptop *pb.Laptop) []float64
+}
+
+var facetFields = map[string]*facetField{
+	"brand": {
+		values: func(laptop *pb.Laptop) []string { return []string{laptop.GetBrand()} },
+	},
+	"release_year": {
+		values: func(laptop *pb.Laptop) []string {
+			return []string{strconv.FormatUint(uint64(laptop.GetReleaseYear()), 10)}
+		},
+		numbers: func(laptop *pb.Laptop) []float64 { return []float64{float64(laptop.GetReleaseYear())} },
+	},
+	"price_inr": {
+		numbers: func(laptop *pb.Laptop) []float64 { return []float64{laptop.GetPriceInr()} },
+	},
+	"cpu.brand": {
+		values: func(laptop *pb.Laptop) []string { return []string{laptop.GetCpu().GetBrand()} },
+	},
+	"cpu.cores": {
+		values: func(laptop *pb.Laptop) []string {
+			return []string{strconv.FormatUint(uint64(laptop.GetCpu().GetNumberOfCores()), 10)}
+		},
+		numbers: func(laptop *pb.Laptop) []float64 { return []float64{float64(laptop.GetCpu().GetNumberOfCores())} },
+	},
+	"cpu.min_ghz": {
+		numbers: func(laptop *pb.Laptop) []float64 { return []float64{laptop.GetCpu().GetMinGhz()} },
+	},
+	"ram": {
+		values: func(laptop *pb.Laptop) []string { return []string{formatMemory(laptop.GetRam())} },
+	},
+	"gpu.brand": {
+		values: func(laptop *pb.Laptop) []string {
+			var brands []string
+			for _, gpu := range laptop.GetGpus() {
+				brands = append(brands, gpu.GetBrand())
+			}
+			return brands
+		},
+	},
+	"gpu.memory": {
+		values: func(laptop *pb.Laptop) []string {
+			var memories []string
+			for _, gpu := range laptop.GetGpus() {
+				memories = append(memories, formatMemory(gpu.GetMemory()))
+			}
+			return memories
+		},
+	},
+	"storage.driver": {
+		values: func(laptop *pb.Laptop) []string {
+			var drivers []string
+			for _, storage := range laptop.GetStorages() {
+				drivers = append(drivers, storage.GetDriver().String())
+			}
+			return drivers
+		},
+	},
+	"screen_size": {
+		numbers: func(laptop *pb.Laptop) []float64 { return []float64{float64(laptop.GetScreen().GetScreenSize())} },
+	},
+	"panel": {
+		values: func(laptop *pb.Laptop) []string { return []string{laptop.GetScreen().GetPanel().String()} },
+	},
+	"multitouch": {
+		values: func(laptop *pb.Laptop) []string { return boolText(laptop.GetScreen().GetMultitouch()) },
+	},
+	"layout": {
+		values: func(laptop *pb.Laptop) []string { return []string{laptop.GetKeyboard().GetLayout().String()} },
+	},
+	"backlit": {
+		values: func(laptop *pb.Laptop) []string { return boolText(laptop.GetKeyboard().GetBacklit()) },
+	},
+	"weight": {
+		numbers: func(laptop *pb.Laptop) []float64 {
+			weight, ok := weightKg(laptop)
+			if !ok {
+				return nil
+			}
+			return []float64{weight}
+		},
+	},
+}
+
+var memoryUnitSymbols = []struct {
+	unit   pb.Memory_Unit
+	symbol string
+}{
+	{pb.Memory_TERABYTE, "TB"},
+	{pb.Memory_GIGYBYTE, "GB"},
+	{pb.Memory_MEGABYTE, "MB"},
+	{pb.Memory_KILOBYTE, "KB"},
+	{pb.Memory_BYTE, "B"},
+}
+
+// formatMemory writes memory in the largest unit that holds it exactly, so
+// that 16GB and 16384MB are counted as the same value
+func formatMemory(memory *pb.Memory) string {
+	bits := toBit(memory)
+	for _, u := range memoryUnitSymbols {
+		size := toBit(&pb.Memory{Value: 1, Unit: u.unit})
+		if bits >= size && bits%size == 0 {
+			return fmt.Sprintf("%d%s", bits/size, u.symbol)
+		}
+	}
+	return fmt.Sprintf("%dbit", bits)
+}
+
+// laptopAggregator accumulates facet counts and statistics over the laptops added to it
+type laptopAggregator struct {
+	total  uint32
+	fields []string
+	counts []map[string]uint32
+	stats  []*pb.NumericStats
+	sums   []float64
+}
+
+func newLaptopAggregator(fields []string) (*laptopAggregator, error) {
+	aggregator := &laptopAggregator{}
+
+	for _, field := range fields {
+		name := strings.ToLower(field)
+		if alias, ok := fieldAliases[name]; ok {
+			name = alias
+		}
+		if facetFields[name] == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFacet, field)
+		}
+
+		aggregator.fields = append(aggregator.fields, name)
+		aggregator.counts = append(aggregator.counts, make(map[string]uint32))
+		aggregator.stats = append(aggregator.stats, &pb.NumericStats{})
+		aggregator.sums = append(aggregator.sums, 0)
+	}
+
+	return aggregator, nil
+}
+
+func (aggregator *laptopAggregator) add(laptop *pb.Laptop) {
+	aggregator.total++
+
+	for i, name := range aggregator.fields {
+		field := facetFields[name]
+
+		if field.values != nil {
+			// a laptop with two GPUs of the same brand counts once for that brand
+			seen := make(map[string]bool)
+			for _, value := range field.values(laptop) {
+				if !seen[value] {
+					seen[value] = true
+					aggregator.counts[i][value]++
+				}
+			}
+		}
+
+		if field.numbers != nil {
+			stats := aggregator.stats[i]
+			for _, number := range field.numbers(laptop) {
+				if stats.Count == 0 || number < stats.Min {
+					stats.Min = number
+				}
+				if stats.Count == 0 || number > stats.Max {
+					stats.Max = number
+				}
+				stats.Count++
+				aggregator.sums[i] += number
+			}
+		}
+	}
+}
+
+func (aggregator *laptopAggregator) result() *pb.AggregateLaptopsResponse {
+	res := &pb.AggregateLaptopsResponse{Total: aggregator.total}
+
+	for i, name := range aggregator.fields {
+		facet := &pb.Facet{Field: name}
+
+		for value, count := range aggregator.counts[i] {
+			facet.Values = append(facet.Values, &pb.FacetValue{Value: value, Count: count})
+		}
+		sort.Slice(facet.Values, func(a, b int) bool {
+			if facet.Values[a].Count != facet.Values[b].Count {
+				return facet.Values[a].Count > facet.Values[b].Count
+			}
+			return facet.Values[a].Value < facet.Values[b].Value
+		})
+
+		if facetFields[name].numbers != nil {
+			stats := aggregator.stats[i]
+			if stats.Count > 0 {
+				stats.Average = aggregator.sums[i] / float64(stats.Count)
+			}
+			facet.Stats = stats
+		}
+
+		res.Facets = append(res.Facets, facet)
+	}
+
+	return res
+}
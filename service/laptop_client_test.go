@@ -2,20 +2,35 @@ package service_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"image/jpeg"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/certs"
 	"gitlab.com/keshavbhattad/pcbook/pb"
 	"gitlab.com/keshavbhattad/pcbook/sample"
 	"gitlab.com/keshavbhattad/pcbook/serializer"
 	"gitlab.com/keshavbhattad/pcbook/service"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestClientCreateLaptop(t *testing.T) {
@@ -43,6 +58,38 @@ func TestClientCreateLaptop(t *testing.T) {
 	requireSameLaptop(t, laptop, other)
 }
 
+func TestClientUpdateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	createdAt := laptop.GetCreatedAt().AsTime()
+
+	laptop.PriceInr = 75000
+	laptop.CreatedAt = timestamppb.New(createdAt.Add(-time.Hour))
+	updateRes, err := laptopClient.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+	require.Equal(t, laptop.Id, updateRes.GetLaptop().GetId())
+	require.True(t, createdAt.Equal(updateRes.GetLaptop().GetCreatedAt().AsTime()))
+
+	getRes, err := laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.Id})
+	require.NoError(t, err)
+	require.Equal(t, float64(75000), getRes.GetLaptop().GetPriceInr())
+	requireSameLaptop(t, updateRes.GetLaptop(), getRes.GetLaptop())
+
+	_, err = laptopClient.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.NoError(t, err)
+
+	_, err = laptopStore.Find(laptop.Id)
+	require.ErrorIs(t, err, service.ErrNotFound)
+}
+
 func TestClientSearchLaptop(t *testing.T) {
 	t.Parallel()
 
@@ -116,16 +163,178 @@ func TestClientSearchLaptop(t *testing.T) {
 	require.Equal(t, len(expectedIDs), found)
 }
 
+func TestClientSearchLaptopPages(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < 7; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceInr = float64(50000 + 1000*(i%4))
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.SearchLaptopRequest{
+		Filter:     &pb.Filter{},
+		SortBy:     pb.SearchLaptopRequest_PRICE_INR,
+		Descending: true,
+		PageSize:   3,
+	}
+
+	var prices []float64
+	ids := make(map[string]bool)
+	pages := 0
+
+	for {
+		stream, err := laptopClient.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		nextPageToken := ""
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			prices = append(prices, res.GetLaptop().GetPriceInr())
+			ids[res.GetLaptop().GetId()] = true
+			nextPageToken = res.GetNextPageToken()
+		}
+
+		pages++
+		if nextPageToken == "" {
+			break
+		}
+		req.PageToken = nextPageToken
+	}
+
+	require.Equal(t, 3, pages)
+	require.Len(t, ids, 7)
+	require.True(t, sort.IsSorted(sort.Reverse(sort.Float64Slice(prices))))
+
+	req.PageToken = "invalid-token"
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestClientSearchLaptopQuery(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	expectedIDs := make(map[string]bool)
+
+	for i := 0; i < 4; i++ {
+		laptop := sample.NewLaptop()
+		laptop.Brand = "Dell"
+		laptop.Screen.Panel = pb.Screen_IPS
+		if i%2 == 0 {
+			laptop.Brand = "Apple"
+			laptop.Screen.Panel = pb.Screen_OLED
+			expectedIDs[laptop.Id] = true
+		}
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.SearchLaptopRequest{Query: "brand:Apple (panel:OLED OR panel:IPS)"}
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	found := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Contains(t, expectedIDs, res.GetLaptop().GetId())
+		found++
+	}
+	require.Equal(t, len(expectedIDs), found)
+
+	req = &pb.SearchLaptopRequest{Query: "brand:Apple price_inr<cheap"}
+	stream, err = laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), `"cheap"`)
+}
+
+func TestClientAggregateLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	prices := []float64{60000, 70000, 80000, 95000}
+
+	for i, price := range prices {
+		laptop := sample.NewLaptop()
+		laptop.Brand = "Dell"
+		laptop.PriceInr = price
+		laptop.Ram = &pb.Memory{Value: 8, Unit: pb.Memory_GIGYBYTE}
+		if i == 0 {
+			laptop.Brand = "Apple"
+			laptop.Ram = &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE}
+		}
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.AggregateLaptopsRequest{
+		Filter: &pb.Filter{MaxPriceInr: 90000},
+		Facets: []string{"brand", "ram", "price"},
+	}
+	res, err := laptopClient.AggregateLaptops(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), res.GetTotal())
+	require.Len(t, res.GetFacets(), 3)
+
+	brand := res.GetFacets()[0]
+	require.Equal(t, "brand", brand.GetField())
+	require.Equal(t, "Dell", brand.GetValues()[0].GetValue())
+	require.Equal(t, uint32(2), brand.GetValues()[0].GetCount())
+	require.Equal(t, "Apple", brand.GetValues()[1].GetValue())
+	require.Nil(t, brand.GetStats())
+
+	ram := res.GetFacets()[1]
+	require.Equal(t, "8GB", ram.GetValues()[0].GetValue())
+	require.Equal(t, "16GB", ram.GetValues()[1].GetValue())
+
+	price := res.GetFacets()[2]
+	require.Equal(t, "price_inr", price.GetField())
+	require.Equal(t, uint32(3), price.GetStats().GetCount())
+	require.Equal(t, float64(60000), price.GetStats().GetMin())
+	require.Equal(t, float64(80000), price.GetStats().GetMax())
+	require.Equal(t, float64(70000), price.GetStats().GetAverage())
+
+	req.Facets = []string{"colour"}
+	_, err = laptopClient.AggregateLaptops(context.Background(), req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestClientUploadImage(t *testing.T) {
 	t.Parallel()
 
 	testImageFolder := "../tmp"
 
 	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore(testImageFolder)
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
 
 	laptop := sample.NewLaptop()
-	err := laptopStore.Save(laptop)
+	err = laptopStore.Save(laptop)
 	require.NoError(t, err)
 
 	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
@@ -179,6 +388,422 @@ func TestClientUploadImage(t *testing.T) {
 	require.NoError(t, err)
 	require.NotZero(t, res.GetId())
 	require.Equal(t, uint32(size), res.GetSize())
+	require.False(t, res.GetDeduplicated())
+
+	downloadStream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{
+		ImageId: res.GetId(),
+		Variant: "thumbnail",
+	})
+	require.NoError(t, err)
+
+	downloadRes, err := downloadStream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "thumbnail", downloadRes.GetInfo().GetVariant())
+
+	thumbnail := bytes.Buffer{}
+	for {
+		downloadRes, err := downloadStream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		thumbnail.Write(downloadRes.GetChunkData())
+	}
+
+	config, err := jpeg.DecodeConfig(&thumbnail)
+	require.NoError(t, err)
+	require.LessOrEqual(t, config.Width, 128)
+	require.LessOrEqual(t, config.Height, 128)
+
+	downloadStream, err = laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{
+		ImageId: res.GetId(),
+		Variant: "poster",
+	})
+	require.NoError(t, err)
+	_, err = downloadStream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientUploadImageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	err = laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil)
+	laptopServer.SetMaxImageSize(2048)
+	serverAddress := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpeg"},
+		},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		chunk := make([]byte, 1024)
+		copy(chunk, []byte{0xFF, 0xD8, 0xFF})
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: chunk},
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	files, err := ioutil.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestClientUploadImageInvalidContent(t *testing.T) {
+	t.Parallel()
+
+	pngData := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	jpegData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		imageType string
+		data      []byte
+	}{
+		{
+			name:      "type_mismatch",
+			imageType: ".jpeg",
+			data:      pngData,
+		},
+		{
+			name:      "path_in_type",
+			imageType: "../evil",
+			data:      jpegData,
+		},
+		{
+			name:      "unsupported_content",
+			imageType: ".exe",
+			data:      []byte("MZ\x90\x00 this is not an image"),
+		},
+		{
+			name:      "too_short",
+			imageType: "",
+			data:      []byte{0xFF},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptopStore := service.NewInMemoryLaptopStore()
+			imageFolder := t.TempDir()
+			imageStore, err := service.NewDiskImageStore(imageFolder)
+			require.NoError(t, err)
+
+			laptop := sample.NewLaptop()
+			err = laptopStore.Save(laptop)
+			require.NoError(t, err)
+
+			serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
+			laptopClient := newTestLaptopClient(t, serverAddress)
+
+			stream, err := laptopClient.UploadImage(context.Background())
+			require.NoError(t, err)
+
+			err = stream.Send(&pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_Info{
+					Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: tc.imageType},
+				},
+			})
+			require.NoError(t, err)
+
+			err = stream.Send(&pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_ChunkData{ChunkData: tc.data},
+			})
+			require.NoError(t, err)
+
+			_, err = stream.CloseAndRecv()
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+			files, err := ioutil.ReadDir(imageFolder)
+			require.NoError(t, err)
+			require.Empty(t, files)
+		})
+	}
+}
+
+func TestClientUploadImageDeduplicated(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+
+	var imageIDs []string
+	for i := 0; i < 2; i++ {
+		laptop := sample.NewLaptop()
+		err = laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		res, err := uploadTestImage(laptopClient, laptop.GetId(), ".jpeg", imageData)
+		require.NoError(t, err)
+		require.Equal(t, i > 0, res.GetDeduplicated())
+		imageIDs = append(imageIDs, res.GetId())
+	}
+
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{ImageId: imageIDs[0]})
+	require.NoError(t, err)
+
+	stream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: imageIDs[1]})
+	require.NoError(t, err)
+
+	downloaded := bytes.Buffer{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		downloaded.Write(res.GetChunkData())
+	}
+	require.Equal(t, imageData, downloaded.Bytes())
+}
+
+func TestClientResumeUploadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+	uploadStore, err := service.NewDiskUploadStore(t.TempDir())
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	err = laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil)
+	laptopServer.SetUploadStore(uploadStore)
+	serverAddress := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+	half := len(imageData) / 2
+
+	// the first stream breaks after half of the image
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := laptopClient.UploadImage(ctx)
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpeg"},
+		},
+	})
+	require.NoError(t, err)
+
+	header, err := stream.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0"}, header.Get("upload-offset"))
+	require.Len(t, header.Get("upload-id"), 1)
+	uploadID := header.Get("upload-id")[0]
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Chunk{
+			Chunk: &pb.Chunk{Data: imageData[:half], Offset: 0},
+		},
+	})
+	require.NoError(t, err)
+
+	queryUpload := func() (*pb.QueryUploadResponse, error) {
+		return laptopClient.QueryUpload(context.Background(), &pb.QueryUploadRequest{UploadId: uploadID})
+	}
+	require.Eventually(t, func() bool {
+		res, err := queryUpload()
+		return err == nil && res.GetOffset() == uint64(half)
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	// the second stream resumes the upload, sending a few bytes again
+	stream, err = laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpeg", UploadId: uploadID},
+		},
+	})
+	require.NoError(t, err)
+
+	header, err = stream.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{uploadID}, header.Get("upload-id"))
+	require.Equal(t, []string{strconv.Itoa(half)}, header.Get("upload-offset"))
+
+	resend := half - 10
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Chunk{
+			Chunk: &pb.Chunk{Data: imageData[resend:], Offset: uint64(resend)},
+		},
+	})
+	require.NoError(t, err)
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.Equal(t, uint32(len(imageData)), res.GetSize())
+
+	_, reader, err := imageStore.Open(context.Background(), res.GetId())
+	require.NoError(t, err)
+	saved, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, imageData, saved)
+
+	_, err = queryUpload()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientUploadImageGap(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	err = laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// the offsets of 1<<63 and above are negative as int64
+	for _, offset := range []uint64{100, 1 << 63, math.MaxUint64} {
+		stream, err := laptopClient.UploadImage(context.Background())
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{
+				Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpeg"},
+			},
+		})
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Chunk{
+				Chunk: &pb.Chunk{Data: []byte{0xFF, 0xD8, 0xFF}, Offset: offset},
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "offset: %d", offset)
+	}
+}
+
+// uploadTestImage uploads the image data in a single chunk
+func uploadTestImage(laptopClient pb.LaptopServiceClient, laptopID string, imageType string, imageData []byte) (*pb.UploadImageResponse, error) {
+	stream, err := laptopClient.UploadImage(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptopID, ImageType: imageType},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: imageData},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func TestClientDownloadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	err = laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+
+	info, err := service.SaveImage(context.Background(), imageStore, laptop.GetId(), ".jpeg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	imageID := info.ID
+
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	listRes, err := laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetImages(), 1)
+	require.Equal(t, imageID, listRes.GetImages()[0].GetId())
+	require.Equal(t, uint32(len(imageData)), listRes.GetImages()[0].GetSize())
+
+	stream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: imageID})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, ".jpeg", res.GetInfo().GetImageType())
+	require.Equal(t, "image/jpeg", res.GetInfo().GetMimeType())
+
+	downloaded := bytes.Buffer{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		downloaded.Write(res.GetChunkData())
+	}
+	require.Equal(t, imageData, downloaded.Bytes())
+
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{ImageId: imageID})
+	require.NoError(t, err)
+
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{ImageId: imageID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	listRes, err = laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Empty(t, listRes.GetImages())
 }
 
 func TestClientRateLaptop(t *testing.T) {
@@ -197,12 +822,15 @@ func TestClientRateLaptop(t *testing.T) {
 	stream, err := laptopClient.RateLaptop(context.Background())
 	require.NoError(t, err)
 
-	scores := []float64{5, 7, 9}
-	averages := []float64{5, 6, 7}
+	// the last score of user-1 replaces the first one
+	userIDs := []string{"user-1", "user-2", "user-3", "user-1"}
+	scores := []float64{5, 7, 9, 8}
+	counts := []uint32{1, 2, 3, 3}
+	averages := []float64{5, 6, 7, 8}
 
 	n := len(scores)
 	for i := 0; i < n; i++ {
-		req := &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: scores[i]}
+		req := &pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: userIDs[i], Score: scores[i]}
 
 		err := stream.Send(req)
 		require.NoError(t, err)
@@ -220,10 +848,304 @@ func TestClientRateLaptop(t *testing.T) {
 
 		require.NoError(t, err)
 		require.Equal(t, laptop.GetId(), res.GetLaptopId())
-		require.Equal(t, uint32(idx+1), res.GetRatedCount())
+		require.Equal(t, counts[idx], res.GetRatedCount())
 		require.Equal(t, averages[idx], res.GetAverageScore())
 	}
+}
+
+func TestClientRateLaptopWithoutUser(t *testing.T) {
+	t.Parallel()
 
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, service.NewInMemoryRatingStore())
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	// the scores of a client without user ID replace each other
+	for _, score := range []float64{10, 4} {
+		err = stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: score})
+		require.NoError(t, err)
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), res.GetRatedCount())
+		require.Equal(t, score, res.GetAverageScore())
+	}
+
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestClientRateLaptopInvalidScore(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	require.Error(t, laptopServer.SetScoreRange(5, 0, 1))
+	require.Error(t, laptopServer.SetScoreRange(0, 5, 0))
+	require.Error(t, laptopServer.SetScoreRange(0, 5, 1e-6))
+	require.NoError(t, laptopServer.SetScoreRange(0, 5, 0.5))
+	serverAddress := serveTestLaptopServer(t, laptopServer)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	testCases := []struct {
+		name  string
+		score float64
+		code  codes.Code
+	}{
+		{name: "lowest", score: 0, code: codes.OK},
+		{name: "highest", score: 5, code: codes.OK},
+		{name: "half", score: 2.5, code: codes.OK},
+		{name: "off_step", score: 2.3, code: codes.InvalidArgument},
+		{name: "negative", score: -1, code: codes.InvalidArgument},
+		{name: "too_high", score: 1e9, code: codes.InvalidArgument},
+		{name: "nan", score: math.NaN(), code: codes.InvalidArgument},
+		{name: "infinite", score: math.Inf(1), code: codes.InvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stream, err := laptopClient.RateLaptop(context.Background())
+			require.NoError(t, err)
+
+			err = stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: tc.name, Score: tc.score})
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			require.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
+
+func TestClientGetLaptopRating(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, service.NewInMemoryRatingStore())
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.GetLaptopRatingRequest{LaptopId: laptop.GetId()}
+	res, err := laptopClient.GetLaptopRating(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), res.GetCount())
+	require.Empty(t, res.GetBuckets())
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	scores := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	for i, score := range scores {
+		req := &pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: fmt.Sprintf("user-%d", i), Score: score}
+		err := stream.Send(req)
+		require.NoError(t, err)
+
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	err = stream.CloseSend()
+	require.NoError(t, err)
+
+	res, err = laptopClient.GetLaptopRating(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetId(), res.GetLaptopId())
+	require.Equal(t, uint32(8), res.GetCount())
+	require.Equal(t, 5.0, res.GetMean())
+	require.Equal(t, 4.5, res.GetMedian())
+	require.InDelta(t, 2.0, res.GetStdDev(), 1e-9)
+
+	buckets := map[float64]uint32{}
+	for _, bucket := range res.GetBuckets() {
+		buckets[bucket.GetScore()] = bucket.GetCount()
+	}
+	require.Equal(t, map[float64]uint32{2: 1, 4: 3, 5: 2, 7: 1, 9: 1}, buckets)
+	require.Equal(t, 2.0, res.GetBuckets()[0].GetScore())
+
+	_, err = laptopClient.GetLaptopRating(context.Background(), &pb.GetLaptopRatingRequest{LaptopId: sample.NewLaptop().GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientListRatings(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, service.NewInMemoryRatingStore())
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i, score := range []float64{3, 8} {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: fmt.Sprintf("user-%d", i), Score: score})
+		require.NoError(t, err)
+
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	err = stream.CloseSend()
+	require.NoError(t, err)
+
+	listRatings := func(req *pb.ListRatingsRequest) ([]*pb.ListRatingsResponse, error) {
+		stream, err := laptopClient.ListRatings(context.Background(), req)
+		require.NoError(t, err)
+
+		var ratings []*pb.ListRatingsResponse
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return ratings, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			ratings = append(ratings, res)
+		}
+	}
+
+	ratings, err := listRatings(&pb.ListRatingsRequest{From: timestamppb.New(start)})
+	require.NoError(t, err)
+	require.Len(t, ratings, 2)
+	require.Equal(t, laptop.GetId(), ratings[0].GetLaptopId())
+	require.Equal(t, "user-0", ratings[0].GetUserId())
+	require.Equal(t, 3.0, ratings[0].GetScore())
+	require.False(t, ratings[0].GetRatedAt().AsTime().Before(start))
+	require.Equal(t, "user-1", ratings[1].GetUserId())
+
+	ratings, err = listRatings(&pb.ListRatingsRequest{To: timestamppb.New(start)})
+	require.NoError(t, err)
+	require.Empty(t, ratings)
+
+	_, err = listRatings(&pb.ListRatingsRequest{From: timestamppb.Now(), To: timestamppb.New(start)})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestClientTopRatedLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptops := make([]*pb.Laptop, 4)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].PriceInr = 50000
+		if i == 3 {
+			laptops[i].PriceInr = 150000
+		}
+		err := laptopStore.Save(laptops[i])
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, service.NewInMemoryRatingStore())
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// laptop 0 has a single top score, laptop 3 is the best rated but too expensive
+	scores := [][]float64{
+		{10},
+		{8, 9, 9, 8},
+		{3, 4, 2},
+		{10, 10, 10, 10},
+	}
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	for i, laptopScores := range scores {
+		for j, score := range laptopScores {
+			req := &pb.RateLaptopRequest{LaptopId: laptops[i].GetId(), UserId: fmt.Sprintf("user-%d", j), Score: score}
+			err := stream.Send(req)
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			require.NoError(t, err)
+		}
+	}
+	err = stream.CloseSend()
+	require.NoError(t, err)
+
+	topRated := func(req *pb.TopRatedLaptopsRequest) []*pb.TopRatedLaptopsResponse {
+		stream, err := laptopClient.TopRatedLaptops(context.Background(), req)
+		require.NoError(t, err)
+
+		var top []*pb.TopRatedLaptopsResponse
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return top
+			}
+			require.NoError(t, err)
+			top = append(top, res)
+		}
+	}
+
+	filter := &pb.Filter{MaxPriceInr: 100000}
+	top := topRated(&pb.TopRatedLaptopsRequest{Filter: filter})
+	require.Len(t, top, 3)
+	require.Equal(t, laptops[1].GetId(), top[0].GetLaptop().GetId())
+	require.Equal(t, laptops[0].GetId(), top[1].GetLaptop().GetId())
+	require.Equal(t, laptops[2].GetId(), top[2].GetLaptop().GetId())
+	require.Equal(t, uint32(4), top[0].GetRatedCount())
+	require.Equal(t, 8.5, top[0].GetAverageScore())
+	require.Less(t, top[0].GetWeightedScore(), top[0].GetAverageScore())
+
+	top = topRated(&pb.TopRatedLaptopsRequest{Filter: filter, MinRatingCount: 2})
+	require.Len(t, top, 2)
+	require.Equal(t, laptops[1].GetId(), top[0].GetLaptop().GetId())
+	require.Equal(t, laptops[2].GetId(), top[1].GetLaptop().GetId())
+
+	top = topRated(&pb.TopRatedLaptopsRequest{Limit: 2})
+	require.Len(t, top, 2)
+	require.Equal(t, laptops[3].GetId(), top[0].GetLaptop().GetId())
+	require.Equal(t, laptops[1].GetId(), top[1].GetLaptop().GetId())
+}
+
+func TestClientWithoutCertificate(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestLaptopServer(t, service.NewInMemoryLaptopStore(), nil, nil)
+	_, clientTLS := testTLSConfigs(t)
+	clientTLS.Certificates = nil
+
+	dialOptions := map[string]grpc.DialOption{
+		"plaintext":      grpc.WithInsecure(),
+		"no_client_cert": grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)),
+	}
+
+	for name, dialOption := range dialOptions {
+		dialOption := dialOption
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, err := grpc.Dial(serverAddress, dialOption)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			laptopClient := pb.NewLaptopServiceClient(conn)
+			_, err = laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: sample.NewLaptop().GetId()})
+			require.Equal(t, codes.Unavailable, status.Code(err))
+		})
+	}
 }
 
 func startTestLaptopServer(
@@ -233,8 +1155,12 @@ func startTestLaptopServer(
 	ratingStore service.RatingStore,
 ) string {
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	return serveTestLaptopServer(t, laptopServer)
+}
 
-	grpcServer := grpc.NewServer()
+func serveTestLaptopServer(t *testing.T, laptopServer *service.LaptopServer) string {
+	serverTLS, _ := testTLSConfigs(t)
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 
 	listener, err := net.Listen("tcp", ":0")
@@ -246,12 +1172,59 @@ func startTestLaptopServer(
 }
 
 func newTestLaptopClient(t *testing.T, serverAddress string) pb.LaptopServiceClient {
-	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	_, clientTLS := testTLSConfigs(t)
+	conn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
 	require.NoError(t, err)
 
 	return pb.NewLaptopServiceClient(conn)
 }
 
+var (
+	testTLSOnce   sync.Once
+	testServerTLS *tls.Config
+	testClientTLS *tls.Config
+	testTLSErr    error
+)
+
+// testTLSConfigs returns the TLS configs of the test servers and clients,
+// which authenticate each other with certificates of a throwaway CA
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	testTLSOnce.Do(func() {
+		var ca, server, client *certs.KeyPair
+
+		ca, testTLSErr = certs.NewCA("test CA", time.Hour)
+		if testTLSErr != nil {
+			return
+		}
+		server, testTLSErr = ca.IssueServer("test server", []string{"localhost"}, time.Hour)
+		if testTLSErr != nil {
+			return
+		}
+		client, testTLSErr = ca.IssueClient("test client", time.Hour)
+		if testTLSErr != nil {
+			return
+		}
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Certificate)
+
+		testServerTLS = &tls.Config{
+			Certificates: []tls.Certificate{server.TLSCertificate()},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		}
+		testClientTLS = &tls.Config{
+			Certificates: []tls.Certificate{client.TLSCertificate()},
+			RootCAs:      pool,
+			// the servers listen on all the addresses, which are not in the certificate
+			ServerName: "localhost",
+		}
+	})
+	require.NoError(t, testTLSErr)
+
+	return testServerTLS.Clone(), testClientTLS.Clone()
+}
+
 func requireSameLaptop(t *testing.T, laptop1 *pb.Laptop, laptop2 *pb.Laptop) {
 	json1, err := serializer.ProtobufToJSON(laptop1)
 	require.NoError(t, err)
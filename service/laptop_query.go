@@ -0,0 +1,588 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+)
+
+// LaptopQuery is a compiled text query such as
+//
+//	brand:Apple price_inr<90000 ram>=16GB (panel:OLED OR panel:IPS)
+//
+// Terms compare a laptop field with a value using one of the operators
+// : = != < <= > >=. Terms next to each other must all match, and they can be
+// combined with AND, OR, NOT and parentheses. Fields that hold several values,
+// like the GPUs or storages of a laptop, match when any of the values does
+type LaptopQuery struct {
+	match func(laptop *pb.Laptop) bool
+}
+
+// Match reports whether the laptop satisfies the query. An empty query matches every laptop
+func (query *LaptopQuery) Match(laptop *pb.Laptop) bool {
+	if query == nil || query.match == nil {
+		return true
+	}
+	return query.match(laptop)
+}
+
+// QueryError describes why a query cannot be parsed and where
+type QueryError struct {
+	Message  string
+	Position int
+	Token    string
+}
+
+func (err *QueryError) Error() string {
+	if err.Token == "" {
+		return fmt.Sprintf("%s at position %d", err.Message, err.Position)
+	}
+	return fmt.Sprintf("%s at position %d near %q", err.Message, err.Position, err.Token)
+}
+
+// The limits of the queries, which keep a client from exhausting the stack
+// of the parser or of Match with a long or deeply nested query
+const (
+	maxQueryLength = 4096
+	maxQueryDepth  = 64
+)
+
+// ParseLaptopQuery compiles a text query into a LaptopQuery
+func ParseLaptopQuery(query string) (*LaptopQuery, error) {
+	if len(query) > maxQueryLength {
+		return nil, &QueryError{Message: fmt.Sprintf("Query is longer than %d bytes", maxQueryLength), Position: maxQueryLength + 1}
+	}
+
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &queryParser{tokens: tokens}
+	if parser.peek().kind == tokenEnd {
+		return &LaptopQuery{}, nil
+	}
+
+	match, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != tokenEnd {
+		return nil, token.errorf("Unexpected token")
+	}
+
+	return &LaptopQuery{match: match}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+)
+
+type queryToken struct {
+	kind tokenKind
+	text string
+	// position is the 1-based column of the first character of the token
+	position int
+}
+
+func (token queryToken) errorf(format string, args ...interface{}) error {
+	return &QueryError{
+		Message:  fmt.Sprintf(format, args...),
+		Position: token.position,
+		Token:    token.text,
+	}
+}
+
+func (token queryToken) isKeyword(keyword string) bool {
+	return token.kind == tokenWord && strings.EqualFold(token.text, keyword)
+}
+
+func isQuerySeparator(c byte) bool {
+	return c == '(' || c == ')' || c == '"' || c == ':' || c == '=' || c == '<' || c == '>' || c == '!' ||
+		unicode.IsSpace(rune(c))
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+			continue
+
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokenLeftParen, text: "(", position: start + 1})
+			i++
+
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokenRightParen, text: ")", position: start + 1})
+			i++
+
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, &QueryError{Message: "Unterminated string", Position: start + 1, Token: query[start:]}
+			}
+			tokens = append(tokens, queryToken{kind: tokenString, text: query[i+1 : i+1+end], position: start + 1})
+			i += end + 2
+
+		case c == ':' || c == '=':
+			tokens = append(tokens, queryToken{kind: tokenOperator, text: string(c), position: start + 1})
+			i++
+
+		case c == '<' || c == '>' || c == '!':
+			i++
+			if i < len(query) && query[i] == '=' {
+				i++
+			} else if c == '!' {
+				return nil, &QueryError{Message: "Expected != operator", Position: start + 1, Token: "!"}
+			}
+			tokens = append(tokens, queryToken{kind: tokenOperator, text: query[start:i], position: start + 1})
+
+		default:
+			for i < len(query) && !isQuerySeparator(query[i]) {
+				i++
+			}
+			tokens = append(tokens, queryToken{kind: tokenWord, text: query[start:i], position: start + 1})
+		}
+	}
+
+	return append(tokens, queryToken{kind: tokenEnd, position: len(query) + 1}), nil
+}
+
+type laptopPredicate func(laptop *pb.Laptop) bool
+
+type queryParser struct {
+	tokens []queryToken
+	next   int
+	// depth is the number of parentheses and NOT the parser is in
+	depth int
+}
+
+// enter goes one level deeper into the query at the token, unless the query
+// is nested too deeply. The caller calls leave after the level is parsed
+func (parser *queryParser) enter(token queryToken) error {
+	if parser.depth >= maxQueryDepth {
+		return token.errorf("Query is nested deeper than %d levels", maxQueryDepth)
+	}
+	parser.depth++
+	return nil
+}
+
+func (parser *queryParser) leave() {
+	parser.depth--
+}
+
+func (parser *queryParser) peek() queryToken {
+	return parser.tokens[parser.next]
+}
+
+func (parser *queryParser) take() queryToken {
+	token := parser.tokens[parser.next]
+	if token.kind != tokenEnd {
+		parser.next++
+	}
+	return token
+}
+
+func (parser *queryParser) parseOr() (laptopPredicate, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().isKeyword("OR") {
+		parser.take()
+
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		a, b := left, right
+		left = func(laptop *pb.Laptop) bool { return a(laptop) || b(laptop) }
+	}
+
+	return left, nil
+}
+
+func (parser *queryParser) parseAnd() (laptopPredicate, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		token := parser.peek()
+		if token.kind == tokenEnd || token.kind == tokenRightParen || token.isKeyword("OR") {
+			return left, nil
+		}
+		if token.isKeyword("AND") {
+			parser.take()
+		}
+
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		a, b := left, right
+		left = func(laptop *pb.Laptop) bool { return a(laptop) && b(laptop) }
+	}
+}
+
+func (parser *queryParser) parseNot() (laptopPredicate, error) {
+	if !parser.peek().isKeyword("NOT") {
+		return parser.parsePrimary()
+	}
+	if err := parser.enter(parser.take()); err != nil {
+		return nil, err
+	}
+
+	inner, err := parser.parseNot()
+	parser.leave()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(laptop *pb.Laptop) bool { return !inner(laptop) }, nil
+}
+
+func (parser *queryParser) parsePrimary() (laptopPredicate, error) {
+	token := parser.take()
+
+	switch token.kind {
+	case tokenLeftParen:
+		if err := parser.enter(token); err != nil {
+			return nil, err
+		}
+
+		inner, err := parser.parseOr()
+		parser.leave()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := parser.take(); closing.kind != tokenRightParen {
+			return nil, token.errorf("Missing closing parenthesis")
+		}
+		return inner, nil
+
+	case tokenWord:
+		return parser.parseTerm(token)
+
+	case tokenEnd:
+		return nil, token.errorf("Unexpected end of query")
+
+	default:
+		return nil, token.errorf("Unexpected token")
+	}
+}
+
+func (parser *queryParser) parseTerm(fieldToken queryToken) (laptopPredicate, error) {
+	field := queryFields[strings.ToLower(fieldToken.text)]
+	if field == nil {
+		return nil, fieldToken.errorf("Unknown field")
+	}
+
+	operator := parser.take()
+	if operator.kind != tokenOperator {
+		return nil, operator.errorf("Expected an operator after field %s", fieldToken.text)
+	}
+
+	value := parser.take()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, value.errorf("Expected a value after %s%s", fieldToken.text, operator.text)
+	}
+
+	if field.numbers != nil {
+		return field.compileNumber(fieldToken, operator, value)
+	}
+	return field.compileText(fieldToken, operator, value)
+}
+
+// queryField describes how a query term reads a laptop field. Text fields
+// only support equality, which ignores case, while numeric fields support
+// every operator and parse their values with parse
+type queryField struct {
+	texts   func(laptop *pb.Laptop) []string
+	allowed []string
+
+	numbers func(laptop *pb.Laptop) []float64
+	parse   func(value string) (float64, error)
+}
+
+func (field *queryField) compileText(fieldToken, operator, value queryToken) (laptopPredicate, error) {
+	if operator.text != ":" && operator.text != "=" && operator.text != "!=" {
+		return nil, operator.errorf("Operator %s is not supported by field %s", operator.text, fieldToken.text)
+	}
+
+	if len(field.allowed) > 0 && !containsFold(field.allowed, value.text) {
+		return nil, value.errorf("Invalid value for field %s, expected one of %s",
+			fieldToken.text, strings.Join(field.allowed, ", "))
+	}
+
+	want := value.text
+	equal := func(laptop *pb.Laptop) bool {
+		return containsFold(field.texts(laptop), want)
+	}
+
+	if operator.text == "!=" {
+		return func(laptop *pb.Laptop) bool { return !equal(laptop) }, nil
+	}
+	return equal, nil
+}
+
+func (field *queryField) compileNumber(fieldToken, operator, value queryToken) (laptopPredicate, error) {
+	want, err := field.parse(value.text)
+	if err != nil {
+		return nil, value.errorf("Invalid value for field %s: %v", fieldToken.text, err)
+	}
+
+	var compare func(got float64) bool
+	switch operator.text {
+	case ":", "=":
+		compare = func(got float64) bool { return got == want }
+	case "!=":
+		compare = func(got float64) bool { return got != want }
+	case "<":
+		compare = func(got float64) bool { return got < want }
+	case "<=":
+		compare = func(got float64) bool { return got <= want }
+	case ">":
+		compare = func(got float64) bool { return got > want }
+	case ">=":
+		compare = func(got float64) bool { return got >= want }
+	}
+
+	return func(laptop *pb.Laptop) bool {
+		for _, got := range field.numbers(laptop) {
+			if compare(got) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseNumber(value string) (float64, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	return number, nil
+}
+
+var memoryUnits = map[string]pb.Memory_Unit{
+	"bit": pb.Memory_BIT,
+	"b":   pb.Memory_BYTE,
+	"kb":  pb.Memory_KILOBYTE,
+	"mb":  pb.Memory_MEGABYTE,
+	"gb":  pb.Memory_GIGYBYTE,
+	"tb":  pb.Memory_TERABYTE,
+}
+
+// parseMemory parses sizes like 16GB or 512mb into bits. A number without unit is taken as gigabytes
+func parseMemory(value string) (float64, error) {
+	number := strings.TrimRightFunc(value, unicode.IsLetter)
+	unit := strings.ToLower(value[len(number):])
+	if unit == "" {
+		unit = "gb"
+	}
+
+	memoryUnit, ok := memoryUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown memory unit %q", value[len(number):])
+	}
+
+	size, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a memory size", value)
+	}
+
+	return float64(toBit(&pb.Memory{Value: size, Unit: memoryUnit})), nil
+}
+
+// parseWeight parses weights like 1.5kg or 4lb into kilograms. A number without unit is taken as kilograms
+func parseWeight(value string) (float64, error) {
+	number := strings.TrimRightFunc(value, unicode.IsLetter)
+	unit := strings.ToLower(value[len(number):])
+
+	weight, err := parseNumber(number)
+	if err != nil {
+		return 0, err
+	}
+
+	switch unit {
+	case "", "kg":
+		return weight, nil
+	case "lb", "lbs":
+		return weight * poundToKg, nil
+	default:
+		return 0, fmt.Errorf("unknown weight unit %q", value[len(number):])
+	}
+}
+
+func enumNames(names map[int32]string) []string {
+	var allowed []string
+	for value, name := range names {
+		if value != 0 {
+			allowed = append(allowed, name)
+		}
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+func textField(texts func(laptop *pb.Laptop) []string, allowed ...string) *queryField {
+	return &queryField{texts: texts, allowed: allowed}
+}
+
+func numberField(parse func(value string) (float64, error), numbers func(laptop *pb.Laptop) []float64) *queryField {
+	return &queryField{numbers: numbers, parse: parse}
+}
+
+func boolText(value bool) []string {
+	return []string{strconv.FormatBool(value)}
+}
+
+// fieldAliases maps the short field names accepted by queries and facets to the full ones
+var fieldAliases = map[string]string{
+	"price":      "price_inr",
+	"year":       "release_year",
+	"cpu":        "cpu.brand",
+	"cores":      "cpu.cores",
+	"ghz":        "cpu.min_ghz",
+	"gpu":        "gpu.brand",
+	"gpu_memory": "gpu.memory",
+	"driver":     "storage.driver",
+	"screen":     "screen_size",
+	"width":      "resolution.width",
+	"height":     "resolution.height",
+	"keyboard":   "layout",
+}
+
+var queryFields = map[string]*queryField{}
+
+func init() {
+	fields := map[string]*queryField{
+		"brand": textField(func(laptop *pb.Laptop) []string { return []string{laptop.GetBrand()} }),
+		"name":  textField(func(laptop *pb.Laptop) []string { return []string{laptop.GetName()} }),
+		"price_inr": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{laptop.GetPriceInr()}
+		}),
+		"release_year": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(laptop.GetReleaseYear())}
+		}),
+
+		"cpu.brand": textField(func(laptop *pb.Laptop) []string { return []string{laptop.GetCpu().GetBrand()} }),
+		"cpu.name":  textField(func(laptop *pb.Laptop) []string { return []string{laptop.GetCpu().GetName()} }),
+		"cpu.cores": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(laptop.GetCpu().GetNumberOfCores())}
+		}),
+		"cpu.threads": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(laptop.GetCpu().GetNumberOfThreads())}
+		}),
+		"cpu.min_ghz": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{laptop.GetCpu().GetMinGhz()}
+		}),
+		"cpu.max_ghz": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{laptop.GetCpu().GetMaxGhz()}
+		}),
+
+		"ram": numberField(parseMemory, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(toBit(laptop.GetRam()))}
+		}),
+
+		"gpu.brand": textField(func(laptop *pb.Laptop) []string {
+			var brands []string
+			for _, gpu := range laptop.GetGpus() {
+				brands = append(brands, gpu.GetBrand())
+			}
+			return brands
+		}),
+		"gpu.name": textField(func(laptop *pb.Laptop) []string {
+			var names []string
+			for _, gpu := range laptop.GetGpus() {
+				names = append(names, gpu.GetName())
+			}
+			return names
+		}),
+		"gpu.memory": numberField(parseMemory, func(laptop *pb.Laptop) []float64 {
+			var memories []float64
+			for _, gpu := range laptop.GetGpus() {
+				memories = append(memories, float64(toBit(gpu.GetMemory())))
+			}
+			return memories
+		}),
+
+		"storage": numberField(parseMemory, func(laptop *pb.Laptop) []float64 {
+			var capacity uint64
+			for _, storage := range laptop.GetStorages() {
+				capacity += toBit(storage.GetMemory())
+			}
+			return []float64{float64(capacity)}
+		}),
+		"storage.driver": textField(func(laptop *pb.Laptop) []string {
+			var drivers []string
+			for _, storage := range laptop.GetStorages() {
+				drivers = append(drivers, storage.GetDriver().String())
+			}
+			return drivers
+		}, enumNames(pb.Storage_Driver_name)...),
+
+		"screen_size": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(laptop.GetScreen().GetScreenSize())}
+		}),
+		"resolution.width": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(laptop.GetScreen().GetResolution().GetWidth())}
+		}),
+		"resolution.height": numberField(parseNumber, func(laptop *pb.Laptop) []float64 {
+			return []float64{float64(laptop.GetScreen().GetResolution().GetHeight())}
+		}),
+		"panel": textField(func(laptop *pb.Laptop) []string {
+			return []string{laptop.GetScreen().GetPanel().String()}
+		}, enumNames(pb.Screen_Panel_name)...),
+		"multitouch": textField(func(laptop *pb.Laptop) []string {
+			return boolText(laptop.GetScreen().GetMultitouch())
+		}, "true", "false"),
+
+		"layout": textField(func(laptop *pb.Laptop) []string {
+			return []string{laptop.GetKeyboard().GetLayout().String()}
+		}, enumNames(pb.Keyboard_Layout_name)...),
+		"backlit": textField(func(laptop *pb.Laptop) []string {
+			return boolText(laptop.GetKeyboard().GetBacklit())
+		}, "true", "false"),
+
+		"weight": numberField(parseWeight, func(laptop *pb.Laptop) []float64 {
+			weight, ok := weightKg(laptop)
+			if !ok {
+				return nil
+			}
+			return []float64{weight}
+		}),
+	}
+
+	for name, field := range fields {
+		queryFields[name] = field
+	}
+	for alias, name := range fieldAliases {
+		queryFields[alias] = fields[name]
+	}
+}
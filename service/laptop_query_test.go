@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestParseLaptopQuery(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Brand = "Apple"
+	laptop.PriceInr = 85000
+	laptop.Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGYBYTE}
+	laptop.Screen.Panel = pb.Screen_IPS
+	laptop.Keyboard.Backlit = true
+	laptop.Weight = &pb.Laptop_WeightPound{WeightPound: 4}
+
+	testCases := []struct {
+		query string
+		match bool
+	}{
+		{"", true},
+		{"brand:Apple price_inr<90000 ram>=16GB (panel:OLED OR panel:IPS)", true},
+		{"brand:apple AND price<85000", false},
+		{"brand:Dell OR ram>=16384MB", true},
+		{"NOT panel:IPS", false},
+		{"brand!=Dell backlit:true", true},
+		{`name:"` + laptop.Name + `"`, true},
+		{"weight<=4lb weight>1.8kg", true},
+		{"(brand:Dell OR brand:Lenovo) ram>8", false},
+	}
+
+	for _, tc := range testCases {
+		query, err := service.ParseLaptopQuery(tc.query)
+		require.NoError(t, err, tc.query)
+		require.Equal(t, tc.match, query.Match(laptop), tc.query)
+	}
+}
+
+func TestParseLaptopQueryZeroBound(t *testing.T) {
+	t.Parallel()
+
+	// a zero bound of a filter is unset, the query is how to ask for free laptops
+	laptop := sample.NewLaptop()
+	laptop.PriceInr = 0
+
+	query, err := service.ParseLaptopQuery("price_inr<=0")
+	require.NoError(t, err)
+	require.True(t, query.Match(laptop))
+
+	laptop.PriceInr = 1
+	require.False(t, query.Match(laptop))
+}
+
+func TestParseLaptopQueryDepth(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	nested := strings.Repeat("(", 32) + strings.Repeat("NOT ", 32) + "brand:" + laptop.GetBrand() + strings.Repeat(")", 32)
+
+	query, err := service.ParseLaptopQuery(nested)
+	require.NoError(t, err)
+	require.True(t, query.Match(laptop))
+}
+
+func TestParseLaptopQueryError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		query    string
+		position int
+		token    string
+	}{
+		{"colour:red", 1, "colour"},
+		{"brand:Apple price<cheap", 19, "cheap"},
+		{"ram>=16XB", 6, "16XB"},
+		{"panel:LCD", 7, "LCD"},
+		{"brand<Apple", 6, "<"},
+		{"(brand:Apple OR brand:Dell", 1, "("},
+		{"brand:Apple)", 12, ")"},
+		{"brand:", 7, ""},
+		{`name:"Mac`, 6, `"Mac`},
+		{strings.Repeat("(", 65) + "brand:Apple" + strings.Repeat(")", 65), 65, "("},
+		{strings.Repeat("NOT ", 65) + "brand:Apple", 257, "NOT"},
+		{strings.Repeat("(", 3<<20), 4097, ""},
+		{strings.Repeat("brand:Apple ", 400), 4097, ""},
+	}
+
+	for _, tc := range testCases {
+		_, err := service.ParseLaptopQuery(tc.query)
+
+		queryErr, ok := err.(*service.QueryError)
+		require.True(t, ok, tc.query)
+		require.Equal(t, tc.position, queryErr.Position, tc.query)
+		require.Equal(t, tc.token, queryErr.Token, tc.query)
+	}
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+)
+
+var ErrInvalidPageToken = errors.New("Invalid page token")
+
+// SearchOptions controls the order and the paging of the laptops returned by LaptopStore.Search
+type SearchOptions struct {
+	SortBy     pb.SearchLaptopRequest_SortBy
+	Descending bool
+	// PageSize limits the number of laptops returned, zero means no limit
+	PageSize  int
+	PageToken string
+	// Query further restricts the laptops matched by the filter
+	Query *LaptopQuery
+	// Rating returns the average score of a laptop when sorting by rating
+	Rating func(laptopID string) float64
+}
+
+// matches reports whether the laptop satisfies the query of the options
+func (options *SearchOptions) matches(laptop *pb.Laptop) bool {
+	return options == nil || options.Query.Match(laptop)
+}
+
+// pageToken marks the last laptop of a page. The next page starts right after
+// it in the same order, so laptops added or removed in between do not shift
+// the pages
+type pageToken struct {
+	SortBy     pb.SearchLaptopRequest_SortBy `json:"sort_by"`
+	Descending bool                          `json:"descending"`
+	Key        float64                       `json:"key"`
+	ID         string                        `json:"id"`
+}
+
+// encodePageToken turns a page token into an opaque string
+func encodePageToken(token interface{}) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePageToken(value string, token interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ErrInvalidPageToken
+	}
+
+	err = json.Unmarshal(data, token)
+	if err != nil {
+		return ErrInvalidPageToken
+	}
+	return nil
+}
+
+// sortKey returns the value of the laptop field laptops are sorted by
+func sortKey(laptop *pb.Laptop, options *SearchOptions) float64 {
+	switch options.SortBy {
+	case pb.SearchLaptopRequest_PRICE_INR:
+		return laptop.GetPriceInr()
+	case pb.SearchLaptopRequest_RELEASE_YEAR:
+		return float64(laptop.GetReleaseYear())
+	case pb.SearchLaptopRequest_CPU_GHZ:
+		return laptop.GetCpu().GetMinGhz()
+	case pb.SearchLaptopRequest_RAM:
+		return float64(toBit(laptop.GetRam()))
+	case pb.SearchLaptopRequest_SCREEN_SIZE:
+		return float64(laptop.GetScreen().GetScreenSize())
+	case pb.SearchLaptopRequest_WEIGHT:
+		weight, _ := weightKg(laptop)
+		return weight
+	case pb.SearchLaptopRequest_AVERAGE_RATING:
+		if options.Rating == nil {
+			return 0
+		}
+		return options.Rating(laptop.GetId())
+	default:
+		return 0
+	}
+}
+
+// sortAndPage orders the laptops as requested and keeps the ones on the page
+// selected by the page token. It returns the token of the next page, which is
+// empty when there are no more laptops
+func sortAndPage(laptops []*pb.Laptop, options *SearchOptions) ([]*pb.Laptop, string, error) {
+	if options == nil {
+		options = &SearchOptions{}
+	}
+
+	keys := make(map[string]float64, len(laptops))
+	for _, laptop := range laptops {
+		keys[laptop.GetId()] = sortKey(laptop, options)
+	}
+
+	// less orders two positions ascending and reverses the result when the order is descending
+	less := func(keyA float64, idA string, keyB float64, idB string) bool {
+		if keyA != keyB {
+			return (keyA < keyB) != options.Descending
+		}
+		return (idA < idB) != options.Descending
+	}
+
+	sort.Slice(laptops, func(i, j int) bool {
+		a, b := laptops[i].GetId(), laptops[j].GetId()
+		return less(keys[a], a, keys[b], b)
+	})
+
+	if options.PageToken != "" {
+		token := &pageToken{}
+		err := decodePageToken(options.PageToken, token)
+		if err != nil {
+			return nil, "", err
+		}
+		if token.SortBy != options.SortBy || token.Descending != options.Descending {
+			return nil, "", ErrInvalidPageToken
+		}
+
+		start := sort.Search(len(laptops), func(i int) bool {
+			id := laptops[i].GetId()
+			return less(token.Key, token.ID, keys[id], id)
+		})
+		laptops = laptops[start:]
+	}
+
+	if options.PageSize <= 0 || len(laptops) <= options.PageSize {
+		return laptops, "", nil
+	}
+
+	laptops = laptops[:options.PageSize]
+	last := laptops[len(laptops)-1]
+
+	nextPageToken, err := encodePageToken(&pageToken{
+		SortBy:     options.SortBy,
+		Descending: options.Descending,
+		Key:        keys[last.GetId()],
+		ID:         last.GetId(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return laptops, nextPageToken, nil
+}
@@ -1,32 +1,105 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"strconv"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	"github.com/google/uuid"
 	"gitlab.com/keshavbhattad/pcbook/pb"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-const MaxImageSize = 2 << 20
+// DefaultMaxImageSize is the largest image accepted by UploadImage unless the
+// limit is changed with SetMaxImageSize
+const DefaultMaxImageSize = 2 << 20
+
+// DefaultMinScore, DefaultMaxScore and DefaultScoreStep are the range of the
+// scores accepted by RateLaptop unless it is changed with SetScoreRange
+const (
+	DefaultMinScore  = 1
+	DefaultMaxScore  = 10
+	DefaultScoreStep = 0.5
+)
+
+// maxScoreSteps bounds the number of scores in a range, which is the number
+// of buckets of the histogram of a rating
+const maxScoreSteps = 1000
+
+// imageChunkSize is the size of the chunks an image is downloaded in
+const imageChunkSize = 32 << 10
+
+// uploadIDHeader and uploadOffsetHeader are the header metadata UploadImage
+// sends back, so that a client can resume the upload if the stream breaks
+const (
+	uploadIDHeader     = "upload-id"
+	uploadOffsetHeader = "upload-offset"
+)
 
 type LaptopServer struct {
-	laptopStore LaptopStore
-	imageStore  ImageStore
-	ratingStore RatingStore
+	laptopStore   LaptopStore
+	imageStore    ImageStore
+	uploadStore   UploadStore
+	ratingStore   RatingStore
+	maxImageSize  int
+	imageVariants []ImageVariant
+	minScore      float64
+	maxScore      float64
+	scoreStep     float64
 }
 
 func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
 	return &LaptopServer{
-		laptopStore: laptopStore,
-		imageStore:  imageStore,
-		ratingStore: ratingStore,
+		laptopStore:   laptopStore,
+		imageStore:    imageStore,
+		uploadStore:   NewInMemoryUploadStore(),
+		ratingStore:   ratingStore,
+		maxImageSize:  DefaultMaxImageSize,
+		imageVariants: DefaultImageVariants,
+		minScore:      DefaultMinScore,
+		maxScore:      DefaultMaxScore,
+		scoreStep:     DefaultScoreStep,
+	}
+}
+
+// SetMaxImageSize changes the largest image size in bytes accepted by UploadImage
+func (server *LaptopServer) SetMaxImageSize(size int) {
+	server.maxImageSize = size
+}
+
+// SetUploadStore changes the store keeping the uploads in progress. The
+// default InMemoryUploadStore keeps a bounded amount of data in memory, and is
+// meant for tests. Servers should use DiskUploadStore
+func (server *LaptopServer) SetUploadStore(uploadStore UploadStore) {
+	server.uploadStore = uploadStore
+}
+
+// SetScoreRange changes the scores accepted by RateLaptop, which are the
+// multiples of step from the lowest score up to the highest one
+func (server *LaptopServer) SetScoreRange(minScore float64, maxScore float64, step float64) error {
+	err := checkScoreRange(minScore, maxScore, step)
+	if err != nil {
+		return err
 	}
+
+	server.minScore = minScore
+	server.maxScore = maxScore
+	server.scoreStep = step
+	return nil
+}
+
+// SetImageVariants changes the resized variants made of every uploaded image
+func (server *LaptopServer) SetImageVariants(variants []ImageVariant) {
+	server.imageVariants = variants
 }
 
 func (server *LaptopServer) SearchLaptop(
@@ -34,25 +107,60 @@ func (server *LaptopServer) SearchLaptop(
 	stream pb.LaptopService_SearchLaptopServer,
 ) error {
 	filter := req.GetFilter()
-	log.Printf("Received a search-laptop request with filter: %v", filter)
+	log.Printf("Received a search-laptop request with filter: %v and query: %q", filter, req.GetQuery())
 
-	err := server.laptopStore.Search(
+	query, err := ParseLaptopQuery(req.GetQuery())
+	if err != nil {
+		return logError(status.Errorf(codes.InvalidArgument, "Invalid query: %v", err))
+	}
+
+	options := &SearchOptions{
+		SortBy:     req.GetSortBy(),
+		Descending: req.GetDescending(),
+		PageSize:   int(req.GetPageSize()),
+		PageToken:  req.GetPageToken(),
+		Query:      query,
+		Rating:     server.averageRating,
+	}
+
+	// Each laptop is sent once the next one is found, so that the last one
+	// can carry the token of the next page
+	var pending *pb.Laptop
+	send := func(nextPageToken string) error {
+		res := &pb.SearchLaptopResponse{Laptop: pending, NextPageToken: nextPageToken}
+
+		err := stream.Send(res)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("sent laptop with ID: %s", pending.GetId())
+		return nil
+	}
+
+	nextPageToken, err := server.laptopStore.Search(
 		stream.Context(),
 		filter,
+		options,
 		func(laptop *pb.Laptop) error {
-			res := &pb.SearchLaptopResponse{Laptop: laptop}
-
-			err := stream.Send(res)
-
-			if err != nil {
-				return err
+			if pending != nil {
+				if err := send(""); err != nil {
+					return err
+				}
 			}
 
-			log.Printf("sent laptop with ID: %s", laptop.GetId())
+			pending = laptop
 			return nil
 		},
 	)
 
+	if err == nil && pending != nil {
+		err = send(nextPageToken)
+	}
+
+	if errors.Is(err, ErrInvalidPageToken) {
+		return status.Errorf(codes.InvalidArgument, "Cannot search laptop: %v", err)
+	}
 	if err != nil {
 		return status.Errorf(codes.Internal, "unexpected error: %v", err)
 	}
@@ -60,6 +168,39 @@ func (server *LaptopServer) SearchLaptop(
 	return nil
 }
 
+func (server *LaptopServer) AggregateLaptops(ctx context.Context, req *pb.AggregateLaptopsRequest) (*pb.AggregateLaptopsResponse, error) {
+	log.Printf("Received an aggregate-laptops request with facets %v, filter: %v and query: %q",
+		req.GetFacets(), req.GetFilter(), req.GetQuery())
+
+	query, err := ParseLaptopQuery(req.GetQuery())
+	if err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Invalid query: %v", err))
+	}
+
+	res, err := server.laptopStore.Aggregate(ctx, req.GetFilter(), query, req.GetFacets())
+	if errors.Is(err, ErrUnknownFacet) {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Cannot aggregate laptops: %v", err))
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot aggregate laptops: %v", err))
+	}
+
+	return res, nil
+}
+
+func (server *LaptopServer) averageRating(laptopID string) float64 {
+	if server.ratingStore == nil {
+		return 0
+	}
+
+	rating, err := server.ratingStore.Find(laptopID)
+	if err != nil {
+		return 0
+	}
+
+	return rating.Mean()
+}
+
 func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
 	laptop := req.GetLaptop()
 	log.Printf("Received create-laptop request with ID: %s", laptop.Id)
@@ -82,6 +223,10 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 		return nil, err
 	}
 
+	if laptop.CreatedAt == nil {
+		laptop.CreatedAt = ptypes.TimestampNow()
+	}
+
 	// Save laptop Id on database normally
 	// Here laptop Id is stored in-memory
 	err := server.laptopStore.Save(laptop)
@@ -99,6 +244,105 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 	return res, nil
 }
 
+func (server *LaptopServer) GetLaptop(ctx context.Context, req *pb.GetLaptopRequest) (*pb.GetLaptopResponse, error) {
+	laptopID := req.GetId()
+	log.Printf("Received get-laptop request with ID: %s", laptopID)
+
+	if _, err := uuid.Parse(laptopID); err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Laptop ID is not valid UUID: %v", err))
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	laptop, err := server.laptopStore.Find(laptopID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot find laptop: %v", err))
+	}
+
+	res := &pb.GetLaptopResponse{
+		Laptop: laptop,
+	}
+	return res, nil
+}
+
+func (server *LaptopServer) UpdateLaptop(ctx context.Context, req *pb.UpdateLaptopRequest) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	log.Printf("Received update-laptop request with ID: %s", laptop.GetId())
+
+	if laptop == nil {
+		return nil, logError(status.Error(codes.InvalidArgument, "Laptop is missing"))
+	}
+
+	if _, err := uuid.Parse(laptop.Id); err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Laptop ID is not valid UUID: %v", err))
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// the creation time belongs to the server, not to the client
+	stored, err := server.laptopStore.Find(laptop.Id)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot find laptop in the store: %v", err))
+	}
+	laptop.CreatedAt = stored.GetCreatedAt()
+	laptop.UpdatedAt = ptypes.TimestampNow()
+
+	err = server.laptopStore.Update(laptop)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot update laptop in the store: %v", err))
+	}
+	log.Printf("Laptop updated with id: %s", laptop.Id)
+
+	res := &pb.UpdateLaptopResponse{
+		Laptop: laptop,
+	}
+	return res, nil
+}
+
+func (server *LaptopServer) DeleteLaptop(ctx context.Context, req *pb.DeleteLaptopRequest) (*pb.DeleteLaptopResponse, error) {
+	laptopID := req.GetId()
+	log.Printf("Received delete-laptop request with ID: %s", laptopID)
+
+	if _, err := uuid.Parse(laptopID); err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Laptop ID is not valid UUID: %v", err))
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err := server.laptopStore.Delete(laptopID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot delete laptop from the store: %v", err))
+	}
+	log.Printf("Laptop deleted with id: %s", laptopID)
+
+	res := &pb.DeleteLaptopResponse{
+		Id: laptopID,
+	}
+	return res, nil
+}
+
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
@@ -119,22 +363,43 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
-	log.Printf("Received an upload-image request for laptop %s with image type %s\n", laptopID, imageType)
+	uploadID := req.GetInfo().GetUploadId()
+	log.Printf("Received an upload-image request for laptop %s with image type %s and upload ID %q\n", laptopID, imageType, uploadID)
 
 	laptop, err := server.laptopStore.Find(laptopID)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "Cannot find laptop: %v", err))
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return logError(status.Errorf(code, "Cannot find laptop: %v", err))
 	}
 	if laptop == nil {
 		return logError(status.Errorf(codes.NotFound, "Laptop not found: %v", err))
 	}
 
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	upload, err := server.startUpload(uploadID, laptopID, imageType)
+	if err != nil {
+		return err
+	}
+
+	header := metadata.Pairs(
+		uploadIDHeader, upload.ID,
+		uploadOffsetHeader, strconv.FormatInt(upload.Size, 10),
+	)
+	err = stream.SendHeader(header)
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "Cannot send header: %v", err))
+	}
+
+	// chunks go straight to the upload store, so the size limit does not cost
+	// memory. The upload is kept when the stream breaks, so that the client
+	// can resume it
+	imageSize := upload.Size
 
 	for {
 		if err := contextError(stream.Context()); err != nil {
-			return nil
+			return err
 		}
 
 		log.Print("Waiting to receive more data")
@@ -148,28 +413,69 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 			return logError(status.Errorf(codes.Unknown, "Cannot receive chunk: %v", err))
 		}
 
-		chunk := req.GetChunkData()
-		size := len(chunk)
-		imageSize += size
+		data := req.GetChunkData()
+		offset := imageSize
+		if chunk := req.GetChunk(); chunk != nil {
+			// the offset is compared before its conversion, which would turn
+			// the offsets of 1<<63 and above negative
+			if chunk.GetOffset() > uint64(imageSize) {
+				return logError(status.Errorf(codes.InvalidArgument, "Chunk at offset %d leaves a gap after the %d bytes uploaded", chunk.GetOffset(), imageSize))
+			}
+			data = chunk.GetData()
+			offset = int64(chunk.GetOffset())
+		}
+
+		// data sent again after a resume is already uploaded
+		if skip := imageSize - offset; skip < int64(len(data)) {
+			data = data[skip:]
+		} else {
+			continue
+		}
 
-		if imageSize > MaxImageSize {
-			return logError(status.Error(codes.InvalidArgument, "File is too large"))
+		if imageSize+int64(len(data)) > int64(server.maxImageSize) {
+			server.deleteUpload(upload.ID)
+			return logError(status.Errorf(codes.InvalidArgument, "File is too large: the limit is %d bytes", server.maxImageSize))
 		}
 
-		_, err = imageData.Write(chunk)
+		size, err := server.uploadStore.Append(upload.ID, imageSize, data)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "Cannot write chunk data: %v", err))
+			code := codes.Internal
+			if errors.Is(err, ErrUploadOffset) {
+				code = codes.Aborted
+			}
+			if errors.Is(err, ErrUploadStoreFull) {
+				code = codes.ResourceExhausted
+			}
+			return logError(status.Errorf(code, "Cannot write chunk data: %v", err))
 		}
+
+		// the format is checked as soon as it is known rather than at the end
+		if imageSize < imageHeaderSize && size >= imageHeaderSize {
+			if err := server.checkUploadFormat(upload); err != nil {
+				return err
+			}
+		}
+		imageSize = size
 	}
 
-	imageID, err := server.imageStore.Save(laptopID, imageType, imageData)
+	info, err := server.completeUpload(stream.Context(), upload)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "Cannot save image to the store: %v", err))
+		return err
 	}
+	imageID := info.ID
+
+	// the upload succeeded even if the variants cannot be made, the image is
+	// then downloaded without them
+	variants, err := SaveImageVariants(stream.Context(), server.imageStore, info, server.imageVariants)
+	if err != nil {
+		log.Printf("Cannot save variants of image %s: %v", imageID, err)
+	}
+	log.Printf("Saved %d variants of image %s", len(variants), imageID)
 
 	res := &pb.UploadImageResponse{
-		Id:   imageID,
-		Size: uint32(imageSize),
+		Id:           imageID,
+		Size:         uint32(info.Size),
+		Deduplicated: info.Deduplicated,
 	}
 
 	err = stream.SendAndClose(res)
@@ -177,11 +483,248 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return logError(status.Errorf(codes.Unknown, "Cannot send the response and close the stream: %v", err))
 	}
 
-	log.Printf("Image is successfully saved with id: %s and size: %d", imageID, imageSize)
+	log.Printf("Image is successfully saved with id: %s and size: %d, deduplicated: %t", imageID, info.Size, info.Deduplicated)
+
+	return nil
+}
+
+// startUpload resumes the upload with the given ID, or starts a new one
+func (server *LaptopServer) startUpload(uploadID string, laptopID string, imageType string) (*UploadInfo, error) {
+	if uploadID == "" {
+		uploadID = uuid.New().String()
+	} else if _, err := uuid.Parse(uploadID); err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Upload ID is not a valid UUID: %v", err))
+	}
+
+	upload, err := server.uploadStore.Find(uploadID)
+	if errors.Is(err, ErrNotFound) {
+		upload, err = server.uploadStore.Create(uploadID, laptopID, imageType)
+		if errors.Is(err, ErrAlreadyExists) {
+			return nil, logError(status.Errorf(codes.Aborted, "Upload %s is started by another request", uploadID))
+		}
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot start upload: %v", err))
+	}
+
+	if upload.LaptopID != laptopID || upload.ImageType != imageType {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Upload %s is for laptop %s with image type %s", uploadID, upload.LaptopID, upload.ImageType))
+	}
+
+	log.Printf("Upload %s starts at offset %d", upload.ID, upload.Size)
+	return upload, nil
+}
+
+// checkUploadFormat checks the format of the data uploaded so far against the
+// declared image type, and drops the upload if it is not a valid image
+func (server *LaptopServer) checkUploadFormat(upload *UploadInfo) error {
+	_, reader, err := server.uploadStore.Open(upload.ID)
+	if err != nil {
+		return logError(status.Errorf(codes.Internal, "Cannot read upload: %v", err))
+	}
+	defer reader.Close()
+
+	header := make([]byte, imageHeaderSize)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return logError(status.Errorf(codes.Internal, "Cannot read upload: %v", err))
+	}
+
+	_, err = detectImageFormat(upload.ImageType, header)
+	if err != nil {
+		server.deleteUpload(upload.ID)
+		return logError(status.Errorf(codes.InvalidArgument, "Invalid image: %v", err))
+	}
+
+	return nil
+}
+
+// completeUpload saves the uploaded data to the image store and drops the upload
+func (server *LaptopServer) completeUpload(ctx context.Context, upload *UploadInfo) (*ImageInfo, error) {
+	_, reader, err := server.uploadStore.Open(upload.ID)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot read upload: %v", err))
+	}
+	defer reader.Close()
+
+	info, err := SaveImage(ctx, server.imageStore, upload.LaptopID, upload.ImageType, reader)
+	if errors.Is(err, ErrUnsupportedImage) || errors.Is(err, ErrImageTypeMismatch) {
+		server.deleteUpload(upload.ID)
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Invalid image: %v", err))
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot save image to the store: %v", err))
+	}
+
+	server.deleteUpload(upload.ID)
+	return info, nil
+}
+
+func (server *LaptopServer) deleteUpload(uploadID string) {
+	err := server.uploadStore.Delete(uploadID)
+	if err != nil {
+		log.Printf("Cannot delete upload %s: %v", uploadID, err)
+	}
+}
+
+func (server *LaptopServer) QueryUpload(ctx context.Context, req *pb.QueryUploadRequest) (*pb.QueryUploadResponse, error) {
+	uploadID := req.GetUploadId()
+	log.Printf("Received a query-upload request for upload %s", uploadID)
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	_, err := uuid.Parse(uploadID)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Upload ID is not a valid UUID: %v", err))
+	}
+
+	upload, err := server.uploadStore.Find(uploadID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot find upload: %v", err))
+	}
+
+	res := &pb.QueryUploadResponse{
+		UploadId:  upload.ID,
+		LaptopId:  upload.LaptopID,
+		ImageType: upload.ImageType,
+		Offset:    uint64(upload.Size),
+	}
+	return res, nil
+}
+
+func (server *LaptopServer) ListImages(ctx context.Context, req *pb.ListImagesRequest) (*pb.ListImagesResponse, error) {
+	laptopID := req.GetLaptopId()
+	log.Printf("Received a list-images request for laptop %s", laptopID)
+
+	_, err := server.laptopStore.Find(laptopID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot find laptop: %v", err))
+	}
+
+	images, err := server.imageStore.List(ctx, laptopID)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot list images: %v", err))
+	}
+
+	res := &pb.ListImagesResponse{}
+	for _, info := range images {
+		res.Images = append(res.Images, imageToProto(info))
+	}
+
+	return res, nil
+}
+
+func (server *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageID := req.GetImageId()
+	variant := req.GetVariant()
+	log.Printf("Received a download-image request for image %s variant %q", imageID, variant)
 
+	var info *ImageInfo
+	var reader io.ReadCloser
+	var err error
+	if variant == "" {
+		info, reader, err = server.imageStore.Open(stream.Context(), imageID)
+	} else {
+		info, reader, err = server.imageStore.OpenVariant(stream.Context(), imageID, variant)
+	}
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return logError(status.Errorf(code, "Cannot open image: %v", err))
+	}
+	defer reader.Close()
+
+	res := &pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: imageToProto(info),
+		},
+	}
+
+	err = stream.Send(res)
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "Cannot send image info: %v", err))
+	}
+
+	buffer := make([]byte, imageChunkSize)
+	imageSize := 0
+
+	for {
+		if err := contextError(stream.Context()); err != nil {
+			return err
+		}
+
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			res := &pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_ChunkData{
+					ChunkData: buffer[:n],
+				},
+			}
+
+			if err := stream.Send(res); err != nil {
+				return logError(status.Errorf(codes.Unknown, "Cannot send chunk: %v", err))
+			}
+			imageSize += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(status.Errorf(codes.Internal, "Cannot read image: %v", err))
+		}
+	}
+
+	log.Printf("Image %s is successfully sent with size: %d", imageID, imageSize)
 	return nil
 }
 
+func (server *LaptopServer) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	imageID := req.GetImageId()
+	log.Printf("Received a delete-image request for image %s", imageID)
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err := server.imageStore.Delete(ctx, imageID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot delete image: %v", err))
+	}
+
+	log.Printf("Image deleted with id: %s", imageID)
+	return &pb.DeleteImageResponse{ImageId: imageID}, nil
+}
+
+func imageToProto(info *ImageInfo) *pb.Image {
+	return &pb.Image{
+		Id:         info.ID,
+		LaptopId:   info.LaptopID,
+		ImageType:  info.Type,
+		MimeType:   info.MimeType,
+		Size:       uint32(info.Size),
+		Sha256:     info.Hash,
+		UploadedAt: timestamppb.New(info.UploadedAt),
+		Variant:    info.Variant,
+		Variants:   info.Variants,
+	}
+}
+
 func logError(err error) error {
 	if err != nil {
 		log.Print(err)
@@ -189,6 +732,62 @@ func logError(err error) error {
 	return err
 }
 
+// ratingUserID returns the user a rating is given by, who is the authenticated
+// user when the server authenticates the calls. Each user has one score per
+// laptop, so that a client cannot rate a laptop over and over. The clients
+// sending no user ID, written before it existed, rate as their address
+func ratingUserID(ctx context.Context, req *pb.RateLaptopRequest) (string, error) {
+	userID, err := callerID(ctx, req.GetUserId())
+	if err != nil {
+		return "", logError(err)
+	}
+	if userID == "" {
+		host, ok := peerHost(ctx)
+		if !ok {
+			return "", logError(status.Error(codes.InvalidArgument, "User ID is required to rate a laptop"))
+		}
+		userID = "peer:" + host
+	}
+	return userID, nil
+}
+
+// checkScoreRange returns an error if the range has no score, or too many
+// scores for the histograms of the ratings
+func checkScoreRange(minScore float64, maxScore float64, step float64) error {
+	// NaN fails the comparisons
+	if !(minScore <= maxScore) || math.IsInf(minScore, 0) || math.IsInf(maxScore, 0) {
+		return fmt.Errorf("Invalid score range: %v to %v", minScore, maxScore)
+	}
+	if !(step > 0) || (maxScore-minScore)/step > maxScoreSteps {
+		return fmt.Errorf("Invalid score step %v: the range must have at most %d steps", step, maxScoreSteps)
+	}
+	return nil
+}
+
+// checkScore returns an InvalidArgument error if the score is not in the range
+// or not on a step of it. Otherwise it returns the score rounded to its step,
+// so that the scores of a step are the same in the histograms
+func checkScore(score float64, minScore float64, maxScore float64, step float64) (float64, error) {
+	// NaN fails both comparisons
+	if !(score >= minScore && score <= maxScore) {
+		return 0, logError(status.Errorf(
+			codes.InvalidArgument,
+			"Score %v is out of range, it must be between %v and %v",
+			score, minScore, maxScore,
+		))
+	}
+
+	steps := (score - minScore) / step
+	if math.Abs(steps-math.Round(steps)) > 1e-9 {
+		return 0, logError(status.Errorf(
+			codes.InvalidArgument,
+			"Score %v is not a multiple of %v from %v",
+			score, step, minScore,
+		))
+	}
+	return minScore + math.Round(steps)*step, nil
+}
+
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
 	for {
 		err := contextError(stream.Context())
@@ -209,6 +808,16 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		log.Printf("Received a request with laptop ID: %s and score: %.2f", laptopID, score)
 
+		userID, err := ratingUserID(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		score, err = checkScore(score, server.minScore, server.maxScore, server.scoreStep)
+		if err != nil {
+			return err
+		}
+
 		found, err := server.laptopStore.Find(laptopID)
 		if err != nil {
 			return logError(status.Errorf(codes.Internal, "Cannot find a laptop: %v", err))
@@ -217,7 +826,7 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 			return logError(status.Errorf(codes.NotFound, "Laptop with id %s is not found", laptopID))
 		}
 
-		rating, err := server.ratingStore.Add(laptopID, score)
+		rating, err := server.ratingStore.Add(laptopID, userID, score)
 		if err != nil {
 			return logError(status.Errorf(codes.Internal, "Cannot add the rating: %v", err))
 		}
@@ -225,7 +834,7 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 		res := &pb.RateLaptopResponse{
 			LaptopId:     laptopID,
 			RatedCount:   rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+			AverageScore: rating.Mean(),
 		}
 
 		err = stream.Send(res)
@@ -237,3 +846,137 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 	}
 	return nil
 }
+
+func (server *LaptopServer) GetLaptopRating(ctx context.Context, req *pb.GetLaptopRatingRequest) (*pb.GetLaptopRatingResponse, error) {
+	laptopID := req.GetLaptopId()
+	log.Printf("Received get-laptop-rating request with laptop ID: %s", laptopID)
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	found, err := server.laptopStore.Find(laptopID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return nil, logError(status.Errorf(code, "Cannot find laptop: %v", err))
+	}
+	if found == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "Laptop with id %s is not found", laptopID))
+	}
+
+	res := &pb.GetLaptopRatingResponse{LaptopId: laptopID}
+
+	rating, err := server.ratingStore.Find(laptopID)
+	if errors.Is(err, ErrNotFound) {
+		// the laptop is not rated yet
+		return res, nil
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot find the rating: %v", err))
+	}
+
+	res.Count = rating.Count
+	res.Mean = rating.Mean()
+	res.Median = rating.Median()
+	res.StdDev = rating.StdDev()
+	for _, bucket := range rating.Buckets() {
+		res.Buckets = append(res.Buckets, &pb.ScoreBucket{Score: bucket.Score, Count: bucket.Count})
+	}
+
+	return res, nil
+}
+
+func (server *LaptopServer) ListRatings(req *pb.ListRatingsRequest, stream pb.LaptopService_ListRatingsServer) error {
+	log.Printf("Received list-ratings request from %v to %v", req.GetFrom(), req.GetTo())
+
+	var from, to time.Time
+	if req.GetFrom() != nil {
+		if err := req.GetFrom().CheckValid(); err != nil {
+			return logError(status.Errorf(codes.InvalidArgument, "Invalid from time: %v", err))
+		}
+		from = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		if err := req.GetTo().CheckValid(); err != nil {
+			return logError(status.Errorf(codes.InvalidArgument, "Invalid to time: %v", err))
+		}
+		to = req.GetTo().AsTime()
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return logError(status.Errorf(codes.InvalidArgument, "The to time is before the from time"))
+	}
+
+	err := server.ratingStore.ListRatings(stream.Context(), from, to, func(event *RatingEvent) error {
+		return stream.Send(&pb.ListRatingsResponse{
+			LaptopId: event.LaptopID,
+			UserId:   event.UserID,
+			Score:    event.Score,
+			RatedAt:  timestamppb.New(event.Time),
+		})
+	})
+	if err != nil {
+		if ctxErr := contextError(stream.Context()); ctxErr != nil {
+			return ctxErr
+		}
+		return logError(status.Errorf(codes.Internal, "Cannot list ratings: %v", err))
+	}
+	return nil
+}
+
+func (server *LaptopServer) TopRatedLaptops(req *pb.TopRatedLaptopsRequest, stream pb.LaptopService_TopRatedLaptopsServer) error {
+	filter := req.GetFilter()
+	limit := int(req.GetLimit())
+	log.Printf(
+		"Received top-rated-laptops request with filter: %v, min rating count: %d and limit: %d",
+		filter, req.GetMinRatingCount(), limit,
+	)
+
+	// the entries are copied under the lock of the rating store, and the
+	// laptops are found, filtered and sent once it is released, so that the
+	// laptop store and a slow client do not hold up the ratings
+	var entries []RankedRating
+	server.ratingStore.TopRated(req.GetMinRatingCount(), func(entry *RankedRating) bool {
+		entries = append(entries, *entry)
+		return true
+	})
+
+	var top []*pb.TopRatedLaptopsResponse
+	for _, entry := range entries {
+		if limit > 0 && len(top) >= limit {
+			break
+		}
+		if err := contextError(stream.Context()); err != nil {
+			return err
+		}
+
+		laptop, err := server.laptopStore.Find(entry.LaptopID)
+		if errors.Is(err, ErrNotFound) {
+			// the laptop was deleted after being rated
+			continue
+		}
+		if err != nil {
+			return logError(status.Errorf(codes.Internal, "Cannot find laptop: %v", err))
+		}
+		if !isQualified(filter, laptop) {
+			continue
+		}
+
+		top = append(top, &pb.TopRatedLaptopsResponse{
+			Laptop:        laptop,
+			RatedCount:    entry.Count,
+			AverageScore:  entry.Mean,
+			WeightedScore: entry.WeightedAverage,
+		})
+	}
+
+	for _, res := range top {
+		err := stream.Send(res)
+		if err != nil {
+			return logError(status.Errorf(codes.Unknown, "Cannot send response: %v", err))
+		}
+	}
+	return nil
+}
@@ -94,3 +94,56 @@ func TestServerCreateLaptop(t *testing.T) {
 
 	}
 }
+
+func TestServerGetUpdateDeleteLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	server := service.NewLaptopServer(laptopStore, nil, nil)
+
+	testCases := []struct {
+		name string
+		id   string
+		code codes.Code
+	}{
+		{
+			name: "Success",
+			id:   laptop.Id,
+			code: codes.OK,
+		},
+		{
+			name: "Failure_invalid_uuid",
+			id:   "invalid-uuid",
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "Failure_not_found",
+			id:   sample.NewLaptop().Id,
+			code: codes.NotFound,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := server.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: tc.id})
+			require.Equal(t, tc.code, status.Code(err))
+
+			other := sample.NewLaptop()
+			other.Id = tc.id
+			_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: other})
+			require.Equal(t, tc.code, status.Code(err))
+
+			_, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: tc.id})
+			require.Equal(t, tc.code, status.Code(err))
+		})
+	}
+
+	_, err = server.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 
 	"github.com/jinzhu/copier"
@@ -12,11 +13,19 @@ import (
 )
 
 var ErrAlreadyExists = errors.New("Record already exists")
+var ErrNotFound = errors.New("Record not found")
 
 type LaptopStore interface {
 	Save(laptop *pb.Laptop) error
 	Find(id string) (*pb.Laptop, error)
-	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	Update(laptop *pb.Laptop) error
+	Delete(id string) error
+	// Search calls found for every laptop on the page selected by options and
+	// returns the token of the next page
+	Search(ctx context.Context, filter *pb.Filter, options *SearchOptions, found func(laptop *pb.Laptop) error) (string, error)
+	// Aggregate counts the values of the facet fields over the laptops matching
+	// the filter and the query
+	Aggregate(ctx context.Context, filter *pb.Filter, query *LaptopQuery, facets []string) (*pb.AggregateLaptopsResponse, error)
 }
 
 type InMemoryLaptopStore struct {
@@ -55,61 +64,304 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	if store.data[id] != nil {
 		return deepCopy(laptop)
 	} else {
-		return nil, fmt.Errorf("Cannot find the laptop with ID: %s", id)
+		return nil, fmt.Errorf("Cannot find the laptop with ID %s: %w", id, ErrNotFound)
 	}
 }
 
+func (store *InMemoryLaptopStore) Update(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.data[laptop.Id] == nil {
+		return ErrNotFound
+	}
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.data[other.Id] = other
+	return nil
+}
+
+func (store *InMemoryLaptopStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.data[id] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.data, id)
+	return nil
+}
+
+// Count returns the number of laptops in the store
+func (store *InMemoryLaptopStore) Count() (int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.data), nil
+}
+
 func (store *InMemoryLaptopStore) Search(
 	ctx context.Context,
 	filter *pb.Filter,
+	options *SearchOptions,
 	found func(laptop *pb.Laptop) error,
-) error {
+) (string, error) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
+	var qualified []*pb.Laptop
+
 	for _, laptop := range store.data {
 		log.Print("checking laptop with ID: ", laptop.GetId())
 
 		if ctx.Err() == context.DeadlineExceeded || ctx.Err() == context.Canceled {
 			log.Print("Context is canceled")
-			return errors.New("Context is canceled")
+			return "", errors.New("Context is canceled")
 		}
 
-		if isQualified(filter, laptop) {
-			other, err := deepCopy(laptop)
-			if err != nil {
-				return err
-			}
+		if isQualified(filter, laptop) && options.matches(laptop) {
+			qualified = append(qualified, laptop)
+		}
+	}
 
-			err = found(other)
-			if err != nil {
-				return err
-			}
+	page, nextPageToken, err := sortAndPage(qualified, options)
+	if err != nil {
+		return "", err
+	}
+
+	for _, laptop := range page {
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return "", err
+		}
+
+		err = found(other)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	return nil
+	return nextPageToken, nil
+}
+
+func (store *InMemoryLaptopStore) Aggregate(
+	ctx context.Context,
+	filter *pb.Filter,
+	query *LaptopQuery,
+	facets []string,
+) (*pb.AggregateLaptopsResponse, error) {
+	aggregator, err := newLaptopAggregator(facets)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, laptop := range store.data {
+		if ctx.Err() == context.DeadlineExceeded || ctx.Err() == context.Canceled {
+			log.Print("Context is canceled")
+			return nil, errors.New("Context is canceled")
+		}
+
+		if isQualified(filter, laptop) && query.Match(laptop) {
+			aggregator.add(laptop)
+		}
+	}
+
+	return aggregator.result(), nil
 }
 
+const poundToKg = 0.45359237
+
 func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
-	if laptop.GetPriceInr() > filter.GetMaxPriceInr() {
+	if !inRangeFloat(laptop.GetPriceInr(), filter.GetMinPriceInr(), filter.GetMaxPriceInr()) {
+		return false
+	}
+
+	if len(filter.GetBrands()) > 0 && !containsFold(filter.GetBrands(), laptop.GetBrand()) {
+		return false
+	}
+
+	if !inRangeUint(uint64(laptop.GetReleaseYear()), uint64(filter.GetMinReleaseYear()), uint64(filter.GetMaxReleaseYear())) {
+		return false
+	}
+
+	if !isCPUQualified(filter, laptop.GetCpu()) {
+		return false
+	}
+
+	if !inRangeUint(toBit(laptop.GetRam()), toBit(filter.GetMinRam()), toBit(filter.GetMaxRam())) {
+		return false
+	}
+
+	if !isGPUQualified(filter, laptop.GetGpus()) {
 		return false
 	}
 
-	if laptop.GetCpu().GetNumberOfCores() < filter.GetMinCpuCores() {
+	if !isStorageQualified(filter, laptop.GetStorages()) {
 		return false
 	}
 
-	if laptop.GetCpu().GetMinGhz() < filter.GetMinCpuGhz() {
+	if !isScreenQualified(filter, laptop.GetScreen()) {
 		return false
 	}
 
-	if toBit(laptop.GetRam()) < toBit(filter.GetMinRam()) {
+	if !isKeyboardQualified(filter, laptop.GetKeyboard()) {
 		return false
 	}
+
+	if filter.GetMinWeightKg() > 0 || filter.GetMaxWeightKg() > 0 {
+		weight, ok := weightKg(laptop)
+		if !ok || !inRangeFloat(weight, filter.GetMinWeightKg(), filter.GetMaxWeightKg()) {
+			return false
+		}
+	}
+
 	return true
 }
 
+func isCPUQualified(filter *pb.Filter, cpu *pb.CPU) bool {
+	if len(filter.GetCpuBrands()) > 0 && !containsFold(filter.GetCpuBrands(), cpu.GetBrand()) {
+		return false
+	}
+
+	if !inRangeUint(uint64(cpu.GetNumberOfCores()), uint64(filter.GetMinCpuCores()), uint64(filter.GetMaxCpuCores())) {
+		return false
+	}
+
+	return inRangeFloat(cpu.GetMinGhz(), filter.GetMinCpuGhz(), filter.GetMaxCpuGhz())
+}
+
+func isGPUQualified(filter *pb.Filter, gpus []*pb.GPU) bool {
+	if len(filter.GetGpuBrands()) == 0 && toBit(filter.GetMinGpuMemory()) == 0 {
+		return true
+	}
+
+	for _, gpu := range gpus {
+		if len(filter.GetGpuBrands()) > 0 && !containsFold(filter.GetGpuBrands(), gpu.GetBrand()) {
+			continue
+		}
+		if toBit(gpu.GetMemory()) >= toBit(filter.GetMinGpuMemory()) {
+			return true
+		}
+	}
+	return false
+}
+
+func isStorageQualified(filter *pb.Filter, storages []*pb.Storage) bool {
+	if len(filter.GetStorageDrivers()) > 0 {
+		found := false
+		for _, storage := range storages {
+			for _, driver := range filter.GetStorageDrivers() {
+				if storage.GetDriver() == driver {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	var capacity uint64
+	for _, storage := range storages {
+		capacity += toBit(storage.GetMemory())
+	}
+
+	return inRangeUint(capacity, toBit(filter.GetMinStorage()), toBit(filter.GetMaxStorage()))
+}
+
+func isScreenQualified(filter *pb.Filter, screen *pb.Screen) bool {
+	size := float64(screen.GetScreenSize())
+	if !inRangeFloat(size, float64(filter.GetMinScreenSize()), float64(filter.GetMaxScreenSize())) {
+		return false
+	}
+
+	if screen.GetResolution().GetWidth() < filter.GetMinResolutionWidth() {
+		return false
+	}
+
+	if screen.GetResolution().GetHeight() < filter.GetMinResolutionHeight() {
+		return false
+	}
+
+	if len(filter.GetPanels()) > 0 {
+		found := false
+		for _, panel := range filter.GetPanels() {
+			if screen.GetPanel() == panel {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	multitouch := filter.GetMultitouch()
+	return multitouch == nil || multitouch.GetValue() == screen.GetMultitouch()
+}
+
+func isKeyboardQualified(filter *pb.Filter, keyboard *pb.Keyboard) bool {
+	if len(filter.GetKeyboardLayouts()) > 0 {
+		found := false
+		for _, layout := range filter.GetKeyboardLayouts() {
+			if keyboard.GetLayout() == layout {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	backlit := filter.GetBacklit()
+	return backlit == nil || backlit.GetValue() == keyboard.GetBacklit()
+}
+
+// weightKg returns the weight of the laptop in kilograms, whichever unit it was given in
+func weightKg(laptop *pb.Laptop) (float64, bool) {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return weight.WeightKg, true
+	case *pb.Laptop_WeightPound:
+		return weight.WeightPound * poundToKg, true
+	default:
+		return 0, false
+	}
+}
+
+// inRangeFloat reports whether value lies between min and max, a zero bound
+// being unset rather than a limit, as documented on pb.Filter
+func inRangeFloat(value, min, max float64) bool {
+	if min > 0 && value < min {
+		return false
+	}
+	return max <= 0 || value <= max
+}
+
+// inRangeUint reports whether value lies between min and max, a zero bound
+// being unset rather than a limit, as documented on pb.Filter
+func inRangeUint(value, min, max uint64) bool {
+	if value < min {
+		return false
+	}
+	return max == 0 || value <= max
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
 func toBit(memory *pb.Memory) uint64 {
 	value := memory.GetValue()
 
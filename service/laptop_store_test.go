@@ -0,0 +1,77 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestInMemoryLaptopStoreSearchFilter(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Brand = "Apple"
+	laptop.PriceInr = 80000
+	laptop.ReleaseYear = 2020
+	laptop.Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGYBYTE}
+	laptop.Gpus = []*pb.GPU{{Brand: "NVIDIA", Memory: &pb.Memory{Value: 4, Unit: pb.Memory_GIGYBYTE}}}
+	laptop.Storages = []*pb.Storage{
+		{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGYBYTE}},
+		{Driver: pb.Storage_HDD, Memory: &pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}},
+	}
+	laptop.Screen = &pb.Screen{
+		ScreenSize: 14,
+		Resolution: &pb.Screen_Resolution{Width: 2560, Height: 1440},
+		Panel:      pb.Screen_OLED,
+	}
+	laptop.Keyboard = &pb.Keyboard{Layout: pb.Keyboard_QWERTY, Backlit: true}
+	laptop.Weight = &pb.Laptop_WeightPound{WeightPound: 4.4}
+
+	store := service.NewInMemoryLaptopStore()
+	require.NoError(t, store.Save(laptop))
+
+	gb := func(value uint64) *pb.Memory { return &pb.Memory{Value: value, Unit: pb.Memory_GIGYBYTE} }
+
+	testCases := []struct {
+		name   string
+		filter *pb.Filter
+		match  bool
+	}{
+		{"Empty", &pb.Filter{}, true},
+		{"Brand", &pb.Filter{Brands: []string{"dell", "apple"}}, true},
+		{"Brand_mismatch", &pb.Filter{Brands: []string{"Dell"}}, false},
+		{"Price_range", &pb.Filter{MinPriceInr: 70000, MaxPriceInr: 90000}, true},
+		{"Price_too_low", &pb.Filter{MinPriceInr: 85000}, false},
+		{"Release_year", &pb.Filter{MinReleaseYear: 2019, MaxReleaseYear: 2020}, true},
+		{"Release_year_mismatch", &pb.Filter{MaxReleaseYear: 2019}, false},
+		{"RAM_in_megabytes", &pb.Filter{MinRam: &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE}}, true},
+		{"RAM_too_large", &pb.Filter{MaxRam: gb(8)}, false},
+		{"GPU", &pb.Filter{GpuBrands: []string{"NVIDIA"}, MinGpuMemory: gb(4)}, true},
+		{"GPU_memory_too_small", &pb.Filter{MinGpuMemory: gb(6)}, false},
+		{"Storage_driver", &pb.Filter{StorageDrivers: []pb.Storage_Driver{pb.Storage_SSD}}, true},
+		{"Storage_total_capacity", &pb.Filter{MinStorage: gb(1500)}, true},
+		{"Storage_too_large", &pb.Filter{MaxStorage: gb(1024)}, false},
+		{"Screen", &pb.Filter{MinScreenSize: 13, MaxScreenSize: 15, MinResolutionWidth: 1920}, true},
+		{"Screen_resolution_too_small", &pb.Filter{MinResolutionHeight: 2160}, false},
+		{"Panel", &pb.Filter{Panels: []pb.Screen_Panel{pb.Screen_IPS, pb.Screen_OLED}}, true},
+		{"Panel_mismatch", &pb.Filter{Panels: []pb.Screen_Panel{pb.Screen_IPS}}, false},
+		{"Multitouch_mismatch", &pb.Filter{Multitouch: wrapperspb.Bool(true)}, false},
+		{"Keyboard", &pb.Filter{KeyboardLayouts: []pb.Keyboard_Layout{pb.Keyboard_QWERTY}, Backlit: wrapperspb.Bool(true)}, true},
+		{"Not_backlit", &pb.Filter{Backlit: wrapperspb.Bool(false)}, false},
+		{"Weight_from_pounds", &pb.Filter{MinWeightKg: 1.9, MaxWeightKg: 2.1}, true},
+		{"Weight_too_heavy", &pb.Filter{MaxWeightKg: 1.5}, false},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ids := searchIDs(t, store, tc.filter)
+			require.Equal(t, tc.match, ids[laptop.Id])
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics counts the calls of the gRPC services and their messages for
+// Prometheus. Its interceptors should be chained first, so that the calls
+// rejected by the other interceptors are counted too
+type Metrics struct {
+	requests       *prometheus.CounterVec
+	latency        *prometheus.HistogramVec
+	streamMessages *prometheus.CounterVec
+	uploadedBytes  prometheus.Counter
+}
+
+// NewMetrics creates the metrics and registers them
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	metrics := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pcbook",
+			Name:      "grpc_requests_total",
+			Help:      "Number of gRPC calls handled, by method and status code.",
+		}, []string{"service", "method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pcbook",
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Time taken to handle the gRPC calls, until the end of the stream for streaming calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "method"}),
+		streamMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pcbook",
+			Name:      "grpc_stream_messages_total",
+			Help:      "Number of messages of the streaming calls, by method and direction, received or sent.",
+		}, []string{"service", "method", "direction"}),
+		uploadedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "pcbook",
+			Name:      "upload_image_bytes_total",
+			Help:      "Number of image bytes received by UploadImage.",
+		}),
+	}
+
+	registerer.MustRegister(metrics.requests, metrics.latency, metrics.streamMessages, metrics.uploadedBytes)
+	return metrics
+}
+
+// splitMethod returns the service and the method of a full method name,
+// e.g. keshavbhattad.pcbook.LaptopService and CreateLaptop
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndex(fullMethod, "/")
+	if i < 0 {
+		return "unknown", fullMethod
+	}
+	return fullMethod[:i], fullMethod[i+1:]
+}
+
+func (metrics *Metrics) observe(service string, method string, start time.Time, err error) {
+	metrics.requests.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	metrics.latency.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+}
+
+func (metrics *Metrics) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		service, method := splitMethod(info.FullMethod)
+		start := time.Now()
+
+		res, err := handler(ctx, req)
+		metrics.observe(service, method, start, err)
+		return res, err
+	}
+}
+
+func (metrics *Metrics) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		service, method := splitMethod(info.FullMethod)
+		start := time.Now()
+
+		err := handler(srv, &metricsStream{
+			ServerStream: stream,
+			metrics:      metrics,
+			received:     metrics.streamMessages.WithLabelValues(service, method, "received"),
+			sent:         metrics.streamMessages.WithLabelValues(service, method, "sent"),
+		})
+		metrics.observe(service, method, start, err)
+		return err
+	}
+}
+
+// metricsStream counts the messages of a stream, and the image bytes of UploadImage
+type metricsStream struct {
+	grpc.ServerStream
+	metrics  *Metrics
+	received prometheus.Counter
+	sent     prometheus.Counter
+}
+
+func (stream *metricsStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	stream.received.Inc()
+	if req, ok := m.(*pb.UploadImageRequest); ok {
+		size := len(req.GetChunkData()) + len(req.GetChunk().GetData())
+		stream.metrics.uploadedBytes.Add(float64(size))
+	}
+	return nil
+}
+
+func (stream *metricsStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.sent.Inc()
+	}
+	return err
+}
+
+// laptopCounter is implemented by the laptop stores which can count their
+// laptops without reading them
+type laptopCounter interface {
+	Count() (int, error)
+}
+
+// imageCounter is implemented by the image stores which can count their
+// images without listing them, like DiskImageStore
+type imageCounter interface {
+	Count() (int, int)
+}
+
+// ratingCounter is implemented by the rating stores which keep the number of
+// rated laptops and of scores
+type ratingCounter interface {
+	Count() (int, int)
+}
+
+// storeCollector reports the size of the stores when the metrics are scraped
+type storeCollector struct {
+	laptopStore LaptopStore
+	imageStore  ImageStore
+	ratingStore RatingStore
+
+	laptops      *prometheus.Desc
+	images       *prometheus.Desc
+	variants     *prometheus.Desc
+	ratedLaptops *prometheus.Desc
+	ratings      *prometheus.Desc
+}
+
+// RegisterStoreMetrics registers gauges of the number of laptops, images and
+// ratings in the stores. They are only reported for the stores which count
+// them without reading the laptops or listing the images, as they are read
+// on every scrape. S3ImageStore does not count its images, since it would
+// have to list the bucket
+func RegisterStoreMetrics(
+	registerer prometheus.Registerer,
+	laptopStore LaptopStore,
+	imageStore ImageStore,
+	ratingStore RatingStore,
+) error {
+	collector := &storeCollector{
+		laptopStore:  laptopStore,
+		imageStore:   imageStore,
+		ratingStore:  ratingStore,
+		laptops:      prometheus.NewDesc("pcbook_laptops", "Number of laptops in the store.", nil, nil),
+		images:       prometheus.NewDesc("pcbook_images", "Number of images in the store, without their variants.", nil, nil),
+		variants:     prometheus.NewDesc("pcbook_image_variants", "Number of image variants in the store.", nil, nil),
+		ratedLaptops: prometheus.NewDesc("pcbook_rated_laptops", "Number of laptops rated at least once.", nil, nil),
+		ratings:      prometheus.NewDesc("pcbook_ratings", "Number of scores given to the laptops, one per user and laptop.", nil, nil),
+	}
+	return registerer.Register(collector)
+}
+
+func (collector *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.laptops
+	ch <- collector.images
+	ch <- collector.variants
+	ch <- collector.ratedLaptops
+	ch <- collector.ratings
+}
+
+func (collector *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	if counter, ok := collector.laptopStore.(laptopCounter); ok {
+		laptops, err := counter.Count()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(collector.laptops, err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(collector.laptops, prometheus.GaugeValue, float64(laptops))
+		}
+	}
+
+	if counter, ok := collector.imageStore.(imageCounter); ok {
+		images, variants := counter.Count()
+		ch <- prometheus.MustNewConstMetric(collector.images, prometheus.GaugeValue, float64(images))
+		ch <- prometheus.MustNewConstMetric(collector.variants, prometheus.GaugeValue, float64(variants))
+	}
+
+	if counter, ok := collector.ratingStore.(ratingCounter); ok {
+		ratedLaptops, ratings := counter.Count()
+		ch <- prometheus.MustNewConstMetric(collector.ratedLaptops, prometheus.GaugeValue, float64(ratedLaptops))
+		ch <- prometheus.MustNewConstMetric(collector.ratings, prometheus.GaugeValue, float64(ratings))
+	}
+}
@@ -0,0 +1,151 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientMetrics(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+	ratingStore := service.NewInMemoryRatingStore()
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, service.RegisterStoreMetrics(registry, laptopStore, imageStore, ratingStore))
+	metrics := service.NewMetrics(registry)
+
+	serverTLS, _ := testTLSConfigs(t)
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(serverTLS)),
+		grpc.ChainUnaryInterceptor(metrics.Unary()),
+		grpc.ChainStreamInterceptor(metrics.Stream()),
+	)
+	t.Cleanup(grpcServer.Stop)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, ratingStore))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	laptopClient := newTestLaptopClient(t, listener.Addr().String())
+
+	laptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+	_, err = laptopClient.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: uuid.New().String()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	search, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	for {
+		_, err := search.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	rate, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	for _, userID := range []string{"user1", "user2", "user3"} {
+		require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: userID, Score: 8}))
+		_, err = rate.Recv()
+		require.NoError(t, err)
+	}
+	require.NoError(t, rate.CloseSend())
+	_, err = rate.Recv()
+	require.Equal(t, io.EOF, err)
+
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+	_, err = uploadTestImage(laptopClient, laptop.GetId(), ".jpeg", imageData)
+	require.NoError(t, err)
+
+	expected := `
+# HELP pcbook_grpc_requests_total Number of gRPC calls handled, by method and status code.
+# TYPE pcbook_grpc_requests_total counter
+pcbook_grpc_requests_total{code="NotFound",method="GetLaptop",service="keshavbhattad.pcbook.LaptopService"} 1
+pcbook_grpc_requests_total{code="OK",method="CreateLaptop",service="keshavbhattad.pcbook.LaptopService"} 2
+pcbook_grpc_requests_total{code="OK",method="RateLaptop",service="keshavbhattad.pcbook.LaptopService"} 1
+pcbook_grpc_requests_total{code="OK",method="SearchLaptop",service="keshavbhattad.pcbook.LaptopService"} 1
+pcbook_grpc_requests_total{code="OK",method="UploadImage",service="keshavbhattad.pcbook.LaptopService"} 1
+# HELP pcbook_grpc_stream_messages_total Number of messages of the streaming calls, by method and direction, received or sent.
+# TYPE pcbook_grpc_stream_messages_total counter
+pcbook_grpc_stream_messages_total{direction="received",method="RateLaptop",service="keshavbhattad.pcbook.LaptopService"} 3
+pcbook_grpc_stream_messages_total{direction="received",method="SearchLaptop",service="keshavbhattad.pcbook.LaptopService"} 1
+pcbook_grpc_stream_messages_total{direction="received",method="UploadImage",service="keshavbhattad.pcbook.LaptopService"} 2
+pcbook_grpc_stream_messages_total{direction="sent",method="RateLaptop",service="keshavbhattad.pcbook.LaptopService"} 3
+pcbook_grpc_stream_messages_total{direction="sent",method="SearchLaptop",service="keshavbhattad.pcbook.LaptopService"} 2
+pcbook_grpc_stream_messages_total{direction="sent",method="UploadImage",service="keshavbhattad.pcbook.LaptopService"} 1
+# HELP pcbook_laptops Number of laptops in the store.
+# TYPE pcbook_laptops gauge
+pcbook_laptops 2
+# HELP pcbook_images Number of images in the store, without their variants.
+# TYPE pcbook_images gauge
+pcbook_images 1
+# HELP pcbook_rated_laptops Number of laptops rated at least once.
+# TYPE pcbook_rated_laptops gauge
+pcbook_rated_laptops 1
+# HELP pcbook_ratings Number of scores given to the laptops, one per user and laptop.
+# TYPE pcbook_ratings gauge
+pcbook_ratings 3
+`
+	err = testutil.GatherAndCompare(
+		registry,
+		strings.NewReader(expected),
+		"pcbook_grpc_requests_total",
+		"pcbook_grpc_stream_messages_total",
+		"pcbook_laptops",
+		"pcbook_images",
+		"pcbook_rated_laptops",
+		"pcbook_ratings",
+	)
+	require.NoError(t, err)
+
+	uploaded := float64(len(imageData))
+	require.Equal(t, uploaded, gatheredValue(t, registry, "pcbook_upload_image_bytes_total"))
+
+	count, err := testutil.GatherAndCount(registry, "pcbook_grpc_request_duration_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 5, count)
+}
+
+// gatheredValue returns the value of a metric without labels
+func gatheredValue(t *testing.T, registry *prometheus.Registry, name string) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() == name {
+			require.Len(t, family.GetMetric(), 1)
+			metric := family.GetMetric()[0]
+			if metric.GetCounter() != nil {
+				return metric.GetCounter().GetValue()
+			}
+			return metric.GetGauge().GetValue()
+		}
+	}
+	require.Fail(t, "Cannot find metric", name)
+	return 0
+}
@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/client"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func startTestRateLimitedServer(t *testing.T, limits string, maxStreams int, laptopStore service.LaptopStore) string {
+	rateLimits, err := service.ParseRateLimits(limits)
+	require.NoError(t, err)
+	rateLimiter := service.NewRateLimiter(rateLimits, maxStreams)
+
+	serverTLS, _ := testTLSConfigs(t)
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(serverTLS)),
+		grpc.ChainUnaryInterceptor(rateLimiter.Unary()),
+		grpc.ChainStreamInterceptor(rateLimiter.Stream()),
+	)
+	t.Cleanup(grpcServer.Stop)
+
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	return listener.Addr().String()
+}
+
+// newTestRetryLaptopClient returns a client retrying the calls rejected by the rate limiter
+func newTestRetryLaptopClient(t *testing.T, serverAddress string) pb.LaptopServiceClient {
+	interceptor := client.NewRetryInterceptor(3)
+
+	conn := dialTestServer(
+		t,
+		serverAddress,
+		grpc.WithChainUnaryInterceptor(interceptor.Unary()),
+		grpc.WithChainStreamInterceptor(interceptor.Stream()),
+	)
+	return pb.NewLaptopServiceClient(conn)
+}
+
+func TestClientRateLimit(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestRateLimitedServer(t, "CreateLaptop=2:2,SearchLaptop=2:1", 0, service.NewInMemoryLaptopStore())
+	laptopClient := pb.NewLaptopServiceClient(dialTestServer(t, serverAddress))
+	retryClient := newTestRetryLaptopClient(t, serverAddress)
+
+	createLaptop := func(laptopClient pb.LaptopServiceClient, trailer *metadata.MD) error {
+		_, err := laptopClient.CreateLaptop(
+			context.Background(),
+			&pb.CreateLaptopRequest{Laptop: sample.NewLaptop()},
+			grpc.Trailer(trailer),
+		)
+		return err
+	}
+
+	var trailer metadata.MD
+	require.NoError(t, createLaptop(laptopClient, &trailer))
+	require.NoError(t, createLaptop(laptopClient, &trailer))
+
+	err := createLaptop(laptopClient, &trailer)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	delay, ok := client.RetryAfter(trailer)
+	require.True(t, ok)
+	require.True(t, delay > 0 && delay <= 500*time.Millisecond, delay)
+
+	// the clients are limited by address, so the retrying client shares the
+	// budget, and its retry takes the token the other client waits for
+	require.NoError(t, createLaptop(retryClient, &trailer))
+	err = createLaptop(laptopClient, &trailer)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	delay, ok = client.RetryAfter(trailer)
+	require.True(t, ok)
+	require.True(t, delay > 0 && delay <= 500*time.Millisecond, delay)
+
+	search := func(laptopClient pb.LaptopServiceClient) error {
+		stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+		require.NoError(t, err)
+		for {
+			_, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	require.NoError(t, search(laptopClient))
+	require.Equal(t, codes.ResourceExhausted, status.Code(search(laptopClient)))
+	require.NoError(t, search(retryClient))
+}
+
+func TestClientRateLimitStreams(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	serverAddress := startTestRateLimitedServer(t, "RateLaptop=10:1,SearchLaptop=1:1", 1, laptopStore)
+	laptopClient := pb.NewLaptopServiceClient(dialTestServer(t, serverAddress))
+
+	rate, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: "user0", Score: 5}))
+	_, err = rate.Recv()
+	require.NoError(t, err)
+
+	search, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the messages of the stream wait for the tokens instead of failing
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), UserId: "user0", Score: 5}))
+		_, err = rate.Recv()
+		require.NoError(t, err)
+	}
+	require.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	require.NoError(t, rate.CloseSend())
+	_, err = rate.Recv()
+	require.Equal(t, io.EOF, err)
+
+	// the refused stream took no token, so the only one of SearchLaptop is left
+	search, err = laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	_, err = search.Recv()
+	require.NoError(t, err)
+}
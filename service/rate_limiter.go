@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterHeader is the trailer telling a client rejected by the rate
+// limiter how many milliseconds to wait before calling again
+const retryAfterHeader = "retry-after-ms"
+
+// streamRetryAfter is how long a client with too many open streams is told to wait
+const streamRetryAfter = time.Second
+
+// rateLimiterSweepInterval is how often the idle buckets are removed
+const rateLimiterSweepInterval = time.Minute
+
+// RateLimit is a token bucket refilled with Rate tokens per second, holding
+// at most Burst tokens. Each call takes a token
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimits parses a comma separated list of limits written as
+// method=RATE:BURST, e.g. "SearchLaptop=2:10,RateLaptop=20:50". The method is
+// the name of a method of any service, or * for the methods not listed
+func ParseRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid rate limit %q: expected method=RATE:BURST", item)
+		}
+
+		method := strings.TrimSpace(parts[0])
+		if method == "" || strings.Contains(method, "/") {
+			return nil, fmt.Errorf("Invalid rate limit method %q", method)
+		}
+		if _, ok := limits[method]; ok {
+			return nil, fmt.Errorf("Duplicate rate limit for %q", method)
+		}
+
+		budget := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+		if len(budget) != 2 {
+			return nil, fmt.Errorf("Invalid rate limit budget %q", parts[1])
+		}
+		rate, err := strconv.ParseFloat(budget[0], 64)
+		if err != nil || !(rate > 0) || math.IsInf(rate, 1) {
+			return nil, fmt.Errorf("Invalid rate limit rate %q", budget[0])
+		}
+		burst, err := strconv.Atoi(budget[1])
+		if err != nil || burst <= 0 {
+			return nil, fmt.Errorf("Invalid rate limit burst %q", budget[1])
+		}
+
+		limits[method] = RateLimit{Rate: rate, Burst: burst}
+	}
+
+	return limits, nil
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func (bucket *tokenBucket) refill(limit RateLimit, now time.Time) {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+		bucket.updated = now
+	}
+}
+
+// wait returns how long it takes until the bucket has the tokens
+func (bucket *tokenBucket) wait(limit RateLimit, tokens float64) time.Duration {
+	return time.Duration((tokens - bucket.tokens) / limit.Rate * float64(time.Second))
+}
+
+type bucketKey struct {
+	client string
+	method string
+}
+
+// RateLimiter limits the calls of each client with a token bucket per
+// method, and the number of streams each client has open at once. The
+// clients are told when to retry with the retry-after-ms trailer. The client
+// is the authenticated user or API key of the call, or its peer address, so
+// the interceptors should be chained after the AuthInterceptor
+type RateLimiter struct {
+	limits     map[string]RateLimit
+	maxStreams int
+
+	mutex     sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	streams   map[string]int
+	lastSweep time.Time
+}
+
+// NewRateLimiter takes the limits keyed by method name, as returned by
+// ParseRateLimits, and the largest number of open streams per client, zero
+// meaning no limit. Methods without limit are not limited
+func NewRateLimiter(limits map[string]RateLimit, maxStreams int) *RateLimiter {
+	return &RateLimiter{
+		limits:     limits,
+		maxStreams: maxStreams,
+		buckets:    make(map[bucketKey]*tokenBucket),
+		streams:    make(map[string]int),
+		lastSweep:  time.Now(),
+	}
+}
+
+// clientIdentity returns who the call is limited as
+func clientIdentity(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return "user:" + claims.Username
+	}
+	if apiKey, ok := APIKeyFromContext(ctx); ok {
+		return "apikey:" + apiKey.ID
+	}
+	if host, ok := peerHost(ctx); ok {
+		return "peer:" + host
+	}
+	return "unknown"
+}
+
+// peerHost returns the address of the client of the call, without its port
+func peerHost(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	address := p.Addr.String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return address, true
+}
+
+func (limiter *RateLimiter) limit(fullMethod string) (RateLimit, bool) {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if limit, ok := limiter.limits[method]; ok {
+		return limit, true
+	}
+	limit, ok := limiter.limits["*"]
+	return limit, ok
+}
+
+// bucket returns the bucket of the key, with the lock held
+func (limiter *RateLimiter) bucket(key bucketKey, limit RateLimit, now time.Time) *tokenBucket {
+	if now.Sub(limiter.lastSweep) > rateLimiterSweepInterval {
+		limiter.sweep(now)
+	}
+
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updated: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.refill(limit, now)
+	return bucket
+}
+
+// sweep removes the buckets which are full again, as they would be recreated
+// the same, with the lock held
+func (limiter *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		limit, ok := limiter.limit(key.method)
+		if !ok {
+			delete(limiter.buckets, key)
+			continue
+		}
+		bucket.refill(limit, now)
+		if bucket.tokens >= float64(limit.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastSweep = now
+}
+
+// allow takes a token of the client for the method, or returns how long to
+// wait until there is one
+func (limiter *RateLimiter) allow(key bucketKey, limit RateLimit) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	bucket := limiter.bucket(key, limit, time.Now())
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return bucket.wait(limit, 1)
+}
+
+// reserve takes a token of the client for the method, even if there is none
+// yet, and returns how long to wait until it is available
+func (limiter *RateLimiter) reserve(key bucketKey, limit RateLimit) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	bucket := limiter.bucket(key, limit, time.Now())
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return bucket.wait(limit, 0)
+}
+
+func (limiter *RateLimiter) openStream(client string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.maxStreams > 0 && limiter.streams[client] >= limiter.maxStreams {
+		return false
+	}
+	limiter.streams[client]++
+	return true
+}
+
+func (limiter *RateLimiter) closeStream(client string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.streams[client]--
+	if limiter.streams[client] <= 0 {
+		delete(limiter.streams, client)
+	}
+}
+
+// retryAfter returns the trailer telling the client to retry after the delay
+func retryAfter(delay time.Duration) metadata.MD {
+	milliseconds := int64(math.Ceil(float64(delay) / float64(time.Millisecond)))
+	return metadata.Pairs(retryAfterHeader, strconv.FormatInt(milliseconds, 10))
+}
+
+func (limiter *RateLimiter) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		limit, ok := limiter.limit(info.FullMethod)
+		if ok {
+			client := clientIdentity(ctx)
+			delay := limiter.allow(bucketKey{client: client, method: info.FullMethod}, limit)
+			if delay > 0 {
+				grpc.SetTrailer(ctx, retryAfter(delay))
+				log.Printf("Client %s exceeded the rate limit of %s", client, info.FullMethod)
+				return nil, status.Errorf(codes.ResourceExhausted, "Rate limit exceeded, retry after %v", delay.Round(time.Millisecond))
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (limiter *RateLimiter) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		client := clientIdentity(stream.Context())
+		key := bucketKey{client: client, method: info.FullMethod}
+
+		// the streams are counted first, so that a refused stream takes no token
+		if !limiter.openStream(client) {
+			stream.SetTrailer(retryAfter(streamRetryAfter))
+			log.Printf("Client %s has too many open streams", client)
+			return status.Errorf(codes.ResourceExhausted, "Cannot open more than %d streams at once", limiter.maxStreams)
+		}
+		defer limiter.closeStream(client)
+
+		limit, ok := limiter.limit(info.FullMethod)
+		if ok {
+			delay := limiter.allow(key, limit)
+			if delay > 0 {
+				stream.SetTrailer(retryAfter(delay))
+				log.Printf("Client %s exceeded the rate limit of %s", client, info.FullMethod)
+				return status.Errorf(codes.ResourceExhausted, "Rate limit exceeded, retry after %v", delay.Round(time.Millisecond))
+			}
+		}
+
+		if ok && info.IsClientStream {
+			stream = &throttledStream{ServerStream: stream, limiter: limiter, key: key, limit: limit}
+		}
+		return handler(srv, stream)
+	}
+}
+
+// throttledStream takes a token for each message received from the client.
+// Instead of failing the stream, it waits for the token, which slows down the
+// client by flow control
+type throttledStream struct {
+	grpc.ServerStream
+	limiter *RateLimiter
+	key     bucketKey
+	limit   RateLimit
+}
+
+func (stream *throttledStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	delay := stream.limiter.reserve(stream.key, stream.limit)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-stream.Context().Done():
+		return contextError(stream.Context())
+	}
+}
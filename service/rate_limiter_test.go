@@ -0,0 +1,38 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	t.Parallel()
+
+	limits, err := service.ParseRateLimits(" SearchLaptop=2:10, *=0.5:1,")
+	require.NoError(t, err)
+	require.Equal(t, map[string]service.RateLimit{
+		"SearchLaptop": {Rate: 2, Burst: 10},
+		"*":            {Rate: 0.5, Burst: 1},
+	}, limits)
+
+	limits, err = service.ParseRateLimits("")
+	require.NoError(t, err)
+	require.Empty(t, limits)
+
+	for _, value := range []string{
+		"SearchLaptop",
+		"=1:2",
+		"/keshavbhattad.pcbook.LaptopService/SearchLaptop=1:2",
+		"SearchLaptop=1",
+		"SearchLaptop=0:2",
+		"SearchLaptop=-1:2",
+		"SearchLaptop=fast:2",
+		"SearchLaptop=1:0",
+		"SearchLaptop=1:2,SearchLaptop=3:4",
+	} {
+		_, err := service.ParseRateLimits(value)
+		require.Error(t, err, value)
+	}
+}
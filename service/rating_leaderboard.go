@@ -0,0 +1,113 @@
+package service
+
+import "sort"
+
+// RatingPrior is the score every laptop is assumed to have been given Count
+// times before its first rating. It pulls the weighted average of a laptop
+// with few ratings towards Mean, so that a single top score does not put it
+// ahead of laptops rated well by many users
+type RatingPrior struct {
+	Mean  float64
+	Count float64
+}
+
+// DefaultRatingPrior is the middle of the default score range, given 5 times
+var DefaultRatingPrior = RatingPrior{
+	Mean:  (DefaultMinScore + DefaultMaxScore) / 2.0,
+	Count: 5,
+}
+
+// WeightedAverage returns the Bayesian average of the rating
+func (prior RatingPrior) WeightedAverage(rating *Rating) float64 {
+	return (prior.Mean*prior.Count + rating.Sum) / (prior.Count + float64(rating.Count))
+}
+
+// RankedRating is the rating of a laptop in the leaderboard
+type RankedRating struct {
+	LaptopID        string
+	Count           uint32
+	Mean            float64
+	WeightedAverage float64
+}
+
+// leaderboard keeps the rated laptops sorted by weighted average, and is
+// updated with each new score instead of being sorted on every query
+type leaderboard struct {
+	prior   RatingPrior
+	entries []*RankedRating
+	// index maps a laptop ID to its entry
+	index map[string]*RankedRating
+}
+
+func newLeaderboard(prior RatingPrior) *leaderboard {
+	return &leaderboard{
+		prior: prior,
+		index: make(map[string]*RankedRating),
+	}
+}
+
+// ranksBefore reports whether entry1 is better rated than entry2. The ties
+// are broken by the number of ratings, then by laptop ID so that the order
+// is stable
+func ranksBefore(entry1 *RankedRating, entry2 *RankedRating) bool {
+	if entry1.WeightedAverage != entry2.WeightedAverage {
+		return entry1.WeightedAverage > entry2.WeightedAverage
+	}
+	if entry1.Count != entry2.Count {
+		return entry1.Count > entry2.Count
+	}
+	return entry1.LaptopID < entry2.LaptopID
+}
+
+// position returns where the entry is, or would be inserted, in the entries
+func (board *leaderboard) position(entry *RankedRating) int {
+	return sort.Search(len(board.entries), func(i int) bool {
+		return !ranksBefore(board.entries[i], entry)
+	})
+}
+
+// update moves the laptop to its place for the new rating
+func (board *leaderboard) update(laptopID string, rating *Rating) {
+	if entry := board.index[laptopID]; entry != nil {
+		i := board.position(entry)
+		board.entries = append(board.entries[:i], board.entries[i+1:]...)
+	}
+
+	entry := &RankedRating{
+		LaptopID:        laptopID,
+		Count:           rating.Count,
+		Mean:            rating.Mean(),
+		WeightedAverage: board.prior.WeightedAverage(rating),
+	}
+	board.index[laptopID] = entry
+
+	i := board.position(entry)
+	board.entries = append(board.entries, nil)
+	copy(board.entries[i+1:], board.entries[i:])
+	board.entries[i] = entry
+}
+
+// setPrior recomputes the weighted averages with another prior
+func (board *leaderboard) setPrior(prior RatingPrior, ratings map[string]*Rating) {
+	board.prior = prior
+	for _, entry := range board.entries {
+		entry.WeightedAverage = prior.WeightedAverage(ratings[entry.LaptopID])
+	}
+	sort.Slice(board.entries, func(i, j int) bool {
+		return ranksBefore(board.entries[i], board.entries[j])
+	})
+}
+
+// each calls found with the laptops rated at least minCount times, best
+// first, until found returns false
+func (board *leaderboard) each(minCount uint32, found func(entry *RankedRating) bool) {
+	for _, entry := range board.entries {
+		if entry.Count < minCount {
+			continue
+		}
+		other := *entry
+		if !found(&other) {
+			return
+		}
+	}
+}
@@ -1,42 +1,292 @@
 package service
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
 
 type RatingStore interface {
-	Add(laptopID string, score float64) (*Rating, error)
+	// Add records the score given to the laptop by the user. A user has one
+	// score per laptop, a new score replaces the previous one
+	Add(laptopID string, userID string, score float64) (*Rating, error)
+	Find(laptopID string) (*Rating, error)
+	// ListRatings calls found with each score given from the time from,
+	// included, to the time to, excluded, in the order they were given. A
+	// zero time leaves its end of the range open. A store may only keep the
+	// last scores
+	ListRatings(ctx context.Context, from time.Time, to time.Time, found func(event *RatingEvent) error) error
+	// TopRated calls found with the laptops rated at least minCount times,
+	// highest weighted average first, until found returns false
+	TopRated(minCount uint32, found func(entry *RankedRating) bool)
+}
+
+// RatingEvent is a score given to a laptop by a user
+type RatingEvent struct {
+	LaptopID string    `json:"laptop_id"`
+	UserID   string    `json:"user_id"`
+	Score    float64   `json:"score"`
+	Time     time.Time `json:"time"`
+}
+
+// between reports whether the event happened in the range of ListRatings
+func (event *RatingEvent) between(from time.Time, to time.Time) bool {
+	return (from.IsZero() || !event.Time.Before(from)) && (to.IsZero() || event.Time.Before(to))
 }
 
 type Rating struct {
 	Count uint32
 	Sum   float64
+	// SumSquares is the sum of the squared scores, for the standard deviation
+	SumSquares float64
+	// Histogram maps each score to the number of users who gave it
+	Histogram map[float64]uint32
+}
+
+// ScoreBucket is the number of users who gave a score
+type ScoreBucket struct {
+	Score float64
+	Count uint32
+}
+
+func (rating *Rating) add(score float64) {
+	rating.Count++
+	rating.Sum += score
+	rating.SumSquares += score * score
+	rating.Histogram[score]++
+}
+
+func (rating *Rating) remove(score float64) {
+	rating.Count--
+	rating.Sum -= score
+	rating.SumSquares -= score * score
+	rating.Histogram[score]--
+	if rating.Histogram[score] == 0 {
+		delete(rating.Histogram, score)
+	}
+}
+
+func (rating *Rating) clone() *Rating {
+	other := &Rating{
+		Count:      rating.Count,
+		Sum:        rating.Sum,
+		SumSquares: rating.SumSquares,
+		Histogram:  make(map[float64]uint32, len(rating.Histogram)),
+	}
+	for score, count := range rating.Histogram {
+		other.Histogram[score] = count
+	}
+	return other
+}
+
+// Mean returns the average score, or 0 if the laptop has no score
+func (rating *Rating) Mean() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+	return rating.Sum / float64(rating.Count)
 }
 
+// StdDev returns the population standard deviation of the scores
+func (rating *Rating) StdDev() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+	mean := rating.Mean()
+	variance := rating.SumSquares/float64(rating.Count) - mean*mean
+	if variance < 0 {
+		// rounding errors when all the scores are the same
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// Buckets returns the histogram of the scores, lowest score first
+func (rating *Rating) Buckets() []ScoreBucket {
+	buckets := make([]ScoreBucket, 0, len(rating.Histogram))
+	for score, count := range rating.Histogram {
+		buckets = append(buckets, ScoreBucket{Score: score, Count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Score < buckets[j].Score
+	})
+	return buckets
+}
+
+// Median returns the middle score, or the average of the two middle scores
+// when the count is even
+func (rating *Rating) Median() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	// 0-based positions of the middle scores, the same one when the count is odd
+	low := (rating.Count - 1) / 2
+	high := rating.Count / 2
+
+	var lowScore float64
+	var seen uint32
+	for _, bucket := range rating.Buckets() {
+		if seen <= low && low < seen+bucket.Count {
+			lowScore = bucket.Score
+		}
+		if high < seen+bucket.Count {
+			return (lowScore + bucket.Score) / 2
+		}
+		seen += bucket.Count
+	}
+	return lowScore
+}
+
+// DefaultRatingEventLimit is the number of the last ratings the in-memory
+// store keeps for ListRatings, unless it is changed with SetEventLimit
+const DefaultRatingEventLimit = 10000
+
+// InMemoryRatingStore keeps the aggregates of the ratings, and only the last
+// ratings for ListRatings. FileRatingStore keeps all of them
 type InMemoryRatingStore struct {
 	mutex  sync.RWMutex
 	rating map[string]*Rating
+	// scores maps a laptop ID to the score of each user
+	scores map[string]map[string]float64
+	// events holds the last ratings, up to twice the limit before the
+	// oldest ones are dropped
+	events      []*RatingEvent
+	eventLimit  int
+	leaderboard *leaderboard
+	// ratings counts the scores in scores, for the metrics
+	ratings int
 }
 
 func NewInMemoryRatingStore() *InMemoryRatingStore {
 	return &InMemoryRatingStore{
-		rating: make(map[string]*Rating),
+		rating:      make(map[string]*Rating),
+		scores:      make(map[string]map[string]float64),
+		eventLimit:  DefaultRatingEventLimit,
+		leaderboard: newLeaderboard(DefaultRatingPrior),
 	}
 }
 
-func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
+// SetEventLimit changes the number of the last ratings kept for ListRatings
+func (store *InMemoryRatingStore) SetEventLimit(limit int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.eventLimit = limit
+}
+
+// SetPrior changes the prior of the weighted averages of TopRated, which is
+// DefaultRatingPrior by default
+func (store *InMemoryRatingStore) SetPrior(prior RatingPrior) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.leaderboard.setPrior(prior, store.rating)
+}
+
+func (store *InMemoryRatingStore) Add(laptopID string, userID string, score float64) (*Rating, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
+	event := &RatingEvent{
+		LaptopID: laptopID,
+		UserID:   userID,
+		Score:    score,
+		Time:     time.Now().UTC(),
+	}
+	if len(store.events) >= 2*store.eventLimit {
+		// a new slice, since ListRatings may be reading the current one
+		store.events = append([]*RatingEvent(nil), store.events[len(store.events)-store.eventLimit:]...)
+	}
+	store.events = append(store.events, event)
+
+	return store.apply(event), nil
+}
+
+// apply updates the aggregates with the event and returns a copy of the
+// rating of the laptop. The caller holds the lock
+func (store *InMemoryRatingStore) apply(event *RatingEvent) *Rating {
+	scores := store.scores[event.LaptopID]
+	if scores == nil {
+		scores = make(map[string]float64)
+		store.scores[event.LaptopID] = scores
+	}
+
+	rating := store.rating[event.LaptopID]
+	if rating == nil {
+		rating = &Rating{Histogram: make(map[float64]uint32)}
+		store.rating[event.LaptopID] = rating
+	}
+
+	if previous, ok := scores[event.UserID]; ok {
+		rating.remove(previous)
+	} else {
+		store.ratings++
+	}
+	rating.add(event.Score)
+	scores[event.UserID] = event.Score
+	store.leaderboard.update(event.LaptopID, rating)
+
+	return rating.clone()
+}
+
+func (store *InMemoryRatingStore) Find(laptopID string) (*Rating, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
 	rating := store.rating[laptopID]
 	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
+		return nil, fmt.Errorf("Cannot find the rating of laptop %s: %w", laptopID, ErrNotFound)
+	}
+
+	return rating.clone(), nil
+}
+
+func (store *InMemoryRatingStore) ListRatings(
+	ctx context.Context,
+	from time.Time,
+	to time.Time,
+	found func(event *RatingEvent) error,
+) error {
+	store.mutex.RLock()
+	events := store.events
+	if len(events) > store.eventLimit {
+		events = events[len(events)-store.eventLimit:]
+	}
+	store.mutex.RUnlock()
+
+	// events are only appended or moved to a new slice, so the slice taken
+	// under the lock does not change
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !event.between(from, to) {
+			continue
+		}
+
+		other := *event
+		if err := found(&other); err != nil {
+			return err
 		}
-	} else {
-		rating.Count += 1
-		rating.Sum += score
 	}
+	return nil
+}
+
+// Count returns the number of rated laptops and of scores in the store
+func (store *InMemoryRatingStore) Count() (int, int) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.rating), store.ratings
+}
+
+func (store *InMemoryRatingStore) TopRated(minCount uint32, found func(entry *RankedRating) bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
 
-	store.rating[laptopID] = rating
-	return rating, nil
+	store.leaderboard.each(minCount, found)
 }
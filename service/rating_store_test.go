@@ -0,0 +1,130 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestInMemoryRatingStoreStats(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingStore()
+
+	_, err := store.Find("laptop-1")
+	require.ErrorIs(t, err, service.ErrNotFound)
+
+	rating, err := store.Add("laptop-1", "user-1", 3)
+	require.NoError(t, err)
+	require.Equal(t, 3.0, rating.Median())
+	require.Equal(t, 0.0, rating.StdDev())
+
+	_, err = store.Add("laptop-1", "user-2", 8)
+	require.NoError(t, err)
+	rating, err = store.Add("laptop-1", "user-3", 10)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.Count)
+	require.Equal(t, 8.0, rating.Median())
+
+	// user-3 changes their score, so 10 is not in the histogram anymore
+	rating, err = store.Add("laptop-1", "user-3", 1)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.Count)
+	require.Equal(t, 3.0, rating.Median())
+	require.Equal(t, 4.0, rating.Mean())
+	require.Equal(t, []service.ScoreBucket{{Score: 1, Count: 1}, {Score: 3, Count: 1}, {Score: 8, Count: 1}}, rating.Buckets())
+
+	_, err = store.Add("laptop-1", "user-4", 5)
+	require.NoError(t, err)
+
+	rating, err = store.Find("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), rating.Count)
+	require.Equal(t, 4.0, rating.Median())
+	require.InDelta(t, 2.5860, rating.StdDev(), 1e-4)
+
+	// the returned rating is a copy
+	rating.Histogram[5] = 10
+	rating, err = store.Find("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), rating.Histogram[5])
+}
+
+func TestInMemoryRatingStoreEventLimit(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingStore()
+	store.SetEventLimit(3)
+
+	for i := 0; i < 10; i++ {
+		_, err := store.Add("laptop-1", fmt.Sprintf("user-%d", i), 5)
+		require.NoError(t, err)
+	}
+
+	var userIDs []string
+	err := store.ListRatings(context.Background(), time.Time{}, time.Time{}, func(event *service.RatingEvent) error {
+		userIDs = append(userIDs, event.UserID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-7", "user-8", "user-9"}, userIDs)
+
+	// the aggregates have all the ratings
+	rating, err := store.Find("laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(10), rating.Count)
+}
+
+func topRated(store service.RatingStore, minCount uint32) []string {
+	var laptopIDs []string
+	store.TopRated(minCount, func(entry *service.RankedRating) bool {
+		laptopIDs = append(laptopIDs, entry.LaptopID)
+		return true
+	})
+	return laptopIDs
+}
+
+func TestInMemoryRatingStoreTopRated(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRatingStore()
+	store.SetPrior(service.RatingPrior{Mean: 5, Count: 2})
+
+	// a single top score ranks below many good scores
+	_, err := store.Add("single", "user-1", 10)
+	require.NoError(t, err)
+	for i, score := range []float64{9, 9, 8, 9} {
+		_, err := store.Add("many", fmt.Sprintf("user-%d", i), score)
+		require.NoError(t, err)
+	}
+	_, err = store.Add("low", "user-1", 2)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"many", "single", "low"}, topRated(store, 0))
+	require.Equal(t, []string{"many"}, topRated(store, 2))
+
+	var entry *service.RankedRating
+	store.TopRated(0, func(e *service.RankedRating) bool {
+		entry = e
+		return false
+	})
+	require.Equal(t, "many", entry.LaptopID)
+	require.Equal(t, uint32(4), entry.Count)
+	require.Equal(t, 8.75, entry.Mean)
+	require.Equal(t, (5*2+35)/6.0, entry.WeightedAverage)
+
+	// new scores move the laptops
+	_, err = store.Add("low", "user-1", 10)
+	require.NoError(t, err)
+	_, err = store.Add("single", "user-1", 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"many", "low", "single"}, topRated(store, 0))
+
+	// without prior, the weighted average is the average
+	store.SetPrior(service.RatingPrior{})
+	require.Equal(t, []string{"low", "many", "single"}, topRated(store, 0))
+}
@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/sample"
+	"gitlab.com/keshavbhattad/pcbook/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientSubmitReview(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	reviewClient := newTestReviewClient(t, laptopStore, ratingStore)
+
+	req := &pb.SubmitReviewRequest{
+		LaptopId: laptop.GetId(),
+		Title:    "Too heavy",
+		Body:     "The battery is fine, but it is too heavy to carry around.",
+		Score:    3,
+		Author:   "user-1",
+	}
+	res, err := reviewClient.SubmitReview(context.Background(), req)
+	require.NoError(t, err)
+	require.NotEmpty(t, res.GetReview().GetId())
+	require.Equal(t, "user-1", res.GetReview().GetAuthor())
+	require.Equal(t, req.GetTitle(), res.GetReview().GetTitle())
+	require.NotNil(t, res.GetReview().GetSubmittedAt())
+	require.Equal(t, uint32(1), res.GetRatedCount())
+	require.Equal(t, 3.0, res.GetAverageScore())
+
+	// the score of the review is the rating of its author
+	rating, err := ratingStore.Add(laptop.GetId(), "user-2", 7)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+
+	req.Score = 5
+	res2, err := reviewClient.SubmitReview(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, res.GetReview().GetId(), res2.GetReview().GetId())
+	require.Equal(t, uint32(2), res2.GetRatedCount())
+	require.Equal(t, 6.0, res2.GetAverageScore())
+
+	testCases := []struct {
+		name   string
+		modify func(req *pb.SubmitReviewRequest)
+		code   codes.Code
+	}{
+		{"no_author", func(req *pb.SubmitReviewRequest) { req.Author = " " }, codes.InvalidArgument},
+		{"no_title", func(req *pb.SubmitReviewRequest) { req.Title = "" }, codes.InvalidArgument},
+		{"long_body", func(req *pb.SubmitReviewRequest) { req.Body = strings.Repeat("a", 10001) }, codes.InvalidArgument},
+		{"bad_score", func(req *pb.SubmitReviewRequest) { req.Score = 11 }, codes.InvalidArgument},
+		{"unknown_laptop", func(req *pb.SubmitReviewRequest) { req.LaptopId = sample.NewLaptop().GetId() }, codes.NotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			req := &pb.SubmitReviewRequest{LaptopId: laptop.GetId(), Title: "Title", Body: "Body", Score: 5, Author: "user-3"}
+			tc.modify(req)
+
+			_, err := reviewClient.SubmitReview(context.Background(), req)
+			require.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
+
+func TestClientListReviews(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	reviewClient := newTestReviewClient(t, laptopStore, service.NewInMemoryRatingStore())
+
+	authors := []string{"user-1", "user-2", "user-3"}
+	reviewIDs := make([]string, len(authors))
+	for i, author := range authors {
+		req := &pb.SubmitReviewRequest{LaptopId: laptop.GetId(), Title: "Title", Body: "Body", Score: 8, Author: author}
+		res, err := reviewClient.SubmitReview(context.Background(), req)
+		require.NoError(t, err)
+		reviewIDs[i] = res.GetReview().GetId()
+	}
+
+	vote, err := reviewClient.VoteReview(context.Background(), &pb.VoteReviewRequest{ReviewId: reviewIDs[0], UserId: "user-2", Helpful: true})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), vote.GetHelpfulCount())
+
+	_, err = reviewClient.VoteReview(context.Background(), &pb.VoteReviewRequest{ReviewId: reviewIDs[0], UserId: "user-1", Helpful: true})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = reviewClient.VoteReview(context.Background(), &pb.VoteReviewRequest{ReviewId: reviewIDs[0], Helpful: true})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = reviewClient.VoteReview(context.Background(), &pb.VoteReviewRequest{ReviewId: "unknown", UserId: "user-2", Helpful: true})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	req := &pb.ListReviewsRequest{LaptopId: laptop.GetId(), SortBy: pb.ListReviewsRequest_MOST_HELPFUL, PageSize: 2}
+	res, err := reviewClient.ListReviews(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.GetReviews(), 2)
+	require.Equal(t, reviewIDs[0], res.GetReviews()[0].GetId())
+	require.Equal(t, uint32(1), res.GetReviews()[0].GetHelpfulCount())
+	require.NotEmpty(t, res.GetNextPageToken())
+
+	req.PageToken = res.GetNextPageToken()
+	res, err = reviewClient.ListReviews(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.GetReviews(), 1)
+	require.Empty(t, res.GetNextPageToken())
+
+	// a token of another order is rejected
+	req.SortBy = pb.ListReviewsRequest_NEWEST
+	_, err = reviewClient.ListReviews(context.Background(), req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = reviewClient.ListReviews(context.Background(), &pb.ListReviewsRequest{LaptopId: sample.NewLaptop().GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func newTestReviewClient(t *testing.T, laptopStore service.LaptopStore, ratingStore service.RatingStore) pb.ReviewServiceClient {
+	reviewServer := service.NewReviewServer(service.NewInMemoryReviewStore(), laptopStore, ratingStore)
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	pb.RegisterReviewServiceServer(grpcServer, reviewServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	require.NoError(t, err)
+
+	return pb.NewReviewServiceClient(conn)
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxReviewTitleLength and maxReviewBodyLength are the longest title and
+// body of a review, in characters
+const (
+	maxReviewTitleLength = 200
+	maxReviewBodyLength  = 10000
+)
+
+// ReviewServer serves the reviews of the laptops. The score of a review is
+// added to the rating store, so it counts in the rating of the laptop
+type ReviewServer struct {
+	reviewStore ReviewStore
+	laptopStore LaptopStore
+	ratingStore RatingStore
+	minScore    float64
+	maxScore    float64
+	scoreStep   float64
+}
+
+func NewReviewServer(reviewStore ReviewStore, laptopStore LaptopStore, ratingStore RatingStore) *ReviewServer {
+	return &ReviewServer{
+		reviewStore: reviewStore,
+		laptopStore: laptopStore,
+		ratingStore: ratingStore,
+		minScore:    DefaultMinScore,
+		maxScore:    DefaultMaxScore,
+		scoreStep:   DefaultScoreStep,
+	}
+}
+
+// SetScoreRange changes the scores of a review, which should be the same as
+// for RateLaptop
+func (server *ReviewServer) SetScoreRange(minScore float64, maxScore float64, step float64) error {
+	err := checkScoreRange(minScore, maxScore, step)
+	if err != nil {
+		return err
+	}
+
+	server.minScore = minScore
+	server.maxScore = maxScore
+	server.scoreStep = step
+	return nil
+}
+
+func (server *ReviewServer) findLaptop(laptopID string) error {
+	_, err := server.laptopStore.Find(laptopID)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		}
+		return logError(status.Errorf(code, "Cannot find laptop: %v", err))
+	}
+	return nil
+}
+
+func (server *ReviewServer) SubmitReview(ctx context.Context, req *pb.SubmitReviewRequest) (*pb.SubmitReviewResponse, error) {
+	laptopID := req.GetLaptopId()
+	log.Printf("Received submit-review request for laptop ID: %s with score: %.2f", laptopID, req.GetScore())
+
+	author, err := callerID(ctx, req.GetAuthor())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	review := &Review{
+		LaptopID: laptopID,
+		Author:   author,
+		Title:    strings.TrimSpace(req.GetTitle()),
+		Body:     strings.TrimSpace(req.GetBody()),
+		Score:    req.GetScore(),
+	}
+
+	if review.Author == "" {
+		return nil, logError(status.Error(codes.InvalidArgument, "Author is required to submit a review"))
+	}
+	if review.Title == "" || utf8.RuneCountInString(review.Title) > maxReviewTitleLength {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Title must have 1 to %d characters", maxReviewTitleLength))
+	}
+	if review.Body == "" || utf8.RuneCountInString(review.Body) > maxReviewBodyLength {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Body must have 1 to %d characters", maxReviewBodyLength))
+	}
+	review.Score, err = checkScore(review.Score, server.minScore, server.maxScore, server.scoreStep)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err = server.findLaptop(laptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the review goes first, so that a failure does not leave a rating
+	// without its review. If the rating fails, submitting the review again
+	// adds it
+	review, err = server.reviewStore.Save(review)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot save the review: %v", err))
+	}
+
+	rating, err := server.ratingStore.Add(laptopID, review.Author, review.Score)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot add the rating: %v", err))
+	}
+
+	log.Printf("Saved review with ID: %s", review.ID)
+
+	res := &pb.SubmitReviewResponse{
+		Review:       reviewToProto(review),
+		RatedCount:   rating.Count,
+		AverageScore: rating.Mean(),
+	}
+	return res, nil
+}
+
+func (server *ReviewServer) ListReviews(ctx context.Context, req *pb.ListReviewsRequest) (*pb.ListReviewsResponse, error) {
+	laptopID := req.GetLaptopId()
+	log.Printf("Received list-reviews request for laptop ID: %s sorted by: %v", laptopID, req.GetSortBy())
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err := server.findLaptop(laptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &ReviewListOptions{
+		SortBy:    req.GetSortBy(),
+		PageSize:  int(req.GetPageSize()),
+		PageToken: req.GetPageToken(),
+	}
+
+	reviews, nextPageToken, err := server.reviewStore.List(laptopID, options)
+	if errors.Is(err, ErrInvalidPageToken) {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "Cannot list reviews: %v", err))
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Cannot list reviews: %v", err))
+	}
+
+	res := &pb.ListReviewsResponse{NextPageToken: nextPageToken}
+	for _, review := range reviews {
+		res.Reviews = append(res.Reviews, reviewToProto(review))
+	}
+	return res, nil
+}
+
+func (server *ReviewServer) VoteReview(ctx context.Context, req *pb.VoteReviewRequest) (*pb.VoteReviewResponse, error) {
+	reviewID := req.GetReviewId()
+	log.Printf("Received vote-review request for review ID: %s from user: %s", reviewID, req.GetUserId())
+
+	userID, err := callerID(ctx, req.GetUserId())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	if userID == "" {
+		return nil, logError(status.Error(codes.InvalidArgument, "User ID is required to vote for a review"))
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	review, err := server.reviewStore.Vote(reviewID, userID, req.GetHelpful())
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, ErrNotFound) {
+			code = codes.NotFound
+		} else if errors.Is(err, ErrOwnReview) {
+			code = codes.InvalidArgument
+		}
+		return nil, logError(status.Errorf(code, "Cannot vote for the review: %v", err))
+	}
+
+	res := &pb.VoteReviewResponse{
+		ReviewId:     review.ID,
+		HelpfulCount: review.HelpfulCount,
+	}
+	return res, nil
+}
+
+func reviewToProto(review *Review) *pb.Review {
+	return &pb.Review{
+		Id:           review.ID,
+		LaptopId:     review.LaptopID,
+		Author:       review.Author,
+		Title:        review.Title,
+		Body:         review.Body,
+		Score:        review.Score,
+		HelpfulCount: review.HelpfulCount,
+		SubmittedAt:  timestamppb.New(review.SubmittedAt),
+	}
+}
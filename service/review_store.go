@@ -0,0 +1,252 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+)
+
+// ErrOwnReview is returned when the author of a review votes for it
+var ErrOwnReview = errors.New("Cannot vote for one's own review")
+
+type ReviewStore interface {
+	// Save stores the review of the author for the laptop and returns it with
+	// its ID and submission time. An author has one review per laptop, a new
+	// review replaces the previous one but keeps its ID and its helpful votes
+	Save(review *Review) (*Review, error)
+	Find(reviewID string) (*Review, error)
+	// List returns a page of the reviews of the laptop, together with the
+	// token of the next page, which is empty on the last page
+	List(laptopID string, options *ReviewListOptions) ([]*Review, string, error)
+	// Vote records whether the user found the review helpful and returns the
+	// review with its new helpful count. A user has one vote per review
+	Vote(reviewID string, userID string, helpful bool) (*Review, error)
+}
+
+type Review struct {
+	ID       string  `json:"id"`
+	LaptopID string  `json:"laptop_id"`
+	Author   string  `json:"author"`
+	Title    string  `json:"title"`
+	Body     string  `json:"body"`
+	Score    float64 `json:"score"`
+	// HelpfulCount is the number of users who found the review helpful
+	HelpfulCount uint32    `json:"-"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+}
+
+// ReviewListOptions controls the order and the paging of the reviews
+// returned by ReviewStore.List
+type ReviewListOptions struct {
+	SortBy pb.ListReviewsRequest_SortBy
+	// PageSize limits the number of reviews returned, zero means no limit
+	PageSize  int
+	PageToken string
+}
+
+// reviewPageToken marks the last review of a page, like pageToken does for laptops
+type reviewPageToken struct {
+	SortBy pb.ListReviewsRequest_SortBy `json:"sort_by"`
+	Key    int64                        `json:"key"`
+	ID     string                       `json:"id"`
+}
+
+// reviewSortKey returns the value reviews are sorted by, highest first
+func reviewSortKey(review *Review, sortBy pb.ListReviewsRequest_SortBy) int64 {
+	if sortBy == pb.ListReviewsRequest_MOST_HELPFUL {
+		return int64(review.HelpfulCount)
+	}
+	return review.SubmittedAt.UnixNano()
+}
+
+// sortAndPageReviews orders the reviews as requested and keeps the ones on the
+// page selected by the page token. It returns the token of the next page
+func sortAndPageReviews(reviews []*Review, options *ReviewListOptions) ([]*Review, string, error) {
+	if options == nil {
+		options = &ReviewListOptions{}
+	}
+
+	// before orders the highest key first, and the lowest ID first for equal keys
+	before := func(keyA int64, idA string, keyB int64, idB string) bool {
+		if keyA != keyB {
+			return keyA > keyB
+		}
+		return idA < idB
+	}
+
+	sort.Slice(reviews, func(i, j int) bool {
+		a, b := reviews[i], reviews[j]
+		return before(reviewSortKey(a, options.SortBy), a.ID, reviewSortKey(b, options.SortBy), b.ID)
+	})
+
+	if options.PageToken != "" {
+		token := &reviewPageToken{}
+		err := decodePageToken(options.PageToken, token)
+		if err != nil {
+			return nil, "", err
+		}
+		if token.SortBy != options.SortBy {
+			return nil, "", ErrInvalidPageToken
+		}
+
+		start := sort.Search(len(reviews), func(i int) bool {
+			return before(token.Key, token.ID, reviewSortKey(reviews[i], options.SortBy), reviews[i].ID)
+		})
+		reviews = reviews[start:]
+	}
+
+	if options.PageSize <= 0 || len(reviews) <= options.PageSize {
+		return reviews, "", nil
+	}
+
+	reviews = reviews[:options.PageSize]
+	last := reviews[len(reviews)-1]
+
+	nextPageToken, err := encodePageToken(&reviewPageToken{
+		SortBy: options.SortBy,
+		Key:    reviewSortKey(last, options.SortBy),
+		ID:     last.ID,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return reviews, nextPageToken, nil
+}
+
+type InMemoryReviewStore struct {
+	mutex   sync.RWMutex
+	reviews map[string]*memoryReview
+	// byAuthor maps a laptop ID to the review ID of each author
+	byAuthor map[string]map[string]string
+}
+
+type memoryReview struct {
+	review *Review
+	// voters holds the users who found the review helpful
+	voters map[string]bool
+}
+
+func NewInMemoryReviewStore() *InMemoryReviewStore {
+	return &InMemoryReviewStore{
+		reviews:  make(map[string]*memoryReview),
+		byAuthor: make(map[string]map[string]string),
+	}
+}
+
+func (store *InMemoryReviewStore) Save(review *Review) (*Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	saved := store.next(review)
+	store.put(saved)
+
+	other := *saved
+	return &other, nil
+}
+
+// next returns the review as it is saved, with the ID and the helpful count of
+// the previous review of the author. The caller holds the lock
+func (store *InMemoryReviewStore) next(review *Review) *Review {
+	saved := &Review{
+		ID:          uuid.New().String(),
+		LaptopID:    review.LaptopID,
+		Author:      review.Author,
+		Title:       review.Title,
+		Body:        review.Body,
+		Score:       review.Score,
+		SubmittedAt: time.Now().UTC(),
+	}
+
+	if previous := store.reviews[store.byAuthor[review.LaptopID][review.Author]]; previous != nil {
+		saved.ID = previous.review.ID
+		saved.HelpfulCount = previous.review.HelpfulCount
+	}
+	return saved
+}
+
+// put stores a review returned by next, keeping the votes of the review with
+// the same ID. The caller holds the lock
+func (store *InMemoryReviewStore) put(saved *Review) {
+	authors := store.byAuthor[saved.LaptopID]
+	if authors == nil {
+		authors = make(map[string]string)
+		store.byAuthor[saved.LaptopID] = authors
+	}
+
+	stored := store.reviews[saved.ID]
+	if stored == nil {
+		stored = &memoryReview{voters: make(map[string]bool)}
+		store.reviews[saved.ID] = stored
+		authors[saved.Author] = saved.ID
+	}
+
+	review := *saved
+	review.HelpfulCount = uint32(len(stored.voters))
+	stored.review = &review
+}
+
+func (store *InMemoryReviewStore) Find(reviewID string) (*Review, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	stored := store.reviews[reviewID]
+	if stored == nil {
+		return nil, fmt.Errorf("Cannot find the review with ID %s: %w", reviewID, ErrNotFound)
+	}
+
+	other := *stored.review
+	return &other, nil
+}
+
+func (store *InMemoryReviewStore) List(laptopID string, options *ReviewListOptions) ([]*Review, string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	reviews := make([]*Review, 0, len(store.byAuthor[laptopID]))
+	for _, reviewID := range store.byAuthor[laptopID] {
+		other := *store.reviews[reviewID].review
+		reviews = append(reviews, &other)
+	}
+
+	return sortAndPageReviews(reviews, options)
+}
+
+func (store *InMemoryReviewStore) Vote(reviewID string, userID string, helpful bool) (*Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.checkVote(reviewID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := store.reviews[reviewID]
+	if helpful {
+		stored.voters[userID] = true
+	} else {
+		delete(stored.voters, userID)
+	}
+	stored.review.HelpfulCount = uint32(len(stored.voters))
+
+	other := *stored.review
+	return &other, nil
+}
+
+// checkVote returns an error if the user cannot vote for the review. The
+// caller holds the lock
+func (store *InMemoryReviewStore) checkVote(reviewID string, userID string) error {
+	stored := store.reviews[reviewID]
+	if stored == nil {
+		return fmt.Errorf("Cannot find the review with ID %s: %w", reviewID, ErrNotFound)
+	}
+	if userID == stored.review.Author {
+		return ErrOwnReview
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package service_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/pb"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestInMemoryReviewStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryReviewStore()
+
+	reviews := make([]*service.Review, 5)
+	for i := range reviews {
+		review, err := store.Save(&service.Review{
+			LaptopID: "laptop-1",
+			Author:   fmt.Sprintf("user-%d", i),
+			Title:    "Great",
+			Body:     "Great laptop",
+			Score:    float64(5 + i),
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, review.ID)
+		reviews[i] = review
+
+		// the reviews are listed by submission time
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := store.Save(&service.Review{LaptopID: "laptop-2", Author: "user-0", Title: "Bad", Body: "Bad laptop", Score: 1})
+	require.NoError(t, err)
+
+	// user-3 votes for review 1 and 2, user-4 for review 2
+	_, err = store.Vote(reviews[1].ID, "user-3", true)
+	require.NoError(t, err)
+	_, err = store.Vote(reviews[2].ID, "user-3", true)
+	require.NoError(t, err)
+	review, err := store.Vote(reviews[2].ID, "user-4", true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), review.HelpfulCount)
+
+	// votes are counted once per user and can be taken back
+	review, err = store.Vote(reviews[2].ID, "user-4", true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), review.HelpfulCount)
+	review, err = store.Vote(reviews[1].ID, "user-3", false)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), review.HelpfulCount)
+
+	_, err = store.Vote(reviews[0].ID, "user-0", true)
+	require.ErrorIs(t, err, service.ErrOwnReview)
+	_, err = store.Vote("unknown", "user-0", true)
+	require.ErrorIs(t, err, service.ErrNotFound)
+
+	listIDs := func(options *service.ReviewListOptions) []string {
+		var ids []string
+		for {
+			page, nextPageToken, err := store.List("laptop-1", options)
+			require.NoError(t, err)
+			for _, review := range page {
+				ids = append(ids, review.ID)
+			}
+			if nextPageToken == "" {
+				return ids
+			}
+			options.PageToken = nextPageToken
+		}
+	}
+
+	newest := listIDs(&service.ReviewListOptions{PageSize: 2})
+	require.Equal(t, []string{reviews[4].ID, reviews[3].ID, reviews[2].ID, reviews[1].ID, reviews[0].ID}, newest)
+
+	helpful := listIDs(&service.ReviewListOptions{SortBy: pb.ListReviewsRequest_MOST_HELPFUL, PageSize: 3})
+	require.Len(t, helpful, len(reviews))
+	require.Equal(t, reviews[2].ID, helpful[0])
+
+	// a new review of the same author replaces the previous one
+	review, err = store.Save(&service.Review{LaptopID: "laptop-1", Author: "user-2", Title: "Good", Body: "Good laptop", Score: 6})
+	require.NoError(t, err)
+	require.Equal(t, reviews[2].ID, review.ID)
+	require.Equal(t, uint32(2), review.HelpfulCount)
+
+	review, err = store.Find(reviews[2].ID)
+	require.NoError(t, err)
+	require.Equal(t, "Good", review.Title)
+	require.Equal(t, 6.0, review.Score)
+
+	page, _, err := store.List("laptop-1", &service.ReviewListOptions{PageSize: 1})
+	require.NoError(t, err)
+	require.Equal(t, reviews[2].ID, page[0].ID)
+	require.Len(t, listIDs(&service.ReviewListOptions{}), len(reviews))
+
+	_, _, err = store.List("laptop-1", &service.ReviewListOptions{PageToken: "invalid"})
+	require.ErrorIs(t, err, service.ErrInvalidPageToken)
+}
@@ -0,0 +1,486 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/keshavbhattad/pcbook/s3"
+)
+
+// DefaultS3PartSize is the size of the parts images larger than it are
+// uploaded in, the smallest part size S3 accepts
+const DefaultS3PartSize = s3.DefaultMinPartSize
+
+// S3ImageStore keeps images in an S3 compatible bucket, so that several
+// servers can share them. Each image has a data object and a metadata object,
+// and small index objects list the images of a laptop and the variants of an
+// image:
+//
+//	data/<id><type>                     the image data
+//	images/<id>.json                    the image info
+//	laptops/<laptop id>/<id>            an image of the laptop
+//	laptops/<laptop id>/<id>/<variant>  the ID of the variant of the image
+//
+// A single listing of the laptop prefix finds the images of the laptop
+// together with their variants
+//
+// Images are not deduplicated, since counting the images sharing data would
+// need the servers to coordinate
+type S3ImageStore struct {
+	client   *s3.Client
+	partSize int
+}
+
+// NewS3ImageStore creates a store uploading the images larger than partSize
+// in several parts
+func NewS3ImageStore(client *s3.Client, partSize int) *S3ImageStore {
+	return &S3ImageStore{
+		client:   client,
+		partSize: partSize,
+	}
+}
+
+func s3DataKey(info *ImageInfo) string {
+	return "data/" + info.FileName
+}
+
+func s3MetadataKey(imageID string) string {
+	return "images/" + imageID + imageMetadataExt
+}
+
+func s3LaptopPrefix(laptopID string) string {
+	return "laptops/" + url.PathEscape(laptopID) + "/"
+}
+
+func s3VariantPrefix(laptopID string, imageID string) string {
+	return s3LaptopPrefix(laptopID) + imageID + "/"
+}
+
+// s3Error wraps the errors about missing objects with ErrNotFound
+func s3Error(err error, format string, args ...interface{}) error {
+	if s3.IsNotFound(err) {
+		return fmt.Errorf(format+": %w", append(args, ErrNotFound)...)
+	}
+	return fmt.Errorf(format+": %w", append(args, err)...)
+}
+
+func (store *S3ImageStore) Create(ctx context.Context, laptopID string, imageType string, mimeType string) (ImageWriter, error) {
+	return store.create(ctx, &ImageInfo{LaptopID: laptopID, Type: imageType, MimeType: mimeType})
+}
+
+func (store *S3ImageStore) CreateVariant(ctx context.Context, imageID string, variant string, imageType string, mimeType string) (ImageWriter, error) {
+	if !variantNamePattern.MatchString(variant) {
+		return nil, fmt.Errorf("Invalid image variant %q", variant)
+	}
+
+	parent, err := store.find(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != "" {
+		return nil, fmt.Errorf("Image %s is already a variant", imageID)
+	}
+
+	return store.create(ctx, &ImageInfo{
+		LaptopID: parent.LaptopID,
+		Type:     imageType,
+		MimeType: mimeType,
+		ParentID: imageID,
+		Variant:  variant,
+	})
+}
+
+func (store *S3ImageStore) create(ctx context.Context, info *ImageInfo) (ImageWriter, error) {
+	if !imageExtPattern.MatchString(info.Type) {
+		return nil, fmt.Errorf("Invalid image type %q", info.Type)
+	}
+
+	imageID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate image id: %w", err)
+	}
+
+	info.ID = imageID.String()
+	info.FileName = info.ID + info.Type
+	info.Path = s3DataKey(info)
+
+	writer := &s3ImageWriter{
+		ctx:   ctx,
+		store: store,
+		info:  info,
+		hash:  sha256.New(),
+	}
+	return writer, nil
+}
+
+// s3ImageWriter buffers the image data, and switches to a multipart upload
+// once the data is larger than a part. Its requests are made with the context
+// of Create
+type s3ImageWriter struct {
+	ctx      context.Context
+	store    *S3ImageStore
+	info     *ImageInfo
+	hash     hash.Hash
+	size     int
+	buffer   []byte
+	uploadID string
+	parts    []*s3.Part
+	done     bool
+}
+
+func (writer *s3ImageWriter) Write(p []byte) (int, error) {
+	if writer.done {
+		return 0, errors.New("Image is already committed or aborted")
+	}
+
+	writer.hash.Write(p)
+	writer.size += len(p)
+	writer.buffer = append(writer.buffer, p...)
+
+	for len(writer.buffer) >= writer.store.partSize {
+		err := writer.uploadPart(writer.buffer[:writer.store.partSize])
+		if err != nil {
+			return 0, err
+		}
+		writer.buffer = append([]byte{}, writer.buffer[writer.store.partSize:]...)
+	}
+
+	return len(p), nil
+}
+
+func (writer *s3ImageWriter) uploadPart(data []byte) error {
+	ctx := writer.ctx
+	key := s3DataKey(writer.info)
+
+	if writer.uploadID == "" {
+		uploadID, err := writer.store.client.CreateMultipartUpload(ctx, key, writer.info.MimeType)
+		if err != nil {
+			return fmt.Errorf("Cannot start image upload: %w", err)
+		}
+		writer.uploadID = uploadID
+	}
+
+	part, err := writer.store.client.UploadPart(ctx, key, writer.uploadID, len(writer.parts)+1, data)
+	if err != nil {
+		return fmt.Errorf("Cannot upload image part: %w", err)
+	}
+	writer.parts = append(writer.parts, part)
+	return nil
+}
+
+func (writer *s3ImageWriter) Commit() (*ImageInfo, error) {
+	if writer.done {
+		return nil, errors.New("Image is already committed or aborted")
+	}
+
+	ctx := writer.ctx
+	key := s3DataKey(writer.info)
+
+	if writer.uploadID == "" {
+		err := writer.store.client.PutObject(ctx, key, writer.buffer, writer.info.MimeType)
+		if err != nil {
+			writer.Abort()
+			return nil, fmt.Errorf("Cannot upload image: %w", err)
+		}
+	} else {
+		if len(writer.buffer) > 0 {
+			err := writer.uploadPart(writer.buffer)
+			if err != nil {
+				writer.Abort()
+				return nil, err
+			}
+		}
+
+		err := writer.store.client.CompleteMultipartUpload(ctx, key, writer.uploadID, writer.parts)
+		if err != nil {
+			writer.Abort()
+			return nil, fmt.Errorf("Cannot complete image upload: %w", err)
+		}
+	}
+	writer.done = true
+
+	info := *writer.info
+	info.Size = writer.size
+	info.Hash = hex.EncodeToString(writer.hash.Sum(nil))
+	info.UploadedAt = time.Now().UTC()
+
+	err := writer.store.add(ctx, &info)
+	if err != nil {
+		writer.store.client.DeleteObject(ctx, key)
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func (writer *s3ImageWriter) Abort() error {
+	if writer.done {
+		return nil
+	}
+	writer.done = true
+	writer.buffer = nil
+
+	if writer.uploadID == "" {
+		return nil
+	}
+
+	err := writer.store.client.AbortMultipartUpload(writer.ctx, s3DataKey(writer.info), writer.uploadID)
+	if err != nil {
+		return fmt.Errorf("Cannot abort image upload: %w", err)
+	}
+	return nil
+}
+
+// add writes the metadata of an image whose data is uploaded, then the index
+// object that makes it visible. A variant replaces the previous variant with
+// the same name
+func (store *S3ImageStore) add(ctx context.Context, info *ImageInfo) error {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Cannot marshal image metadata: %w", err)
+	}
+
+	err = store.client.PutObject(ctx, s3MetadataKey(info.ID), data, "application/json")
+	if err != nil {
+		return fmt.Errorf("Cannot write image metadata: %w", err)
+	}
+
+	if info.ParentID == "" {
+		err = store.client.PutObject(ctx, s3LaptopPrefix(info.LaptopID)+info.ID, nil, "")
+		if err != nil {
+			store.client.DeleteObject(ctx, s3MetadataKey(info.ID))
+			return fmt.Errorf("Cannot write image index: %w", err)
+		}
+		return nil
+	}
+
+	replacedID, err := store.variantID(ctx, info.LaptopID, info.ParentID, info.Variant)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		store.client.DeleteObject(ctx, s3MetadataKey(info.ID))
+		return err
+	}
+
+	err = store.client.PutObject(ctx, s3VariantPrefix(info.LaptopID, info.ParentID)+info.Variant, []byte(info.ID), "text/plain")
+	if err != nil {
+		store.client.DeleteObject(ctx, s3MetadataKey(info.ID))
+		return fmt.Errorf("Cannot write image variant index: %w", err)
+	}
+
+	if replacedID != "" {
+		if err := store.remove(ctx, replacedID); err != nil {
+			log.Printf("Cannot remove replaced variant %s: %v", replacedID, err)
+		}
+	}
+	return nil
+}
+
+// variantID reads the ID of the variant of the image of the laptop
+func (store *S3ImageStore) variantID(ctx context.Context, laptopID string, imageID string, variant string) (string, error) {
+	reader, _, err := store.client.GetObject(ctx, s3VariantPrefix(laptopID, imageID)+variant)
+	if err != nil {
+		return "", s3Error(err, "Cannot find the variant %s of image %s", variant, imageID)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read image variant index: %w", err)
+	}
+	return string(data), nil
+}
+
+// remove deletes the metadata and the data of an image, but not its index objects
+func (store *S3ImageStore) remove(ctx context.Context, imageID string) error {
+	info, err := store.find(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	err = store.client.DeleteObject(ctx, s3MetadataKey(imageID))
+	if err != nil {
+		return fmt.Errorf("Cannot remove image metadata: %w", err)
+	}
+
+	err = store.client.DeleteObject(ctx, s3DataKey(info))
+	if err != nil {
+		return fmt.Errorf("Cannot remove image data: %w", err)
+	}
+	return nil
+}
+
+// find reads the image metadata
+func (store *S3ImageStore) find(ctx context.Context, imageID string) (*ImageInfo, error) {
+	if strings.ContainsAny(imageID, "/") {
+		return nil, fmt.Errorf("Cannot find the image with ID %s: %w", imageID, ErrNotFound)
+	}
+
+	reader, _, err := store.client.GetObject(ctx, s3MetadataKey(imageID))
+	if err != nil {
+		return nil, s3Error(err, "Cannot find the image with ID %s", imageID)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read image metadata: %w", err)
+	}
+
+	info := &ImageInfo{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal image metadata: %w", err)
+	}
+	info.Path = s3DataKey(info)
+
+	return info, nil
+}
+
+func (store *S3ImageStore) Find(ctx context.Context, imageID string) (*ImageInfo, error) {
+	info, err := store.find(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.ParentID == "" {
+		prefix := s3VariantPrefix(info.LaptopID, imageID)
+		keys, err := store.client.ListObjects(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list image variants: %w", err)
+		}
+		for _, key := range keys {
+			info.Variants = append(info.Variants, strings.TrimPrefix(key, prefix))
+		}
+	}
+
+	return info, nil
+}
+
+// List reads the index objects of the images of the laptop and of their
+// variants in a single listing, then the metadata of each image
+func (store *S3ImageStore) List(ctx context.Context, laptopID string) ([]*ImageInfo, error) {
+	prefix := s3LaptopPrefix(laptopID)
+	keys, err := store.client.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list images: %w", err)
+	}
+
+	var imageIDs []string
+	variants := make(map[string][]string)
+	for _, key := range keys {
+		parts := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2)
+		if len(parts) == 1 {
+			imageIDs = append(imageIDs, parts[0])
+		} else {
+			variants[parts[0]] = append(variants[parts[0]], parts[1])
+		}
+	}
+
+	var images []*ImageInfo
+	for _, imageID := range imageIDs {
+		info, err := store.find(ctx, imageID)
+		if errors.Is(err, ErrNotFound) {
+			// the image is being deleted
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info.Variants = variants[imageID]
+		images = append(images, info)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		if !images[i].UploadedAt.Equal(images[j].UploadedAt) {
+			return images[i].UploadedAt.Before(images[j].UploadedAt)
+		}
+		return images[i].ID < images[j].ID
+	})
+
+	return images, nil
+}
+
+func (store *S3ImageStore) Open(ctx context.Context, imageID string) (*ImageInfo, io.ReadCloser, error) {
+	info, err := store.Find(ctx, imageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, _, err := store.client.GetObject(ctx, s3DataKey(info))
+	if err != nil {
+		return nil, nil, s3Error(err, "Cannot open image data")
+	}
+
+	return info, reader, nil
+}
+
+func (store *S3ImageStore) OpenVariant(ctx context.Context, imageID string, variant string) (*ImageInfo, io.ReadCloser, error) {
+	if !variantNamePattern.MatchString(variant) {
+		return nil, nil, fmt.Errorf("Cannot find the variant %s of image %s: %w", variant, imageID, ErrNotFound)
+	}
+
+	parent, err := store.find(ctx, imageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variantID, err := store.variantID(ctx, parent.LaptopID, imageID, variant)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return store.Open(ctx, variantID)
+}
+
+func (store *S3ImageStore) Delete(ctx context.Context, imageID string) error {
+	info, err := store.Find(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	// the index objects go first, so that the image is no longer listed
+	// while it is being deleted
+	if info.ParentID == "" {
+		err = store.client.DeleteObject(ctx, s3LaptopPrefix(info.LaptopID)+imageID)
+	} else {
+		var variantID string
+		variantID, err = store.variantID(ctx, info.LaptopID, info.ParentID, info.Variant)
+		if err == nil && variantID == imageID {
+			err = store.client.DeleteObject(ctx, s3VariantPrefix(info.LaptopID, info.ParentID)+info.Variant)
+		} else if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot remove image index: %w", err)
+	}
+
+	for _, variant := range info.Variants {
+		variantID, err := store.variantID(ctx, info.LaptopID, imageID, variant)
+		if err == nil {
+			err = store.remove(ctx, variantID)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		err = store.client.DeleteObject(ctx, s3VariantPrefix(info.LaptopID, imageID)+variant)
+		if err != nil {
+			return fmt.Errorf("Cannot remove image variant index: %w", err)
+		}
+	}
+
+	return store.remove(ctx, imageID)
+}
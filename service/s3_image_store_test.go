@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/s3"
+	"gitlab.com/keshavbhattad/pcbook/s3/s3test"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+const testS3PartSize = 4096
+
+func startFakeS3(t *testing.T) (*s3test.FakeServer, func() *service.S3ImageStore) {
+	credentials := s3.Credentials{AccessKeyID: "test-access-key", SecretAccessKey: "test-secret-key"}
+
+	fake := s3test.NewFakeServer(credentials, "us-east-1", "images")
+	fake.MinPartSize = testS3PartSize
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	// each store is like another server sharing the bucket
+	newStore := func() *service.S3ImageStore {
+		client, err := s3.NewClient(s3.Config{
+			Endpoint:    server.URL,
+			Region:      "us-east-1",
+			Bucket:      "images",
+			Credentials: credentials,
+		})
+		require.NoError(t, err)
+		return service.NewS3ImageStore(client, testS3PartSize)
+	}
+
+	return fake, newStore
+}
+
+func TestS3ImageStore(t *testing.T) {
+	t.Parallel()
+
+	fake, newStore := startFakeS3(t)
+	store := newStore()
+
+	imageData, err := ioutil.ReadFile("../tmp/image.jpeg")
+	require.NoError(t, err)
+	require.Greater(t, len(imageData), testS3PartSize)
+
+	laptopID := "laptop-1"
+	info, err := service.SaveImage(context.Background(), store, laptopID, ".jpeg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	require.Equal(t, len(imageData), info.Size)
+	require.Equal(t, "image/jpeg", info.MimeType)
+	require.Zero(t, fake.Uploads())
+
+	aborted, err := store.Create(context.Background(), laptopID, ".jpeg", "image/jpeg")
+	require.NoError(t, err)
+	_, err = aborted.Write(imageData)
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.Uploads())
+	require.NoError(t, aborted.Abort())
+	require.Zero(t, fake.Uploads())
+
+	replica := newStore()
+
+	images, err := replica.List(context.Background(), laptopID)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	require.Equal(t, info.ID, images[0].ID)
+	require.Equal(t, info.Hash, images[0].Hash)
+
+	_, reader, err := replica.Open(context.Background(), info.ID)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, imageData, data)
+
+	variants := []service.ImageVariant{{Name: "thumbnail", MaxWidth: 32, MaxHeight: 32}}
+	_, err = service.SaveImageVariants(context.Background(), replica, info, variants)
+	require.NoError(t, err)
+	_, err = service.SaveImageVariants(context.Background(), store, info, variants)
+	require.NoError(t, err)
+
+	found, err := store.Find(context.Background(), info.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"thumbnail"}, found.Variants)
+
+	images, err = store.List(context.Background(), laptopID)
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	require.Equal(t, []string{"thumbnail"}, images[0].Variants)
+
+	variant, reader, err := replica.OpenVariant(context.Background(), info.ID, "thumbnail")
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, info.ID, variant.ParentID)
+
+	_, _, err = store.OpenVariant(context.Background(), info.ID, "medium")
+	require.ErrorIs(t, err, service.ErrNotFound)
+
+	err = replica.Delete(context.Background(), info.ID)
+	require.NoError(t, err)
+	require.Empty(t, fake.Keys("images"))
+
+	_, err = store.Find(context.Background(), info.ID)
+	require.ErrorIs(t, err, service.ErrNotFound)
+	require.ErrorIs(t, store.Delete(context.Background(), info.ID), service.ErrNotFound)
+}
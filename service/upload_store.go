@@ -0,0 +1,486 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUploadTTL is how long an upload is kept without receiving data
+// before it is considered abandoned
+const DefaultUploadTTL = 24 * time.Hour
+
+// The limits of InMemoryUploadStore, unless they are changed with its setters
+const (
+	DefaultMemoryUploadBytes = 64 << 20
+	DefaultMemoryUploadTTL   = time.Hour
+)
+
+// ErrUploadStoreFull is returned when an upload store has no room for more data
+var ErrUploadStoreFull = errors.New("Upload store is full")
+
+// ErrUploadOffset is returned when data is appended to an upload at another
+// offset than its current size, e.g. by two streams resuming the same upload
+var ErrUploadOffset = errors.New("Upload offset does not match the uploaded size")
+
+// uploadDataExt and uploadMetadataExt are the extensions of the files holding
+// the data received so far and the info of an upload
+const (
+	uploadDataExt     = ".part"
+	uploadMetadataExt = ".json"
+)
+
+// UploadStore keeps the data of image uploads until they are complete, so that
+// an interrupted upload can be resumed
+type UploadStore interface {
+	// Create starts a new upload. It returns ErrAlreadyExists if an upload
+	// with the same ID exists
+	Create(uploadID string, laptopID string, imageType string) (*UploadInfo, error)
+	Find(uploadID string) (*UploadInfo, error)
+	// Append adds data at the end of the upload, which must be at offset, and
+	// returns the new size of the upload
+	Append(uploadID string, offset int64, data []byte) (int64, error)
+	// Open returns the upload info together with a reader of the data
+	// received so far, which the caller must close
+	Open(uploadID string) (*UploadInfo, io.ReadCloser, error)
+	Delete(uploadID string) error
+	// DeleteExpired removes the uploads which received no data since the
+	// time before, and returns how many were removed
+	DeleteExpired(before time.Time) (int, error)
+}
+
+type UploadInfo struct {
+	ID        string    `json:"id"`
+	LaptopID  string    `json:"laptop_id"`
+	ImageType string    `json:"image_type"`
+	Size      int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when data was last appended, or the creation time
+	UpdatedAt time.Time `json:"-"`
+}
+
+// ExpireUploads removes the uploads which received no data for ttl, every
+// interval, until the context is done
+func ExpireUploads(ctx context.Context, store UploadStore, ttl time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		count, err := store.DeleteExpired(time.Now().Add(-ttl))
+		if err != nil {
+			log.Printf("Cannot remove expired uploads: %v", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Removed %d expired uploads", count)
+		}
+	}
+}
+
+// InMemoryUploadStore keeps the uploads in memory, for tests and servers
+// without a disk. It holds at most DefaultMemoryUploadBytes of data, and
+// drops the uploads idle for DefaultMemoryUploadTTL when an upload is created
+// or when it needs room. Servers should use DiskUploadStore, which does not
+// keep the data in memory
+type InMemoryUploadStore struct {
+	mutex    sync.RWMutex
+	uploads  map[string]*memoryUpload
+	size     int64
+	maxBytes int64
+	ttl      time.Duration
+}
+
+type memoryUpload struct {
+	info *UploadInfo
+	data []byte
+}
+
+func NewInMemoryUploadStore() *InMemoryUploadStore {
+	return &InMemoryUploadStore{
+		uploads:  make(map[string]*memoryUpload),
+		maxBytes: DefaultMemoryUploadBytes,
+		ttl:      DefaultMemoryUploadTTL,
+	}
+}
+
+// SetMaxBytes changes the largest number of bytes kept for all the uploads
+func (store *InMemoryUploadStore) SetMaxBytes(maxBytes int64) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.maxBytes = maxBytes
+}
+
+// SetTTL changes how long an upload is kept without receiving data
+func (store *InMemoryUploadStore) SetTTL(ttl time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.ttl = ttl
+}
+
+func (store *InMemoryUploadStore) Create(uploadID string, laptopID string, imageType string) (*UploadInfo, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.uploads[uploadID] != nil {
+		return nil, ErrAlreadyExists
+	}
+
+	now := time.Now().UTC()
+	store.deleteExpired(now.Add(-store.ttl), "")
+
+	info := &UploadInfo{
+		ID:        uploadID,
+		LaptopID:  laptopID,
+		ImageType: imageType,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	store.uploads[uploadID] = &memoryUpload{info: info}
+
+	other := *info
+	return &other, nil
+}
+
+func (store *InMemoryUploadStore) Find(uploadID string) (*UploadInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	upload := store.uploads[uploadID]
+	if upload == nil {
+		return nil, fmt.Errorf("Cannot find the upload with ID %s: %w", uploadID, ErrNotFound)
+	}
+
+	other := *upload.info
+	other.Size = int64(len(upload.data))
+	return &other, nil
+}
+
+func (store *InMemoryUploadStore) Append(uploadID string, offset int64, data []byte) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	upload := store.uploads[uploadID]
+	if upload == nil {
+		return 0, fmt.Errorf("Cannot find the upload with ID %s: %w", uploadID, ErrNotFound)
+	}
+	if offset != int64(len(upload.data)) {
+		return 0, fmt.Errorf("%w: offset %d, size %d", ErrUploadOffset, offset, len(upload.data))
+	}
+
+	now := time.Now().UTC()
+	if store.size+int64(len(data)) > store.maxBytes {
+		store.deleteExpired(now.Add(-store.ttl), uploadID)
+		if store.size+int64(len(data)) > store.maxBytes {
+			return 0, fmt.Errorf("%w: %d bytes are kept for the uploads", ErrUploadStoreFull, store.maxBytes)
+		}
+	}
+
+	upload.data = append(upload.data, data...)
+	upload.info.UpdatedAt = now
+	store.size += int64(len(data))
+	return int64(len(upload.data)), nil
+}
+
+func (store *InMemoryUploadStore) Open(uploadID string) (*UploadInfo, io.ReadCloser, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	upload := store.uploads[uploadID]
+	if upload == nil {
+		return nil, nil, fmt.Errorf("Cannot find the upload with ID %s: %w", uploadID, ErrNotFound)
+	}
+
+	other := *upload.info
+	other.Size = int64(len(upload.data))
+
+	// appends never change the bytes already in data
+	return &other, ioutil.NopCloser(bytes.NewReader(upload.data)), nil
+}
+
+func (store *InMemoryUploadStore) Delete(uploadID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	upload := store.uploads[uploadID]
+	if upload == nil {
+		return fmt.Errorf("Cannot find the upload with ID %s: %w", uploadID, ErrNotFound)
+	}
+
+	delete(store.uploads, uploadID)
+	store.size -= int64(len(upload.data))
+	return nil
+}
+
+func (store *InMemoryUploadStore) DeleteExpired(before time.Time) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.deleteExpired(before, ""), nil
+}
+
+// deleteExpired removes the uploads which received no data since the time
+// before, except the one receiving data, with the lock held
+func (store *InMemoryUploadStore) deleteExpired(before time.Time, except string) int {
+	count := 0
+	for uploadID, upload := range store.uploads {
+		if uploadID != except && upload.info.UpdatedAt.Before(before) {
+			delete(store.uploads, uploadID)
+			store.size -= int64(len(upload.data))
+			count++
+		}
+	}
+	return count
+}
+
+// DiskUploadStore keeps each upload in a data file and a metadata file, so
+// that uploads can also be resumed after the server restarts. The size of an
+// upload is the size of its data file, and its last update the modification
+// time of that file
+type DiskUploadStore struct {
+	mutex        sync.Mutex
+	uploadFolder string
+}
+
+func NewDiskUploadStore(uploadFolder string) (*DiskUploadStore, error) {
+	err := os.MkdirAll(uploadFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create upload folder: %w", err)
+	}
+
+	return &DiskUploadStore{
+		uploadFolder: uploadFolder,
+	}, nil
+}
+
+func (store *DiskUploadStore) dataPath(uploadID string) string {
+	return filepath.Join(store.uploadFolder, uploadID+uploadDataExt)
+}
+
+func (store *DiskUploadStore) metadataPath(uploadID string) string {
+	return filepath.Join(store.uploadFolder, uploadID+uploadMetadataExt)
+}
+
+// checkUploadID rejects IDs that would not name a file of the upload folder
+func checkUploadID(uploadID string) error {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return fmt.Errorf("Invalid upload ID %q", uploadID)
+	}
+	return nil
+}
+
+func (store *DiskUploadStore) Create(uploadID string, laptopID string, imageType string) (*UploadInfo, error) {
+	if err := checkUploadID(uploadID); err != nil {
+		return nil, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, err := os.Stat(store.metadataPath(uploadID))
+	if err == nil {
+		return nil, ErrAlreadyExists
+	}
+
+	now := time.Now().UTC()
+	info := &UploadInfo{
+		ID:        uploadID,
+		LaptopID:  laptopID,
+		ImageType: imageType,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err = ioutil.WriteFile(store.dataPath(uploadID), nil, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create upload file: %w", err)
+	}
+
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot marshal upload metadata: %w", err)
+	}
+
+	// the metadata is written last and renamed into place, an upload exists
+	// once its metadata does
+	tmpPath := store.metadataPath(uploadID) + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0644)
+	if err == nil {
+		err = os.Rename(tmpPath, store.metadataPath(uploadID))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		os.Remove(store.dataPath(uploadID))
+		return nil, fmt.Errorf("Cannot write upload metadata: %w", err)
+	}
+
+	return info, nil
+}
+
+// find reads the upload info. The caller must hold the mutex
+func (store *DiskUploadStore) find(uploadID string) (*UploadInfo, error) {
+	if err := checkUploadID(uploadID); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(store.metadataPath(uploadID))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Cannot find the upload with ID %s: %w", uploadID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read upload metadata: %w", err)
+	}
+
+	info := &UploadInfo{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal upload metadata: %w", err)
+	}
+
+	stat, err := os.Stat(store.dataPath(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read upload file: %w", err)
+	}
+	info.Size = stat.Size()
+	info.UpdatedAt = stat.ModTime().UTC()
+
+	return info, nil
+}
+
+func (store *DiskUploadStore) Find(uploadID string) (*UploadInfo, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.find(uploadID)
+}
+
+func (store *DiskUploadStore) Append(uploadID string, offset int64, data []byte) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, err := store.find(uploadID)
+	if err != nil {
+		return 0, err
+	}
+	if offset != info.Size {
+		return 0, fmt.Errorf("%w: offset %d, size %d", ErrUploadOffset, offset, info.Size)
+	}
+
+	file, err := os.OpenFile(store.dataPath(uploadID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot open upload file: %w", err)
+	}
+
+	n, err := file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Cannot write upload file: %w", err)
+	}
+
+	return info.Size + int64(n), nil
+}
+
+func (store *DiskUploadStore) Open(uploadID string) (*UploadInfo, io.ReadCloser, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, err := store.find(uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(store.dataPath(uploadID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot open upload file: %w", err)
+	}
+
+	// data appended after Open is not part of the returned size
+	return info, struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, info.Size), file}, nil
+}
+
+func (store *DiskUploadStore) Delete(uploadID string) error {
+	if err := checkUploadID(uploadID); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := os.Remove(store.metadataPath(uploadID))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Cannot find the upload with ID %s: %w", uploadID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot remove upload metadata: %w", err)
+	}
+
+	err = os.Remove(store.dataPath(uploadID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove upload file: %w", err)
+	}
+
+	return nil
+}
+
+func (store *DiskUploadStore) DeleteExpired(before time.Time) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(store.uploadFolder)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot read upload folder: %w", err)
+	}
+
+	count := 0
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, uploadMetadataExt) {
+			continue
+		}
+		uploadID := strings.TrimSuffix(name, uploadMetadataExt)
+
+		// an upload without data file was interrupted while being created or
+		// deleted, and expires with its metadata
+		updatedAt := file.ModTime()
+		if stat, err := os.Stat(store.dataPath(uploadID)); err == nil {
+			updatedAt = stat.ModTime()
+		}
+		if !updatedAt.Before(before) {
+			continue
+		}
+
+		err := os.Remove(store.metadataPath(uploadID))
+		if err != nil && !os.IsNotExist(err) {
+			return count, fmt.Errorf("Cannot remove upload metadata: %w", err)
+		}
+		err = os.Remove(store.dataPath(uploadID))
+		if err != nil && !os.IsNotExist(err) {
+			return count, fmt.Errorf("Cannot remove upload file: %w", err)
+		}
+		count++
+	}
+
+	return count, nil
+}
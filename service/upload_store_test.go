@@ -0,0 +1,177 @@
+package service_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestUploadStore(t *testing.T) {
+	t.Parallel()
+
+	diskStore, err := service.NewDiskUploadStore(t.TempDir())
+	require.NoError(t, err)
+
+	stores := map[string]service.UploadStore{
+		"memory": service.NewInMemoryUploadStore(),
+		"disk":   diskStore,
+	}
+
+	for name, store := range stores {
+		store := store
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			uploadID := "d4f4a7c2-1f5e-4a2e-9c36-0f1b0f4e8a11"
+			_, err := store.Find(uploadID)
+			require.ErrorIs(t, err, service.ErrNotFound)
+
+			info, err := store.Create(uploadID, "laptop-1", ".jpeg")
+			require.NoError(t, err)
+			require.Equal(t, int64(0), info.Size)
+
+			_, err = store.Create(uploadID, "laptop-1", ".jpeg")
+			require.ErrorIs(t, err, service.ErrAlreadyExists)
+
+			size, err := store.Append(uploadID, 0, []byte("hello "))
+			require.NoError(t, err)
+			require.Equal(t, int64(6), size)
+
+			_, err = store.Append(uploadID, 0, []byte("again"))
+			require.ErrorIs(t, err, service.ErrUploadOffset)
+
+			size, err = store.Append(uploadID, 6, []byte("world"))
+			require.NoError(t, err)
+			require.Equal(t, int64(11), size)
+
+			info, reader, err := store.Open(uploadID)
+			require.NoError(t, err)
+			require.Equal(t, "laptop-1", info.LaptopID)
+			require.Equal(t, ".jpeg", info.ImageType)
+			require.Equal(t, int64(11), info.Size)
+
+			data, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			require.Equal(t, "hello world", string(data))
+
+			require.NoError(t, store.Delete(uploadID))
+			_, err = store.Find(uploadID)
+			require.ErrorIs(t, err, service.ErrNotFound)
+			require.ErrorIs(t, store.Delete(uploadID), service.ErrNotFound)
+		})
+	}
+}
+
+func TestDiskUploadStoreReload(t *testing.T) {
+	t.Parallel()
+
+	uploadFolder := t.TempDir()
+	uploadID := "5b0e2c55-8a5e-4a69-8f3f-3f7f6a9d2e01"
+
+	store, err := service.NewDiskUploadStore(uploadFolder)
+	require.NoError(t, err)
+
+	_, err = store.Create(uploadID, "laptop-1", ".png")
+	require.NoError(t, err)
+	_, err = store.Append(uploadID, 0, []byte("partial"))
+	require.NoError(t, err)
+
+	reloaded, err := service.NewDiskUploadStore(uploadFolder)
+	require.NoError(t, err)
+
+	info, err := reloaded.Find(uploadID)
+	require.NoError(t, err)
+	require.Equal(t, "laptop-1", info.LaptopID)
+	require.Equal(t, int64(7), info.Size)
+
+	_, err = reloaded.Create("../escape", "laptop-1", ".png")
+	require.Error(t, err)
+}
+
+func TestUploadStoreDeleteExpired(t *testing.T) {
+	t.Parallel()
+
+	diskStore, err := service.NewDiskUploadStore(t.TempDir())
+	require.NoError(t, err)
+
+	stores := map[string]service.UploadStore{
+		"memory": service.NewInMemoryUploadStore(),
+		"disk":   diskStore,
+	}
+
+	for name, store := range stores {
+		store := store
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := store.Create("abandoned", "laptop-1", ".jpeg")
+			require.NoError(t, err)
+			_, err = store.Append("abandoned", 0, []byte("hello"))
+			require.NoError(t, err)
+
+			time.Sleep(50 * time.Millisecond)
+			before := time.Now()
+			time.Sleep(50 * time.Millisecond)
+
+			_, err = store.Create("active", "laptop-1", ".jpeg")
+			require.NoError(t, err)
+
+			count, err := store.DeleteExpired(before)
+			require.NoError(t, err)
+			require.Equal(t, 1, count)
+
+			_, err = store.Find("abandoned")
+			require.ErrorIs(t, err, service.ErrNotFound)
+			info, err := store.Find("active")
+			require.NoError(t, err)
+			require.False(t, info.UpdatedAt.Before(before))
+
+			count, err = store.DeleteExpired(before)
+			require.NoError(t, err)
+			require.Equal(t, 0, count)
+
+			// the uploads are removed in the background once they expire
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go service.ExpireUploads(ctx, store, 10*time.Millisecond, 10*time.Millisecond)
+
+			require.Eventually(t, func() bool {
+				_, err := store.Find("active")
+				return err != nil
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestInMemoryUploadStoreLimits(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryUploadStore()
+	store.SetMaxBytes(8)
+	store.SetTTL(50 * time.Millisecond)
+
+	_, err := store.Create("abandoned", "laptop-1", ".jpeg")
+	require.NoError(t, err)
+	_, err = store.Append("abandoned", 0, []byte("hello"))
+	require.NoError(t, err)
+
+	_, err = store.Create("active", "laptop-1", ".jpeg")
+	require.NoError(t, err)
+	_, err = store.Append("active", 0, []byte("world"))
+	require.ErrorIs(t, err, service.ErrUploadStoreFull)
+
+	// the abandoned upload is dropped to make room once it expires
+	time.Sleep(100 * time.Millisecond)
+	size, err := store.Append("active", 0, []byte("world"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), size)
+	_, err = store.Find("abandoned")
+	require.ErrorIs(t, err, service.ErrNotFound)
+}
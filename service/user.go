@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is an account that can log in with AuthService
+type User struct {
+	Username       string
+	HashedPassword string
+	Role           string
+}
+
+func NewUser(username string, password string, role string) (*User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot hash password: %w", err)
+	}
+
+	user := &User{
+		Username:       username,
+		HashedPassword: string(hashedPassword),
+		Role:           role,
+	}
+	return user, nil
+}
+
+func (user *User) IsCorrectPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	return err == nil
+}
+
+var (
+	dummyUserOnce sync.Once
+	dummy         *User
+)
+
+// dummyUser returns a user whose password is checked when the user logging in
+// does not exist, so that the login takes as long as with a wrong password
+func dummyUser() *User {
+	dummyUserOnce.Do(func() {
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		dummy = &User{HashedPassword: string(hashedPassword)}
+	})
+	return dummy
+}
+
+func (user *User) Clone() *User {
+	return &User{
+		Username:       user.Username,
+		HashedPassword: user.HashedPassword,
+		Role:           user.Role,
+	}
+}
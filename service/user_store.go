@@ -0,0 +1,96 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserStore interface {
+	// Save returns ErrAlreadyExists if a user with the same username exists
+	Save(user *User) error
+	Find(username string) (*User, error)
+}
+
+type InMemoryUserStore struct {
+	mutex sync.RWMutex
+	users map[string]*User
+}
+
+func NewInMemoryUserStore() *InMemoryUserStore {
+	return &InMemoryUserStore{
+		users: make(map[string]*User),
+	}
+}
+
+func (store *InMemoryUserStore) Save(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[user.Username] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.users[user.Username] = user.Clone()
+	return nil
+}
+
+func (store *InMemoryUserStore) Find(username string) (*User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	user := store.users[username]
+	if user == nil {
+		return nil, fmt.Errorf("Cannot find the user %s: %w", username, ErrNotFound)
+	}
+
+	return user.Clone(), nil
+}
+
+// LoadUserFile saves the users of a file to the store. Each line of the file
+// is username:bcrypt-hash:role, the first two fields being what
+// `htpasswd -nB username` prints. Empty lines and lines starting with # are
+// skipped
+func LoadUserFile(userStore UserStore, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Cannot open user file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || fields[0] == "" || fields[2] == "" {
+			return fmt.Errorf("Invalid user on line %d of %s: the format is username:bcrypt-hash:role", lineNumber, path)
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			return fmt.Errorf("Invalid password hash on line %d of %s: %w", lineNumber, path, err)
+		}
+
+		user := &User{
+			Username:       fields[0],
+			HashedPassword: fields[1],
+			Role:           fields[2],
+		}
+		err := userStore.Save(user)
+		if err != nil {
+			return fmt.Errorf("Cannot save the user %s: %w", user.Username, err)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("Cannot read user file: %w", err)
+	}
+	return nil
+}
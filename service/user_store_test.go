@@ -0,0 +1,46 @@
+package service_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/keshavbhattad/pcbook/service"
+)
+
+func TestLoadUserFile(t *testing.T) {
+	t.Parallel()
+
+	admin, err := service.NewUser("admin1", "secret", "admin")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users")
+	data := "# users\n\nadmin1:" + admin.HashedPassword + ":admin\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+
+	store := service.NewInMemoryUserStore()
+	err = service.LoadUserFile(store, path)
+	require.NoError(t, err)
+
+	user, err := store.Find("admin1")
+	require.NoError(t, err)
+	require.Equal(t, "admin", user.Role)
+	require.True(t, user.IsCorrectPassword("secret"))
+	require.False(t, user.IsCorrectPassword("wrong"))
+
+	_, err = store.Find("user1")
+	require.ErrorIs(t, err, service.ErrNotFound)
+
+	invalid := []string{
+		"admin1:" + admin.HashedPassword,
+		"admin1:secret:admin",
+		":" + admin.HashedPassword + ":admin",
+	}
+	for _, line := range invalid {
+		require.NoError(t, ioutil.WriteFile(path, []byte(line+"\n"), 0600))
+		err = service.LoadUserFile(service.NewInMemoryUserStore(), path)
+		require.Error(t, err, line)
+	}
+}